@@ -0,0 +1,336 @@
+package config
+
+// All the settings of the site. They are loaded, in order of
+// precedence, from the command line flags (see main.go), environment
+// variables (see env.go), a TOML file (see hk.example.toml), and the
+// defaults in Default().
+//
+// c, err := config.Load("hk.toml", os.Environ())
+// c.Server.Address = *address // flags
+// err = c.Validate()
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/mail"
+	"net/url"
+	"strings"
+
+	"github.com/BurntSushi/toml"
+)
+
+type Config struct {
+	Site     Site     `toml:"site"`
+	Server   Server   `toml:"server"`
+	Database Database `toml:"database"`
+	Auth     Auth     `toml:"auth"`
+	Mail     Mail     `toml:"mail"`
+	Oidc     Oidc     `toml:"oidc"`
+	Security Security `toml:"security"`
+	Photos   Photos   `toml:"photos"`
+	Map      Map      `toml:"map"`
+}
+
+// BaseUrl is the canonical scheme and host of the site (e.g.
+// https://example.com) used in the links that leave the site (feeds,
+// sitemaps, share links, and e-mails). When it is empty the host of
+// each request is used. The links to the hosts in HttpsHosts are
+// upgraded to HTTPS when blogs are saved.
+type Site struct {
+	Name         string   `toml:"name"`
+	BaseUrl      string   `toml:"base_url"`
+	HttpsHosts   []string `toml:"https_hosts"`   // e.g. ["example.com", "www.example.com"]
+	ContactEmail string   `toml:"contact_email"` // shown in the pages, hidden when empty
+}
+
+// Timeouts are in seconds.
+type Server struct {
+	Address           string `toml:"address"`
+	PublicMode        bool   `toml:"public_mode"`
+	LogRoutes         bool   `toml:"log_routes"`
+	ReadHeaderTimeout int    `toml:"read_header_timeout"`
+	ReadTimeout       int    `toml:"read_timeout"`
+	WriteTimeout      int    `toml:"write_timeout"`
+	IdleTimeout       int    `toml:"idle_timeout"`
+	ShutdownTimeout   int    `toml:"shutdown_timeout"`
+	MaxHeaderBytes    int    `toml:"max_header_bytes"`
+	TlsCertFile       string `toml:"tls_cert_file"`
+	TlsKeyFile        string `toml:"tls_key_file"`
+	CleanupMinutes    int    `toml:"cleanup_minutes"`
+}
+
+type Database struct {
+	Driver       string `toml:"driver"`
+	User         string `toml:"user"`
+	Password     string `toml:"password"`
+	Name         string `toml:"name"`
+	MaxOpenConns int    `toml:"max_open_conns"`
+	MaxIdleConns int    `toml:"max_idle_conns"`
+}
+
+type Auth struct {
+	AdminUser            string   `toml:"admin_user"`
+	AdminPassword        string   `toml:"admin_password"`
+	GuestUser            string   `toml:"guest_user"`
+	GuestPassword        string   `toml:"guest_password"`
+	LegacySalt           string   `toml:"legacy_salt"`
+	CsrfSecret           string   `toml:"csrf_secret"`
+	LinkSecret           string   `toml:"link_secret"`
+	LoginAttemptsStore   string   `toml:"login_attempts_store"` // memory or db
+	PasswordResetMinutes int      `toml:"password_reset_minutes"`
+	Require2fa           []string `toml:"require_2fa"` // user types, e.g. ["admin"]
+
+	// By user type, e.g. [auth.sessions.admin]
+	Sessions map[string]Session `toml:"sessions"`
+}
+
+// Values that are not set use the default for the user type. An
+// IdleHours of zero means no idle timeout.
+type Session struct {
+	Days      *int `toml:"days"`
+	IdleHours *int `toml:"idle_hours"`
+}
+
+type Mail struct {
+	Mailer       string `toml:"mailer"` // smtp, file, or stdout
+	From         string `toml:"from"`
+	Folder       string `toml:"folder"`
+	SmtpHost     string `toml:"smtp_host"`
+	SmtpPort     string `toml:"smtp_port"`
+	SmtpUser     string `toml:"smtp_user"`
+	SmtpPassword string `toml:"smtp_password"`
+}
+
+// OpenID Connect login, disabled when Issuer is empty.
+type Oidc struct {
+	Issuer       string `toml:"issuer"`
+	ClientId     string `toml:"client_id"`
+	ClientSecret string `toml:"client_secret"`
+	RedirectUrl  string `toml:"redirect_url"`
+	Name         string `toml:"name"`
+}
+
+type Security struct {
+	Csp            string   `toml:"csp"` // replaces the default policy
+	CspImgSrc      []string `toml:"csp_img_src"`
+	FrameOptions   string   `toml:"frame_options"`
+	ReferrerPolicy string   `toml:"referrer_policy"`
+	HstsMaxAge     int      `toml:"hsts_max_age"`
+}
+
+// When Folder is set the photos are served by the site under /photos/,
+// otherwise they are served by another web server under /MaskedPath/.
+type Photos struct {
+	Folder     string `toml:"folder"`
+	MaskedPath string `toml:"masked_path"`
+}
+
+// The tile server for the maps, e.g. a local one when the site runs
+// without Internet access.
+type Map struct {
+	TileUrl         string `toml:"tile_url"` // e.g. https://tile.openstreetmap.org/{z}/{x}/{y}.png
+	TileAttribution string `toml:"tile_attribution"`
+}
+
+const masked = "***"
+
+func Default() Config {
+	return Config{
+		Site: Site{
+			Name:       "hk",
+			HttpsHosts: []string{},
+		},
+		Server: Server{
+			Address:           "localhost:9001",
+			ReadHeaderTimeout: 10,
+			ReadTimeout:       30,
+			WriteTimeout:      120,
+			IdleTimeout:       120,
+			ShutdownTimeout:   30,
+			MaxHeaderBytes:    64 * 1024,
+			CleanupMinutes:    60,
+		},
+		Database: Database{
+			Driver:       "mysql",
+			User:         "root",
+			Name:         "hkdb",
+			MaxOpenConns: 20,
+			MaxIdleConns: 5,
+		},
+		Auth: Auth{
+			AdminUser:            "user1",
+			AdminPassword:        "welcome1",
+			GuestUser:            "user2",
+			GuestPassword:        "welcome2",
+			LoginAttemptsStore:   "memory",
+			PasswordResetMinutes: 60,
+			Require2fa:           []string{},
+			Sessions:             map[string]Session{},
+		},
+		Mail: Mail{
+			Mailer:   "stdout",
+			From:     "noreply@localhost",
+			SmtpHost: "localhost",
+			SmtpPort: "25",
+		},
+		Oidc: Oidc{
+			Name: "your account",
+		},
+		Security: Security{
+			CspImgSrc:      []string{},
+			FrameOptions:   "DENY",
+			ReferrerPolicy: "strict-origin-when-cross-origin",
+		},
+		Map: Map{
+			TileUrl:         "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+			TileAttribution: "© OpenStreetMap contributors",
+		},
+	}
+}
+
+// Returns the defaults overridden by the values in the file (if path
+// is not empty) and then by the environment variables.
+func Load(path string, environ []string) (Config, error) {
+	c := Default()
+	if path != "" {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return c, err
+		}
+		if err := Parse(string(data), &c); err != nil {
+			return c, fmt.Errorf("%s: %s", path, err)
+		}
+	}
+	if err := applyEnv(&c, environ); err != nil {
+		return c, err
+	}
+	c.Site.BaseUrl = strings.TrimSuffix(c.Site.BaseUrl, "/")
+	c.Photos.MaskedPath = strings.Trim(c.Photos.MaskedPath, "/")
+	return c, nil
+}
+
+// Overrides the values in c with the ones in the TOML text. Unknown
+// keys are reported as errors since they are most likely typos.
+func Parse(text string, c *Config) error {
+	meta, err := toml.Decode(text, c)
+	if err != nil {
+		return err
+	}
+	if undecoded := meta.Undecoded(); len(undecoded) > 0 {
+		keys := []string{}
+		for _, key := range undecoded {
+			keys = append(keys, key.String())
+		}
+		return fmt.Errorf("unknown settings: %s", strings.Join(keys, ", "))
+	}
+	return nil
+}
+
+// Returns an error describing all the invalid settings (if any).
+func (c Config) Validate() error {
+	problems := []string{}
+	check := func(valid bool, problem string) {
+		if !valid {
+			problems = append(problems, problem)
+		}
+	}
+
+	check(c.Site.Name != "", "site.name is required")
+	check(c.Site.BaseUrl == "" || validBaseUrl(c.Site.BaseUrl),
+		"site.base_url must be an http:// or https:// URL without a path (e.g. https://example.com)")
+	check(c.Site.ContactEmail == "" || validEmail(c.Site.ContactEmail),
+		"site.contact_email must be an e-mail address (e.g. someone@example.com)")
+
+	check(c.Server.Address != "", "server.address is required")
+	check(c.Server.ReadHeaderTimeout >= 0 && c.Server.ReadTimeout >= 0 &&
+		c.Server.WriteTimeout >= 0 && c.Server.IdleTimeout >= 0 &&
+		c.Server.ShutdownTimeout >= 0, "server timeouts cannot be negative")
+	check(c.Server.MaxHeaderBytes > 0, "server.max_header_bytes must be greater than zero")
+	check((c.Server.TlsCertFile == "") == (c.Server.TlsKeyFile == ""),
+		"server.tls_cert_file and server.tls_key_file must be set together")
+	check(c.Server.CleanupMinutes >= 0, "server.cleanup_minutes cannot be negative")
+
+	check(c.Database.Driver != "", "database.driver is required")
+	check(c.Database.Name != "", "database.name is required")
+	check(c.Database.MaxOpenConns > 0, "database.max_open_conns must be greater than zero")
+	check(c.Database.MaxIdleConns >= 0, "database.max_idle_conns cannot be negative")
+
+	check(c.Auth.AdminUser != "" && c.Auth.AdminPassword != "", "auth.admin_user and auth.admin_password are required")
+	check(c.Auth.LoginAttemptsStore == "memory" || c.Auth.LoginAttemptsStore == "db",
+		"auth.login_attempts_store must be memory or db")
+	check(c.Auth.PasswordResetMinutes > 0, "auth.password_reset_minutes must be greater than zero")
+	for userType, session := range c.Auth.Sessions {
+		check((session.Days == nil || *session.Days > 0) && (session.IdleHours == nil || *session.IdleHours >= 0),
+			fmt.Sprintf("auth.sessions.%s has invalid values", userType))
+	}
+
+	check(c.Mail.Mailer == "smtp" || c.Mail.Mailer == "file" || c.Mail.Mailer == "stdout",
+		"mail.mailer must be smtp, file, or stdout")
+	check(c.Mail.From != "", "mail.from is required")
+
+	check(c.Oidc.Issuer == "" || c.Oidc.ClientId != "", "oidc.client_id is required when oidc.issuer is set")
+	check(c.Security.HstsMaxAge >= 0, "security.hsts_max_age cannot be negative")
+	check(!strings.Contains(strings.Trim(c.Photos.MaskedPath, "/"), "/"),
+		"photos.masked_path must be a single folder name (e.g. photos-abc123)")
+	check(c.Map.TileUrl != "", "map.tile_url is required")
+
+	if len(problems) > 0 {
+		return errors.New("Invalid configuration: " + strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func validBaseUrl(value string) bool {
+	u, err := url.Parse(value)
+	if err != nil {
+		return false
+	}
+	return (u.Scheme == "http" || u.Scheme == "https") && u.Host != "" &&
+		u.Path == "" && u.RawQuery == "" && u.Fragment == ""
+}
+
+func validEmail(value string) bool {
+	address, err := mail.ParseAddress(value)
+	return err == nil && address.Address == value
+}
+
+// Returns true if users of the given type must use two-factor
+// authentication.
+func (a Auth) Requires2fa(userType string) bool {
+	for _, t := range a.Require2fa {
+		if strings.EqualFold(t, userType) {
+			return true
+		}
+	}
+	return false
+}
+
+// Same configuration with the passwords and secrets masked, safe to
+// log or print.
+func (c Config) Masked() Config {
+	mask := func(value *string) {
+		if *value != "" {
+			*value = masked
+		}
+	}
+	mask(&c.Database.Password)
+	mask(&c.Auth.AdminPassword)
+	mask(&c.Auth.GuestPassword)
+	mask(&c.Auth.LegacySalt)
+	mask(&c.Auth.CsrfSecret)
+	mask(&c.Auth.LinkSecret)
+	mask(&c.Mail.SmtpPassword)
+	mask(&c.Oidc.ClientSecret)
+	return c
+}
+
+// The configuration in TOML with the secrets masked.
+func (c Config) String() string {
+	var buffer bytes.Buffer
+	if err := toml.NewEncoder(&buffer).Encode(c.Masked()); err != nil {
+		return err.Error()
+	}
+	return buffer.String()
+}
@@ -0,0 +1,192 @@
+package config
+
+import (
+	"io/ioutil"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	text := `
+[server]
+address = "0.0.0.0:8080"
+public_mode = true
+
+[auth]
+require_2fa = ["admin", "editor"]
+
+[auth.sessions.admin]
+idle_hours = 0
+
+[security]
+csp_img_src = ["https://photos.example.com"]
+`
+	c := Default()
+	if err := Parse(text, &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.Server.Address != "0.0.0.0:8080" || !c.Server.PublicMode {
+		t.Errorf("Server settings not parsed: %#v", c.Server)
+	}
+	if c.Server.ReadTimeout != 30 || c.Database.Name != "hkdb" {
+		t.Errorf("Defaults should be kept for the values not in the file")
+	}
+	if !c.Auth.Requires2fa("admin") || !c.Auth.Requires2fa("Editor") || c.Auth.Requires2fa("family") {
+		t.Errorf("Unexpected require_2fa: %v", c.Auth.Require2fa)
+	}
+	admin := c.Auth.Sessions["admin"]
+	if admin.Days != nil || admin.IdleHours == nil || *admin.IdleHours != 0 {
+		t.Errorf("Unexpected admin session: %#v", admin)
+	}
+	if len(c.Security.CspImgSrc) != 1 {
+		t.Errorf("Unexpected csp_img_src: %v", c.Security.CspImgSrc)
+	}
+}
+
+func TestParseUnknownKeys(t *testing.T) {
+	c := Default()
+	err := Parse("[server]\nadress = \"localhost:80\"\n", &c)
+	if err == nil || !strings.Contains(err.Error(), "server.adress") {
+		t.Errorf("Unknown key not reported: %v", err)
+	}
+
+	err = Parse("[server]\naddress = 80\n", &c)
+	if err == nil {
+		t.Errorf("Wrong type not reported")
+	}
+}
+
+func TestExampleFile(t *testing.T) {
+	data, err := ioutil.ReadFile("../hk.example.toml")
+	if err != nil {
+		t.Fatal(err)
+	}
+	c := Default()
+	if err := Parse(string(data), &c); err != nil {
+		t.Fatal(err)
+	}
+	if c.String() != Default().String() {
+		t.Errorf("The example file does not match the defaults:\n%s", c)
+	}
+}
+
+func TestEnv(t *testing.T) {
+	environ := []string{
+		"DB_NAME=otherdb",
+		"PUBLIC_MODE=yes",
+		"HTTP_WRITE_TIMEOUT=5",
+		"CSP_IMG_SRC=https://a.example.com https://b.example.com",
+		"SESSION_DAYS_FAMILY=7",
+		"SESSION_IDLE_HOURS_ADMIN=0",
+		"REQUIRE_2FA_ADMIN=true",
+		"MAIL_FROM=",
+		"SITE_NAME=The Smiths",
+		"HTTPS_HOSTS=example.com www.example.com",
+		"CONTACT_EMAIL=smiths@example.com",
+		"PHOTO_MASKED_PATH=photos-abc",
+		"MAP_TILE_URL=http://localhost:8080/tiles/{z}/{x}/{y}.png",
+		"NOT_A_SETTING=1",
+	}
+	c := Default()
+	if err := Parse("[auth.sessions.admin]\ndays = 10\n", &c); err != nil {
+		t.Fatal(err)
+	}
+	if err := applyEnv(&c, environ); err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Database.Name != "otherdb" || !c.Server.PublicMode || c.Server.WriteTimeout != 5 {
+		t.Errorf("Environment variables not applied: %#v", c)
+	}
+	if c.Site.Name != "The Smiths" || len(c.Site.HttpsHosts) != 2 || c.Site.ContactEmail != "smiths@example.com" {
+		t.Errorf("Unexpected site settings: %#v", c.Site)
+	}
+	if c.Photos.MaskedPath != "photos-abc" || c.Map.TileUrl != "http://localhost:8080/tiles/{z}/{x}/{y}.png" {
+		t.Errorf("Unexpected photo or map settings: %#v %#v", c.Photos, c.Map)
+	}
+	if len(c.Security.CspImgSrc) != 2 {
+		t.Errorf("Unexpected CSP_IMG_SRC: %v", c.Security.CspImgSrc)
+	}
+	if c.Mail.From != "noreply@localhost" {
+		t.Errorf("Empty variables should be ignored: %s", c.Mail.From)
+	}
+
+	family := c.Auth.Sessions["family"]
+	if family.Days == nil || *family.Days != 7 || family.IdleHours != nil {
+		t.Errorf("Unexpected family session: %#v", family)
+	}
+	admin := c.Auth.Sessions["admin"]
+	if admin.Days == nil || *admin.Days != 10 || admin.IdleHours == nil || *admin.IdleHours != 0 {
+		t.Errorf("Unexpected admin session: %#v", admin)
+	}
+	if !reflect.DeepEqual(c.Auth.Require2fa, []string{"admin"}) {
+		t.Errorf("Unexpected require 2FA: %v", c.Auth.Require2fa)
+	}
+
+	err := applyEnv(&c, []string{"REQUIRE_2FA_ADMIN=false", "HTTP_IDLE_TIMEOUT=two"})
+	if err == nil || !strings.Contains(err.Error(), "HTTP_IDLE_TIMEOUT") {
+		t.Errorf("Invalid number not reported: %v", err)
+	}
+	if c.Auth.Requires2fa("admin") {
+		t.Errorf("REQUIRE_2FA_ADMIN=false should remove the user type")
+	}
+}
+
+func TestLoadBaseUrl(t *testing.T) {
+	c, err := Load("", []string{"BASE_URL=https://example.com/"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Site.BaseUrl != "https://example.com" {
+		t.Errorf("The trailing slash should be removed: %s", c.Site.BaseUrl)
+	}
+	if err := c.Validate(); err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestValidate(t *testing.T) {
+	if err := Default().Validate(); err != nil {
+		t.Errorf("The defaults should be valid: %s", err)
+	}
+
+	c := Default()
+	c.Site.BaseUrl = "https://example.com/blog"
+	c.Site.ContactEmail = "not an address"
+	c.Server.TlsCertFile = "cert.pem"
+	c.Database.MaxOpenConns = 0
+	c.Mail.Mailer = "pigeon"
+	c.Photos.MaskedPath = "photos/abc"
+	c.Map.TileUrl = ""
+	err := c.Validate()
+	if err == nil {
+		t.Fatal("Invalid configuration not reported")
+	}
+	for _, key := range []string{"site.base_url", "site.contact_email", "server.tls_key_file", "database.max_open_conns", "mail.mailer",
+		"photos.masked_path", "map.tile_url"} {
+		if !strings.Contains(err.Error(), key) {
+			t.Errorf("Problem with %s not reported: %s", key, err)
+		}
+	}
+}
+
+func TestMasked(t *testing.T) {
+	c := Default()
+	c.Database.Password = "db-secret"
+	c.Auth.LinkSecret = "link-secret"
+	c.Oidc.ClientSecret = "oidc-secret"
+
+	text := c.String()
+	for _, secret := range []string{"db-secret", "link-secret", "oidc-secret", "welcome1"} {
+		if strings.Contains(text, secret) {
+			t.Errorf("Secret %s not masked:\n%s", secret, text)
+		}
+	}
+	if !strings.Contains(text, `address = "localhost:9001"`) {
+		t.Errorf("Settings missing:\n%s", text)
+	}
+	if c.Database.Password != "db-secret" {
+		t.Errorf("Masking should not change the original")
+	}
+}
@@ -0,0 +1,202 @@
+package config
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Environment variables that override the settings. The names are the
+// ones that were used before the configuration file existed so that
+// existing deployments keep working.
+func applyEnv(c *Config, environ []string) error {
+	e := newEnvReader(environ)
+
+	e.str("SITE_NAME", &c.Site.Name)
+	e.str("BASE_URL", &c.Site.BaseUrl)
+	e.list("HTTPS_HOSTS", &c.Site.HttpsHosts)
+	e.str("CONTACT_EMAIL", &c.Site.ContactEmail)
+
+	e.str("ADDRESS", &c.Server.Address)
+	e.boolean("PUBLIC_MODE", &c.Server.PublicMode)
+	e.boolean("LOG_ROUTES", &c.Server.LogRoutes)
+	e.integer("HTTP_READ_HEADER_TIMEOUT", &c.Server.ReadHeaderTimeout)
+	e.integer("HTTP_READ_TIMEOUT", &c.Server.ReadTimeout)
+	e.integer("HTTP_WRITE_TIMEOUT", &c.Server.WriteTimeout)
+	e.integer("HTTP_IDLE_TIMEOUT", &c.Server.IdleTimeout)
+	e.integer("SHUTDOWN_TIMEOUT", &c.Server.ShutdownTimeout)
+	e.integer("HTTP_MAX_HEADER_BYTES", &c.Server.MaxHeaderBytes)
+	e.str("TLS_CERT_FILE", &c.Server.TlsCertFile)
+	e.str("TLS_KEY_FILE", &c.Server.TlsKeyFile)
+	e.integer("CLEANUP_MINUTES", &c.Server.CleanupMinutes)
+
+	e.str("DB_DRIVER", &c.Database.Driver)
+	e.str("DB_USER", &c.Database.User)
+	e.str("DB_PASSWORD", &c.Database.Password)
+	e.str("DB_NAME", &c.Database.Name)
+	e.integer("DB_MAX_OPEN_CONNS", &c.Database.MaxOpenConns)
+	e.integer("DB_MAX_IDLE_CONNS", &c.Database.MaxIdleConns)
+
+	e.str("BLOG_USR", &c.Auth.AdminUser)
+	e.str("BLOG_PASS", &c.Auth.AdminPassword)
+	e.str("BLOG_GUEST_USR", &c.Auth.GuestUser)
+	e.str("BLOG_GUEST_PASS", &c.Auth.GuestPassword)
+	e.str("BLOG_SALT", &c.Auth.LegacySalt)
+	e.str("CSRF_SECRET", &c.Auth.CsrfSecret)
+	e.str("LINK_SECRET", &c.Auth.LinkSecret)
+	e.str("LOGIN_ATTEMPTS_STORE", &c.Auth.LoginAttemptsStore)
+	e.integer("PASSWORD_RESET_MINUTES", &c.Auth.PasswordResetMinutes)
+	e.perUserType(c)
+
+	e.str("MAILER", &c.Mail.Mailer)
+	e.str("MAIL_FROM", &c.Mail.From)
+	e.str("MAIL_FOLDER", &c.Mail.Folder)
+	e.str("SMTP_HOST", &c.Mail.SmtpHost)
+	e.str("SMTP_PORT", &c.Mail.SmtpPort)
+	e.str("SMTP_USER", &c.Mail.SmtpUser)
+	e.str("SMTP_PASSWORD", &c.Mail.SmtpPassword)
+
+	e.str("OIDC_ISSUER", &c.Oidc.Issuer)
+	e.str("OIDC_CLIENT_ID", &c.Oidc.ClientId)
+	e.str("OIDC_CLIENT_SECRET", &c.Oidc.ClientSecret)
+	e.str("OIDC_REDIRECT_URL", &c.Oidc.RedirectUrl)
+	e.str("OIDC_NAME", &c.Oidc.Name)
+
+	e.str("CSP", &c.Security.Csp)
+	e.list("CSP_IMG_SRC", &c.Security.CspImgSrc)
+	e.str("FRAME_OPTIONS", &c.Security.FrameOptions)
+	e.str("REFERRER_POLICY", &c.Security.ReferrerPolicy)
+	e.integer("HSTS_MAX_AGE", &c.Security.HstsMaxAge)
+
+	e.str("PHOTO_FOLDER", &c.Photos.Folder)
+	e.str("PHOTO_MASKED_PATH", &c.Photos.MaskedPath)
+
+	e.str("MAP_TILE_URL", &c.Map.TileUrl)
+	e.str("MAP_TILE_ATTRIBUTION", &c.Map.TileAttribution)
+
+	if len(e.problems) > 0 {
+		return fmt.Errorf("Invalid environment variables: %s", strings.Join(e.problems, "; "))
+	}
+	return nil
+}
+
+type envReader struct {
+	values   map[string]string
+	problems []string
+}
+
+// The environment is in the same format as os.Environ(), i.e. a list
+// of "key=value" strings.
+func newEnvReader(environ []string) *envReader {
+	e := envReader{values: map[string]string{}}
+	for _, variable := range environ {
+		if i := strings.Index(variable, "="); i > 0 {
+			e.values[variable[0:i]] = variable[i+1:]
+		}
+	}
+	return &e
+}
+
+// Empty values are ignored, same as when the variable is not set.
+func (e *envReader) value(key string) (string, bool) {
+	value := e.values[key]
+	return value, value != ""
+}
+
+func (e *envReader) str(key string, target *string) {
+	if value, ok := e.value(key); ok {
+		*target = value
+	}
+}
+
+func (e *envReader) boolean(key string, target *bool) {
+	if value, ok := e.value(key); ok {
+		value = strings.ToLower(value)
+		*target = value == "true" || value == "yes" || value == "1"
+	}
+}
+
+func (e *envReader) integer(key string, target *int) {
+	if value, ok := e.value(key); ok {
+		number, err := strconv.Atoi(value)
+		if err != nil {
+			e.problems = append(e.problems, fmt.Sprintf("%s is not a number (%s)", key, value))
+			return
+		}
+		*target = number
+	}
+}
+
+func (e *envReader) intPointer(key string, target **int) {
+	if _, ok := e.value(key); ok {
+		number := 0
+		if *target != nil {
+			number = **target
+		}
+		e.integer(key, &number)
+		*target = &number
+	}
+}
+
+// Space separated values.
+func (e *envReader) list(key string, target *[]string) {
+	if value, ok := e.value(key); ok {
+		*target = strings.Fields(value)
+	}
+}
+
+// SESSION_DAYS_<TYPE>, SESSION_IDLE_HOURS_<TYPE>, and REQUIRE_2FA_<TYPE>
+// (e.g. SESSION_DAYS_ADMIN=30 or REQUIRE_2FA_ADMIN=true).
+func (e *envReader) perUserType(c *Config) {
+	for _, userType := range e.userTypes("SESSION_DAYS_", "SESSION_IDLE_HOURS_", "REQUIRE_2FA_") {
+		suffix := strings.ToUpper(userType)
+		if c.Auth.Sessions == nil {
+			c.Auth.Sessions = map[string]Session{}
+		}
+		session := c.Auth.Sessions[userType]
+		e.intPointer("SESSION_DAYS_"+suffix, &session.Days)
+		e.intPointer("SESSION_IDLE_HOURS_"+suffix, &session.IdleHours)
+		if session.Days != nil || session.IdleHours != nil {
+			c.Auth.Sessions[userType] = session
+		}
+
+		required := c.Auth.Requires2fa(userType)
+		e.boolean("REQUIRE_2FA_"+suffix, &required)
+		c.Auth.Require2fa = setUserType(c.Auth.Require2fa, userType, required)
+	}
+}
+
+// User types mentioned in the environment variables with the given
+// prefixes (in lower case).
+func (e *envReader) userTypes(prefixes ...string) []string {
+	types := []string{}
+	seen := map[string]bool{}
+	for key := range e.values {
+		for _, prefix := range prefixes {
+			if strings.HasPrefix(key, prefix) && len(key) > len(prefix) {
+				userType := strings.ToLower(key[len(prefix):])
+				if !seen[userType] {
+					seen[userType] = true
+					types = append(types, userType)
+				}
+			}
+		}
+	}
+	sort.Strings(types)
+	return types
+}
+
+// Adds or removes the user type from the list.
+func setUserType(types []string, userType string, include bool) []string {
+	result := []string{}
+	for _, t := range types {
+		if !strings.EqualFold(t, userType) {
+			result = append(result, t)
+		}
+	}
+	if include {
+		result = append(result, userType)
+	}
+	return result
+}
@@ -2,4 +2,9 @@ module hectorcorrea.com/hk
 
 go 1.14
 
-require github.com/go-sql-driver/mysql v1.5.0
+require (
+	github.com/BurntSushi/toml v0.3.0
+	github.com/go-sql-driver/mysql v1.5.0
+	github.com/skip2/go-qrcode v0.0.0-20200617195104-da1b6568686e
+	golang.org/x/crypto v0.0.0-20200622213623-75b288015ac9
+)
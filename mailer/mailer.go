@@ -0,0 +1,139 @@
+package mailer
+
+// Sends e-mail messages (e.g. password reset links). The mailer to use
+// is configured in the [mail] section of the configuration:
+//
+//	mailer = "smtp"    smtp_host, smtp_port, smtp_user, smtp_password
+//	mailer = "file"    folder (one .eml file per message)
+//	mailer = "stdout"  (default) prints the messages to the console
+//
+// from is the sender for all of them.
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/smtp"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"hectorcorrea.com/hk/config"
+)
+
+type Message struct {
+	From    string
+	To      string
+	Subject string
+	Body    string // plain text
+}
+
+type Mailer interface {
+	Send(msg Message) error
+}
+
+// Returns the mailer indicated in the configuration.
+func New(c config.Mail) Mailer {
+	switch c.Mailer {
+	case "smtp":
+		return SmtpMailer{
+			Host:     c.SmtpHost,
+			Port:     c.SmtpPort,
+			User:     c.SmtpUser,
+			Password: c.SmtpPassword,
+			From:     c.From,
+		}
+	case "file":
+		folder := c.Folder
+		if folder == "" {
+			folder = os.TempDir()
+		}
+		return FileMailer{Folder: folder, From: c.From}
+	default:
+		return WriterMailer{Writer: os.Stdout, From: c.From}
+	}
+}
+
+// Sends the messages through an SMTP server. Authentication is only
+// used when User is set.
+type SmtpMailer struct {
+	Host     string
+	Port     string
+	User     string
+	Password string
+	From     string
+}
+
+func (m SmtpMailer) Send(msg Message) error {
+	msg = withFrom(msg, m.From)
+	var auth smtp.Auth
+	if m.User != "" {
+		auth = smtp.PlainAuth("", m.User, m.Password, m.Host)
+	}
+	address := m.Host + ":" + m.Port
+	return smtp.SendMail(address, auth, msg.From, []string{msg.To}, msg.Bytes())
+}
+
+// Saves each message to a file in Folder, useful for local testing.
+type FileMailer struct {
+	Folder string
+	From   string
+}
+
+func (m FileMailer) Send(msg Message) error {
+	msg = withFrom(msg, m.From)
+	name := fmt.Sprintf("%s-%s.eml", time.Now().UTC().Format("20060102-150405.000000"), safeName(msg.To))
+	return ioutil.WriteFile(filepath.Join(m.Folder, name), msg.Bytes(), 0600)
+}
+
+// Writes the messages to Writer (e.g. os.Stdout).
+type WriterMailer struct {
+	Writer io.Writer
+	From   string
+}
+
+func (m WriterMailer) Send(msg Message) error {
+	msg = withFrom(msg, m.From)
+	_, err := m.Writer.Write(msg.Bytes())
+	return err
+}
+
+// The message in RFC 5322 format.
+func (msg Message) Bytes() []byte {
+	var buffer bytes.Buffer
+	fmt.Fprintf(&buffer, "From: %s\r\n", headerValue(msg.From))
+	fmt.Fprintf(&buffer, "To: %s\r\n", headerValue(msg.To))
+	fmt.Fprintf(&buffer, "Subject: %s\r\n", headerValue(msg.Subject))
+	fmt.Fprintf(&buffer, "Date: %s\r\n", time.Now().Format(time.RFC1123Z))
+	buffer.WriteString("MIME-Version: 1.0\r\n")
+	buffer.WriteString("Content-Type: text/plain; charset=utf-8\r\n")
+	buffer.WriteString("\r\n")
+	body := strings.Replace(msg.Body, "\r\n", "\n", -1)
+	buffer.WriteString(strings.Replace(body, "\n", "\r\n", -1))
+	buffer.WriteString("\r\n")
+	return buffer.Bytes()
+}
+
+func withFrom(msg Message, from string) Message {
+	if msg.From == "" {
+		msg.From = from
+	}
+	return msg
+}
+
+// Prevents header injection via values that include new lines.
+func headerValue(value string) string {
+	value = strings.Replace(value, "\r", " ", -1)
+	return strings.Replace(value, "\n", " ", -1)
+}
+
+func safeName(value string) string {
+	return strings.Map(func(r rune) rune {
+		if (r >= 'a' && r <= 'z') || (r >= 'A' && r <= 'Z') || (r >= '0' && r <= '9') || r == '.' || r == '-' {
+			return r
+		}
+		return '_'
+	}, value)
+}
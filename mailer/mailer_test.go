@@ -0,0 +1,118 @@
+package mailer
+
+import (
+	"bufio"
+	"bytes"
+	"io/ioutil"
+	"net"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestMessageBytes(t *testing.T) {
+	msg := Message{From: "a@b.com", To: "c@d.com\r\nBcc: e@f.com", Subject: "Hello", Body: "line 1\nline 2"}
+	text := string(msg.Bytes())
+	if !strings.Contains(text, "To: c@d.com  Bcc: e@f.com\r\n") {
+		t.Errorf("New lines in headers were not removed: %s", text)
+	}
+	if !strings.HasSuffix(text, "\r\n\r\nline 1\r\nline 2\r\n") {
+		t.Errorf("Unexpected body: %s", text)
+	}
+}
+
+func TestWriterMailer(t *testing.T) {
+	var buffer bytes.Buffer
+	mailer := WriterMailer{Writer: &buffer, From: "noreply@localhost"}
+	if err := mailer.Send(Message{To: "c@d.com", Subject: "Hello", Body: "Hi"}); err != nil {
+		t.Fatalf("Error sending: %s", err)
+	}
+	if !strings.Contains(buffer.String(), "From: noreply@localhost\r\n") {
+		t.Errorf("Default From not used: %s", buffer.String())
+	}
+}
+
+func TestFileMailer(t *testing.T) {
+	folder, err := ioutil.TempDir("", "mailer")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	mailer := FileMailer{Folder: folder, From: "noreply@localhost"}
+	if err := mailer.Send(Message{To: "c@d.com", Subject: "Hello", Body: "Hi"}); err != nil {
+		t.Fatalf("Error sending: %s", err)
+	}
+	files, _ := ioutil.ReadDir(folder)
+	if len(files) != 1 || !strings.HasSuffix(files[0].Name(), "-c_d.com.eml") {
+		t.Errorf("Unexpected files: %v", files)
+	}
+}
+
+// Minimal SMTP server that accepts a single message.
+func smtpCatcher(listener net.Listener, received chan string) {
+	conn, err := listener.Accept()
+	if err != nil {
+		received <- ""
+		return
+	}
+	defer conn.Close()
+
+	reader := bufio.NewReader(conn)
+	reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+	reply("220 localhost")
+	data := ""
+	inData := false
+	for {
+		line, err := reader.ReadString('\n')
+		if err != nil {
+			received <- data
+			return
+		}
+		if inData {
+			if line == ".\r\n" {
+				inData = false
+				reply("250 OK")
+				continue
+			}
+			data += line
+			continue
+		}
+		command := strings.ToUpper(strings.TrimSpace(line))
+		switch {
+		case strings.HasPrefix(command, "EHLO"), strings.HasPrefix(command, "HELO"):
+			reply("250 localhost")
+		case command == "DATA":
+			inData = true
+			reply("354 Go ahead")
+		case command == "QUIT":
+			reply("221 Bye")
+			received <- data
+			return
+		default:
+			reply("250 OK")
+		}
+	}
+}
+
+func TestSmtpMailer(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	received := make(chan string, 1)
+	go smtpCatcher(listener, received)
+
+	host, port, _ := net.SplitHostPort(listener.Addr().String())
+	mailer := SmtpMailer{Host: host, Port: port, From: "noreply@localhost"}
+	if err := mailer.Send(Message{To: "c@d.com", Subject: "Hello", Body: "Hi there"}); err != nil {
+		t.Fatalf("Error sending: %s", err)
+	}
+
+	data := <-received
+	if !strings.Contains(data, "Subject: Hello\r\n") || !strings.Contains(data, "Hi there") {
+		t.Errorf("Unexpected message received: %s", data)
+	}
+}
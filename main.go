@@ -2,28 +2,55 @@ package main
 
 import (
 	"flag"
+	"log"
+	"os"
 
+	"hectorcorrea.com/hk/config"
 	"hectorcorrea.com/hk/tasks"
 	"hectorcorrea.com/hk/web"
 )
 
 func main() {
-	var address = flag.String("address", "localhost:9001", "Address where server will listen for connections.")
+	var configFile = flag.String("config", "", "Path to the TOML configuration file (see hk.example.toml).")
+	var address = flag.String("address", "", "Address where server will listen for connections (overrides server.address).")
+	var printConfig = flag.Bool("printConfig", false, "Print the configuration (with the secrets masked) and exit.")
 	var resave = flag.String("resave", "", "Pass \"yes\" to resave all blog posts and recalculate the HTML content.")
 	var scan = flag.String("scan", "", "Pass full path to folder to scan for photos that need to be added to the database.")
-	var addUser = flag.String("addUser", "", "Adds a new guest user/password")
+	var scanDryRun = flag.Bool("scanDryRun", false, "Report what -scan would do without updating the database.")
+	var scanWorkers = flag.Int("scanWorkers", 0, "Number of files -scan hashes in parallel (defaults to the number of CPUs).")
+	var scanForce = flag.Bool("scanForce", false, "Make -scan re-process all files, even the ones that have not changed.")
+	var scanRewrite = flag.Bool("scanRewrite", false, "Update the blogs that reference photos that -scan detects as moved.")
+	var addUser = flag.String("addUser", "", "Adds a new user/password (with the family role)")
 	flag.Parse()
 
+	c, err := config.Load(*configFile, os.Environ())
+	if err != nil {
+		log.Fatal(err)
+	}
+	if *address != "" {
+		c.Server.Address = *address
+	}
+	if err := c.Validate(); err != nil {
+		log.Fatal(err)
+	}
+
+	if *printConfig {
+		os.Stdout.WriteString(c.String())
+		return
+	}
+	log.Printf("Configuration:\n%s", c)
+
 	if *resave == "yes" {
-		tasks.ResaveAll()
+		tasks.ResaveAll(c)
 		return
 	} else if *scan != "" {
-		tasks.ScanPhotos(*scan)
+		options := tasks.ScanOptions{DryRun: *scanDryRun, RewriteContent: *scanRewrite, Workers: *scanWorkers, Force: *scanForce}
+		tasks.ScanPhotos(c, *scan, options)
 		return
 	} else if *addUser != "" {
-		tasks.AddUser(*addUser)
+		tasks.AddUser(c, *addUser)
 		return
 	}
 
-	web.StartWebServer(*address)
+	web.StartWebServer(c)
 }
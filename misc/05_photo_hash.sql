@@ -0,0 +1,4 @@
+USE hkdb;
+
+ALTER TABLE photos ADD COLUMN hash CHAR(64) NULL;
+CREATE INDEX photos_index_hash ON photos(hash);
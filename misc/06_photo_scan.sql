@@ -0,0 +1,4 @@
+USE hkdb;
+
+ALTER TABLE photos ADD COLUMN size BIGINT NULL;
+ALTER TABLE photos ADD COLUMN modifiedOn DATETIME NULL;
@@ -0,0 +1,11 @@
+USE hkdb;
+
+ALTER TABLE photos ADD COLUMN url VARCHAR(255) NULL;
+ALTER TABLE photos ADD COLUMN latitude DOUBLE NULL;
+ALTER TABLE photos ADD COLUMN longitude DOUBLE NULL;
+CREATE INDEX photos_index_url ON photos(url);
+CREATE INDEX blogs_photos_index_path ON blogs_photos(path);
+
+/* Tile server used by the maps, {z}/{x}/{y} are replaced by the tile coordinates */
+ALTER TABLE settings ADD COLUMN tileUrl VARCHAR(255) NULL;
+ALTER TABLE settings ADD COLUMN tileAttribution VARCHAR(255) NULL;
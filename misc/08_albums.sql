@@ -0,0 +1,22 @@
+USE hkdb;
+
+CREATE TABLE albums (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  name VARCHAR(255) NOT NULL,
+  cover VARCHAR(255) NULL,
+  shareAlias VARCHAR(255) NULL,
+  createdOn DATETIME NOT NULL,
+  updatedOn DATETIME NULL
+);
+
+CREATE INDEX albums_index_shareAlias ON albums(shareAlias);
+
+
+CREATE TABLE albums_photos (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  albumId INT NOT NULL,
+  path VARCHAR(255) NOT NULL,
+  sequence INT NOT NULL
+);
+
+CREATE INDEX albums_photos_index_albumId ON albums_photos(albumId, sequence);
@@ -0,0 +1,11 @@
+USE hkdb;
+
+CREATE TABLE login_attempts (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  login VARCHAR(255) NOT NULL,
+  ip VARCHAR(64) NOT NULL,
+  attemptedOn DATETIME NOT NULL
+);
+
+CREATE INDEX login_attempts_index_login ON login_attempts(login, attemptedOn);
+CREATE INDEX login_attempts_index_ip ON login_attempts(ip, attemptedOn);
@@ -0,0 +1,25 @@
+USE hkdb;
+
+CREATE TABLE access_links (
+  id CHAR(32) NOT NULL PRIMARY KEY,
+  scope VARCHAR(10) NOT NULL,
+  targetId INT NULL,
+  description VARCHAR(255) NULL,
+  createdBy VARCHAR(255) NOT NULL,
+  createdOn DATETIME NOT NULL,
+  expiresOn DATETIME NOT NULL,
+  maxUses INT NOT NULL,
+  uses INT NOT NULL,
+  revokedOn DATETIME NULL
+);
+
+
+CREATE TABLE access_link_uses (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  linkId CHAR(32) NOT NULL,
+  usedOn DATETIME NOT NULL,
+  ip VARCHAR(64) NULL,
+  userAgent VARCHAR(255) NULL
+);
+
+CREATE INDEX access_link_uses_index_linkId ON access_link_uses(linkId, usedOn);
@@ -0,0 +1,5 @@
+USE hkdb;
+
+ALTER TABLE users ADD COLUMN disabled TINYINT NOT NULL DEFAULT 0;
+
+CREATE INDEX sessions_index_userId ON sessions(userId);
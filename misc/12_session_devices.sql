@@ -0,0 +1,5 @@
+USE hkdb;
+
+ALTER TABLE sessions ADD COLUMN createdOn DATETIME NULL;
+ALTER TABLE sessions ADD COLUMN userAgent VARCHAR(255) NULL;
+ALTER TABLE sessions ADD COLUMN ip VARCHAR(64) NULL;
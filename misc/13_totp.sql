@@ -0,0 +1,15 @@
+USE hkdb;
+
+ALTER TABLE users ADD COLUMN totpSecret VARCHAR(64) NULL;
+ALTER TABLE users ADD COLUMN totpEnabled TINYINT NOT NULL DEFAULT 0;
+ALTER TABLE users ADD COLUMN totpLastCounter BIGINT NOT NULL DEFAULT 0;
+
+
+CREATE TABLE recovery_codes (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  userId INT NOT NULL,
+  codeHash CHAR(64) NOT NULL,
+  usedOn DATETIME NULL
+);
+
+CREATE INDEX recovery_codes_index_userId ON recovery_codes(userId);
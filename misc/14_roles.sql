@@ -0,0 +1,8 @@
+USE hkdb;
+
+-- Guests could see every post, which is what the family role does now.
+UPDATE users SET type = 'family' WHERE type = 'guest';
+
+ALTER TABLE blogs ADD COLUMN visibility VARCHAR(10) NOT NULL DEFAULT 'family';
+
+CREATE INDEX blogs_index_visibility ON blogs(visibility);
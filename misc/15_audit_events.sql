@@ -0,0 +1,17 @@
+USE hkdb;
+
+CREATE TABLE audit_events (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  createdOn DATETIME NOT NULL,
+  actor VARCHAR(255) NOT NULL,
+  action VARCHAR(50) NOT NULL,
+  targetId VARCHAR(255) NOT NULL,
+  ip VARCHAR(64) NOT NULL,
+  userAgent VARCHAR(512) NOT NULL,
+  oldValue TEXT NULL,
+  newValue TEXT NULL
+);
+
+CREATE INDEX audit_events_index_createdOn ON audit_events(createdOn);
+CREATE INDEX audit_events_index_actor ON audit_events(actor, createdOn);
+CREATE INDEX audit_events_index_action ON audit_events(action, createdOn);
@@ -0,0 +1,14 @@
+USE hkdb;
+
+ALTER TABLE users ADD COLUMN email VARCHAR(255) NULL;
+
+CREATE TABLE password_resets (
+  tokenHash CHAR(64) NOT NULL PRIMARY KEY,
+  userId INT NOT NULL,
+  createdOn DATETIME NOT NULL,
+  expiresOn DATETIME NOT NULL,
+  usedOn DATETIME NULL,
+  ip VARCHAR(64) NOT NULL
+);
+
+CREATE INDEX password_resets_index_user ON password_resets(userId);
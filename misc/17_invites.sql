@@ -0,0 +1,21 @@
+USE hkdb;
+
+CREATE TABLE invites (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  tokenHash CHAR(64) NOT NULL,
+  role VARCHAR(50) NOT NULL,
+  note VARCHAR(255) NULL,
+  email VARCHAR(255) NULL,
+  createdBy VARCHAR(255) NOT NULL,
+  createdOn DATETIME NOT NULL,
+  expiresOn DATETIME NOT NULL,
+  acceptedOn DATETIME NULL,
+  acceptedBy VARCHAR(255) NULL,
+  revokedOn DATETIME NULL
+);
+
+CREATE UNIQUE INDEX invites_index_token ON invites(tokenHash);
+
+-- Two invites accepted at the same time with the same login would
+-- otherwise both create a user.
+CREATE UNIQUE INDEX users_index_login ON users(login);
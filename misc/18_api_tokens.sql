@@ -0,0 +1,16 @@
+USE hkdb;
+
+CREATE TABLE api_tokens (
+  id INT NOT NULL PRIMARY KEY AUTO_INCREMENT,
+  userId INT NOT NULL,
+  name VARCHAR(255) NOT NULL,
+  tokenHash CHAR(64) NOT NULL,
+  scopes VARCHAR(100) NOT NULL,
+  createdOn DATETIME NOT NULL,
+  expiresOn DATETIME NOT NULL,
+  lastUsedOn DATETIME NULL,
+  revokedOn DATETIME NULL
+);
+
+CREATE UNIQUE INDEX api_tokens_index_token ON api_tokens(tokenHash);
+CREATE INDEX api_tokens_index_user ON api_tokens(userId);
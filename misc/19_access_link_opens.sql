@@ -0,0 +1,8 @@
+USE hkdb;
+
+-- Each time an access link is opened the browser gets a random ID (in
+-- a cookie) that is only good for that open, the link token itself is
+-- not accepted from the cookie. Only the hash of the ID is stored.
+ALTER TABLE access_link_uses ADD COLUMN openHash CHAR(64) NULL;
+
+CREATE UNIQUE INDEX access_link_uses_index_open ON access_link_uses(openHash);
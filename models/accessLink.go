@@ -0,0 +1,327 @@
+package models
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"database/sql"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// An access link gives anonymous users read-only access to a single
+// post, a single album, or the whole site. Links expire, can be
+// limited to a number of uses, and can be revoked.
+//
+// The token in the URL is the ID of the link plus a signature of it
+// (HMAC with LINK_SECRET) so that made up tokens are rejected without
+// hitting the database. Every time the link is opened a use is recorded
+// and the browser gets a random open ID that lets it keep using the
+// link (see AccessLinkFromOpen) without counting as another use.
+type AccessLink struct {
+	Id          string
+	Scope       string // site, post, or album
+	TargetId    int64  // ID of the post or album
+	Description string
+	CreatedBy   string
+	CreatedOn   time.Time
+	ExpiresOn   time.Time
+	MaxUses     int // zero means unlimited
+	Uses        int
+	RevokedOn   time.Time
+}
+
+type AccessLinkUse struct {
+	UsedOn    time.Time
+	Ip        string
+	UserAgent string
+}
+
+const (
+	AccessLinkSite  = "site"
+	AccessLinkPost  = "post"
+	AccessLinkAlbum = "album"
+)
+
+const accessLinkColumns = `id, scope, targetId, description, createdBy,
+	createdOn, expiresOn, maxUses, uses, revokedOn`
+
+func (l AccessLink) IsRevoked() bool {
+	return !l.RevokedOn.IsZero()
+}
+
+// Returns true if the link can be opened (again).
+func (l AccessLink) IsActive(now time.Time) bool {
+	if !l.isCurrent(now) {
+		return false
+	}
+	return l.MaxUses == 0 || l.Uses < l.MaxUses
+}
+
+// Not revoked nor expired. People that already opened the link can
+// keep using it until then, even if it has no uses left.
+func (l AccessLink) isCurrent(now time.Time) bool {
+	return !l.IsRevoked() && now.Before(l.ExpiresOn)
+}
+
+func (l AccessLink) Token() string {
+	return l.Id + "." + accessLinkSignature(l.Id)
+}
+
+// Returns the path (with the token) that the link gives access to.
+func (l AccessLink) Path() string {
+	path := "/"
+	if l.Scope == AccessLinkPost {
+		blog, err := BlogGetById(l.TargetId)
+		if err == nil {
+			path = blog.URL("")
+		}
+	} else if l.Scope == AccessLinkAlbum {
+		path = Album{Id: l.TargetId}.URL()
+	}
+	return path + "?link=" + l.Token()
+}
+
+func accessLinkSignature(id string) string {
+	return accessLinkSignatureWith(settings.Auth.LinkSecret, id)
+}
+
+func accessLinkSignatureWith(secret string, id string) string {
+	mac := hmac.New(sha256.New, []byte(secret))
+	mac.Write([]byte(id))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the ID of the link if the signature of the token is valid.
+func accessLinkIdFromToken(token string) (string, error) {
+	tokens := strings.Split(token, ".")
+	if len(tokens) != 2 || tokens[0] == "" {
+		return "", errors.New("Invalid access link token")
+	}
+	expected := accessLinkSignature(tokens[0])
+	if !hmac.Equal([]byte(tokens[1]), []byte(expected)) {
+		return "", errors.New("Invalid access link signature")
+	}
+	return tokens[0], nil
+}
+
+func AccessLinkNew(scope string, targetId int64, description, createdBy string, days int, maxUses int) (AccessLink, error) {
+	if scope != AccessLinkSite && scope != AccessLinkPost && scope != AccessLinkAlbum {
+		return AccessLink{}, fmt.Errorf("Invalid access link scope (%s)", scope)
+	}
+	if scope == AccessLinkSite {
+		targetId = 0
+	} else if targetId == 0 {
+		return AccessLink{}, errors.New("No target ID was indicated for the access link")
+	}
+	if days <= 0 {
+		return AccessLink{}, errors.New("Access links must expire")
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return AccessLink{}, err
+	}
+
+	rb := make([]byte, 16)
+	if _, err := rand.Read(rb); err != nil {
+		return AccessLink{}, err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	link := AccessLink{
+		Id:          base64.RawURLEncoding.EncodeToString(rb),
+		Scope:       scope,
+		TargetId:    targetId,
+		Description: description,
+		CreatedBy:   createdBy,
+		CreatedOn:   now,
+		ExpiresOn:   now.AddDate(0, 0, days),
+		MaxUses:     maxUses,
+	}
+
+	sqlInsert := `
+		INSERT INTO access_links(id, scope, targetId, description, createdBy,
+			createdOn, expiresOn, maxUses, uses)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?, 0)`
+	_, err = db.Exec(sqlInsert, link.Id, link.Scope, link.TargetId, link.Description,
+		link.CreatedBy, link.CreatedOn, link.ExpiresOn, link.MaxUses)
+	return link, err
+}
+
+func AccessLinkGetAll() ([]AccessLink, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := `SELECT ` + accessLinkColumns + ` FROM access_links ORDER BY createdOn DESC`
+	rows, err := db.Query(sqlSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	links := []AccessLink{}
+	for rows.Next() {
+		link, err := scanAccessLink(rows)
+		if err != nil {
+			return nil, err
+		}
+		links = append(links, link)
+	}
+	return links, rows.Err()
+}
+
+func AccessLinkGetById(id string) (AccessLink, error) {
+	db, err := connectDB()
+	if err != nil {
+		return AccessLink{}, err
+	}
+
+	sqlSelect := `SELECT ` + accessLinkColumns + ` FROM access_links WHERE id = ?`
+	return scanAccessLink(db.QueryRow(sqlSelect, id))
+}
+
+func accessLinkOpenHash(openId string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(openId)))
+}
+
+// Returns the link for the ID returned by AccessLinkOpen as long as
+// the link has not been revoked or expired.
+func AccessLinkFromOpen(openId string) (AccessLink, error) {
+	db, err := connectDB()
+	if err != nil {
+		return AccessLink{}, err
+	}
+
+	var id string
+	sqlSelect := `SELECT linkId FROM access_link_uses WHERE openHash = ?`
+	if err := db.QueryRow(sqlSelect, accessLinkOpenHash(openId)).Scan(&id); err != nil {
+		return AccessLink{}, err
+	}
+
+	link, err := AccessLinkGetById(id)
+	if err != nil {
+		return AccessLink{}, err
+	}
+
+	if !link.isCurrent(time.Now().UTC()) {
+		return AccessLink{}, errors.New("Access link is no longer active")
+	}
+	return link, nil
+}
+
+// Records that a link was opened (i.e. used by someone) and returns it
+// along with a new open ID (see AccessLinkFromOpen) that is not stored
+// anywhere. Fails if the link has been revoked, is expired, or has
+// already been used as many times as allowed.
+func AccessLinkOpen(token string, ip string, userAgent string) (AccessLink, string, error) {
+	id, err := accessLinkIdFromToken(token)
+	if err != nil {
+		return AccessLink{}, "", err
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return AccessLink{}, "", err
+	}
+
+	rb := make([]byte, 32)
+	if _, err := rand.Read(rb); err != nil {
+		return AccessLink{}, "", err
+	}
+	openId := base64.RawURLEncoding.EncodeToString(rb)
+
+	now := time.Now().UTC()
+	sqlUpdate := `
+		UPDATE access_links
+		SET uses = uses + 1
+		WHERE id = ? AND revokedOn IS NULL AND expiresOn > ?
+			AND (maxUses = 0 OR uses < maxUses)`
+	result, err := db.Exec(sqlUpdate, id, now)
+	if err != nil {
+		return AccessLink{}, "", err
+	}
+	if count, _ := result.RowsAffected(); count != 1 {
+		return AccessLink{}, "", errors.New("Access link is no longer active")
+	}
+
+	if len(userAgent) > 255 {
+		userAgent = userAgent[0:255]
+	}
+	sqlInsert := `INSERT INTO access_link_uses(linkId, usedOn, ip, userAgent, openHash) VALUES(?, ?, ?, ?, ?)`
+	_, err = db.Exec(sqlInsert, id, now, ip, userAgent, accessLinkOpenHash(openId))
+	if err != nil {
+		return AccessLink{}, "", err
+	}
+
+	sqlSelect := `SELECT ` + accessLinkColumns + ` FROM access_links WHERE id = ?`
+	link, err := scanAccessLink(db.QueryRow(sqlSelect, id))
+	return link, openId, err
+}
+
+func AccessLinkRevoke(id string) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	sqlUpdate := `UPDATE access_links SET revokedOn = ? WHERE id = ? AND revokedOn IS NULL`
+	_, err = db.Exec(sqlUpdate, time.Now().UTC(), id)
+	return err
+}
+
+func AccessLinkUses(id string) ([]AccessLinkUse, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := `
+		SELECT usedOn, ip, userAgent
+		FROM access_link_uses
+		WHERE linkId = ?
+		ORDER BY usedOn DESC`
+	rows, err := db.Query(sqlSelect, id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	uses := []AccessLinkUse{}
+	for rows.Next() {
+		var use AccessLinkUse
+		var ip, userAgent sql.NullString
+		if err := rows.Scan(&use.UsedOn, &ip, &userAgent); err != nil {
+			return nil, err
+		}
+		use.Ip = stringValue(ip)
+		use.UserAgent = stringValue(userAgent)
+		uses = append(uses, use)
+	}
+	return uses, rows.Err()
+}
+
+func scanAccessLink(row rowScanner) (AccessLink, error) {
+	var link AccessLink
+	var targetId sql.NullInt64
+	var description sql.NullString
+	var revokedOn mysql.NullTime
+	err := row.Scan(&link.Id, &link.Scope, &targetId, &description, &link.CreatedBy,
+		&link.CreatedOn, &link.ExpiresOn, &link.MaxUses, &link.Uses, &revokedOn)
+	if err != nil {
+		return AccessLink{}, err
+	}
+	link.TargetId = targetId.Int64
+	link.Description = stringValue(description)
+	if revokedOn.Valid {
+		link.RevokedOn = revokedOn.Time
+	}
+	return link, nil
+}
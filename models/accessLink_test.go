@@ -0,0 +1,63 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestAccessLinkToken(t *testing.T) {
+	settings.Auth.LinkSecret = "secret"
+	defer func() { settings.Auth.LinkSecret = "" }()
+
+	link := AccessLink{Id: "abc"}
+	id, err := accessLinkIdFromToken(link.Token())
+	if err != nil || id != "abc" {
+		t.Errorf("Valid token rejected: %s %v", id, err)
+	}
+
+	invalid := []string{"", "abc", "abc.", ".sig", "abc.sig", "abd." + accessLinkSignature("abc")}
+	for _, token := range invalid {
+		if _, err := accessLinkIdFromToken(token); err == nil {
+			t.Errorf("Invalid token accepted: %s", token)
+		}
+	}
+
+	settings.Auth.LinkSecret = "other"
+	if _, err := accessLinkIdFromToken(link.Token()); err != nil {
+		t.Errorf("Token should be valid with the new secret")
+	}
+	if _, err := accessLinkIdFromToken("abc." + accessLinkSignatureWith("secret", "abc")); err == nil {
+		t.Errorf("Token signed with the old secret accepted")
+	}
+}
+
+func TestAccessLinkIsActive(t *testing.T) {
+	now := time.Now()
+	link := AccessLink{ExpiresOn: now.Add(time.Hour)}
+	if !link.IsActive(now) {
+		t.Errorf("Link should be active")
+	}
+
+	expired := AccessLink{ExpiresOn: now}
+	if expired.IsActive(now) {
+		t.Errorf("Expired link is active")
+	}
+
+	revoked := AccessLink{ExpiresOn: now.Add(time.Hour), RevokedOn: now}
+	if revoked.IsActive(now) {
+		t.Errorf("Revoked link is active")
+	}
+
+	usedUp := AccessLink{ExpiresOn: now.Add(time.Hour), MaxUses: 2, Uses: 2}
+	if usedUp.IsActive(now) {
+		t.Errorf("Used up link is active")
+	}
+	if !usedUp.isCurrent(now) {
+		t.Errorf("Used up link should still work for the people that opened it")
+	}
+
+	oneLeft := AccessLink{ExpiresOn: now.Add(time.Hour), MaxUses: 2, Uses: 1}
+	if !oneLeft.IsActive(now) {
+		t.Errorf("Link with uses left is not active")
+	}
+}
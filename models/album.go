@@ -0,0 +1,244 @@
+package models
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"strconv"
+	"strings"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// An album is a collection of photos independent of the blogs.
+type Album struct {
+	Id         int64
+	Name       string
+	Cover      string
+	ShareAlias string
+	CreatedOn  string
+	UpdatedOn  string
+	Photos     []string // in the order they are displayed
+}
+
+func (a Album) URL() string {
+	return fmt.Sprintf("/albums/%d", a.Id)
+}
+
+// Returns the cover photo or the first photo if no cover was set.
+func (a Album) CoverPhoto() string {
+	if a.Cover != "" {
+		return a.Cover
+	}
+	if len(a.Photos) > 0 {
+		return a.Photos[0]
+	}
+	return ""
+}
+
+// Returns true if the photo URL is in the album, either directly or
+// as the full size version of a thumbnail.
+func (a Album) HasPhoto(url string) bool {
+	if url == "" {
+		return false
+	}
+	candidates := append([]string{a.Cover}, a.Photos...)
+	for _, photo := range candidates {
+		full := strings.Replace(photo, "_thumb.jpg", ".jpg", 1)
+		if url == photo || url == full {
+			return true
+		}
+	}
+	return false
+}
+
+// Sets the photos from a text with one photo per line.
+func (a *Album) SetPhotosFromText(text string) {
+	a.Photos = []string{}
+	for _, line := range strings.Split(text, "\n") {
+		photo := strings.Trim(line, " \r\n\t")
+		if photo != "" {
+			a.Photos = append(a.Photos, photo)
+		}
+	}
+}
+
+func AlbumGetAll() ([]Album, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := `SELECT id FROM albums ORDER BY createdOn DESC`
+	rows, err := db.Query(sqlSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return nil, err
+		}
+		ids = append(ids, id)
+	}
+
+	albums := []Album{}
+	for _, id := range ids {
+		album, err := AlbumGetById(id)
+		if err != nil {
+			return nil, err
+		}
+		albums = append(albums, album)
+	}
+	return albums, nil
+}
+
+func AlbumGetById(id int64) (Album, error) {
+	db, err := connectDB()
+	if err != nil {
+		return Album{}, err
+	}
+
+	sqlSelect := `
+		SELECT name, cover, shareAlias, createdOn, updatedOn
+		FROM albums
+		WHERE id = ?`
+	row := db.QueryRow(sqlSelect, id)
+
+	var name, cover, shareAlias sql.NullString
+	var createdOn, updatedOn mysql.NullTime
+	err = row.Scan(&name, &cover, &shareAlias, &createdOn, &updatedOn)
+	if err != nil {
+		return Album{}, err
+	}
+
+	album := Album{
+		Id:         id,
+		Name:       stringValue(name),
+		Cover:      stringValue(cover),
+		ShareAlias: stringValue(shareAlias),
+		CreatedOn:  timeValue(createdOn),
+		UpdatedOn:  timeValue(updatedOn),
+	}
+	album.Photos, err = album.getPhotos(db)
+	return album, err
+}
+
+func AlbumGetByAlias(alias string) (Album, error) {
+	db, err := connectDB()
+	if err != nil {
+		return Album{}, err
+	}
+
+	var id int64
+	sqlSelect := "SELECT id FROM albums WHERE shareAlias = ? LIMIT 1"
+	err = db.QueryRow(sqlSelect, alias).Scan(&id)
+	if err != nil {
+		return Album{}, err
+	}
+	return AlbumGetById(id)
+}
+
+func AlbumSaveNew() (int64, error) {
+	db, err := connectDB()
+	if err != nil {
+		return 0, err
+	}
+
+	sqlInsert := `INSERT INTO albums(name, createdOn) VALUES(?, ?)`
+	result, err := db.Exec(sqlInsert, "new album", dbUtcNow())
+	if err != nil {
+		return 0, err
+	}
+	return result.LastInsertId()
+}
+
+func (a *Album) Save() error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	a.Cover = strings.TrimSpace(a.Cover)
+	sqlUpdate := `
+		UPDATE albums
+		SET name = ?, cover = ?, shareAlias = ?, updatedOn = ?
+		WHERE id = ?`
+	_, err = db.Exec(sqlUpdate, a.Name, nullString(a.Cover), nullString(a.ShareAlias),
+		dbUtcNow(), a.Id)
+	if err != nil {
+		return err
+	}
+
+	sqlDelete := `DELETE FROM albums_photos WHERE albumId = ?`
+	_, err = db.Exec(sqlDelete, a.Id)
+	if err != nil {
+		return err
+	}
+
+	for i, photo := range a.Photos {
+		sqlInsert := `INSERT INTO albums_photos(albumId, path, sequence) VALUES(?, ?, ?)`
+		_, err = db.Exec(sqlInsert, a.Id, photo, i+1)
+		if err != nil {
+			return err
+		}
+	}
+	return a.resaveBlogs(db)
+}
+
+// The blogs that embed the album have its photos in their HTML (see
+// albumAsHtml) so they are saved again to pick up the changes.
+func (a Album) resaveBlogs(db *sql.DB) error {
+	sqlSelect := `SELECT DISTINCT blogId FROM blog_sections WHERE sectionType = 'a' AND TRIM(content) = ?`
+	rows, err := db.Query(sqlSelect, strconv.FormatInt(a.Id, 10))
+	if err != nil {
+		return err
+	}
+	defer rows.Close()
+
+	ids := []int64{}
+	for rows.Next() {
+		var id int64
+		if err := rows.Scan(&id); err != nil {
+			return err
+		}
+		ids = append(ids, id)
+	}
+	if err := rows.Err(); err != nil {
+		return err
+	}
+
+	for _, id := range ids {
+		blog, err := BlogGetById(id)
+		if err != nil {
+			return err
+		}
+		if err := blog.Save(); err != nil {
+			return err
+		}
+		log.Printf("Re-saved blog %d that embeds album %d", id, a.Id)
+	}
+	return nil
+}
+
+func (a Album) getPhotos(db *sql.DB) ([]string, error) {
+	sqlSelect := `SELECT path FROM albums_photos WHERE albumId = ? ORDER BY sequence`
+	rows, err := db.Query(sqlSelect, a.Id)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	photos := []string{}
+	for rows.Next() {
+		var path string
+		if err := rows.Scan(&path); err != nil {
+			return nil, err
+		}
+		photos = append(photos, path)
+	}
+	return photos, rows.Err()
+}
@@ -0,0 +1,34 @@
+package models
+
+import "testing"
+
+func TestAlbumPhotosFromText(t *testing.T) {
+	album := Album{}
+	album.SetPhotosFromText("/photos/a_thumb.jpg\r\n\r\n  /photos/b_thumb.jpg \n/photos/c_thumb.jpg")
+	expected := []string{"/photos/a_thumb.jpg", "/photos/b_thumb.jpg", "/photos/c_thumb.jpg"}
+	if len(album.Photos) != len(expected) {
+		t.Fatalf("Unexpected photos: %v", album.Photos)
+	}
+	for i, photo := range expected {
+		if album.Photos[i] != photo {
+			t.Errorf("Unexpected photo (%s) at %d", album.Photos[i], i)
+		}
+	}
+}
+
+func TestAlbumHasPhoto(t *testing.T) {
+	album := Album{Photos: []string{"/photos/a_thumb.jpg"}, Cover: "/photos/cover_thumb.jpg"}
+	valid := []string{"/photos/a_thumb.jpg", "/photos/a.jpg", "/photos/cover.jpg"}
+	for _, url := range valid {
+		if !album.HasPhoto(url) {
+			t.Errorf("Photo not found in album: %s", url)
+		}
+	}
+
+	invalid := []string{"", "/photos/b.jpg", "/photos/a_thumb.jpg.bak"}
+	for _, url := range invalid {
+		if album.HasPhoto(url) {
+			t.Errorf("Unexpected photo found in album: %s", url)
+		}
+	}
+}
@@ -0,0 +1,212 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// Personal access tokens let scripts (and other non-browser clients)
+// act on behalf of a user by passing "Authorization: Bearer <token>".
+// Tokens have scopes that limit what they can do and always expire.
+// We only store the hash of the token so it is only shown when it is
+// created.
+type ApiToken struct {
+	Id         int64
+	UserId     int64
+	Name       string
+	Scopes     []string
+	CreatedOn  time.Time
+	ExpiresOn  time.Time
+	LastUsedOn time.Time
+	RevokedOn  time.Time
+}
+
+const (
+	ScopeRead   = "read"   // GET requests
+	ScopeWrite  = "write"  // POST requests (e.g. saving a blog)
+	ScopeUpload = "upload" // uploading photos
+)
+
+var ApiScopes = []string{ScopeRead, ScopeWrite, ScopeUpload}
+
+// Tokens start with a prefix so that they are easy to recognize (e.g.
+// by secret scanners) if they are leaked.
+const apiTokenPrefix = "hk_"
+
+const apiTokenMaxDays = 365
+
+var ErrApiTokenInvalid = errors.New("Invalid, expired, or revoked API token")
+
+const apiTokenColumns = `id, userId, name, scopes, createdOn, expiresOn, lastUsedOn, revokedOn`
+
+func IsValidScope(scope string) bool {
+	for _, s := range ApiScopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t ApiToken) HasScope(scope string) bool {
+	for _, s := range t.Scopes {
+		if s == scope {
+			return true
+		}
+	}
+	return false
+}
+
+func (t ApiToken) IsActive(now time.Time) bool {
+	return t.RevokedOn.IsZero() && now.Before(t.ExpiresOn)
+}
+
+func apiTokenHash(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// Creates a token for the user and returns it along with the token
+// value (that is not stored anywhere).
+func ApiTokenNew(userId int64, name string, scopes []string, days int) (ApiToken, string, error) {
+	name = strings.TrimSpace(name)
+	if name == "" {
+		return ApiToken{}, "", errors.New("Tokens must have a name")
+	}
+	if len(scopes) == 0 {
+		return ApiToken{}, "", errors.New("Tokens must have at least one scope")
+	}
+	for _, scope := range scopes {
+		if !IsValidScope(scope) {
+			return ApiToken{}, "", fmt.Errorf("Invalid scope (%s)", scope)
+		}
+	}
+	if days <= 0 || days > apiTokenMaxDays {
+		return ApiToken{}, "", fmt.Errorf("Tokens must expire in 1 to %d days", apiTokenMaxDays)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return ApiToken{}, "", err
+	}
+
+	id, err := newId()
+	if err != nil {
+		return ApiToken{}, "", err
+	}
+	value := apiTokenPrefix + strings.TrimRight(id, "=")
+
+	now := time.Now().UTC().Truncate(time.Second)
+	token := ApiToken{
+		UserId:    userId,
+		Name:      name,
+		Scopes:    scopes,
+		CreatedOn: now,
+		ExpiresOn: now.AddDate(0, 0, days),
+	}
+	sqlInsert := `
+		INSERT INTO api_tokens(userId, name, tokenHash, scopes, createdOn, expiresOn)
+		VALUES(?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(sqlInsert, token.UserId, token.Name, apiTokenHash(value),
+		strings.Join(token.Scopes, ","), token.CreatedOn, token.ExpiresOn)
+	if err != nil {
+		return ApiToken{}, "", err
+	}
+	token.Id, err = result.LastInsertId()
+	return token, value, err
+}
+
+func ApiTokensGetByUser(userId int64) ([]ApiToken, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := "SELECT " + apiTokenColumns + " FROM api_tokens WHERE userId = ? ORDER BY createdOn DESC"
+	rows, err := db.Query(sqlSelect, userId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	tokens := []ApiToken{}
+	for rows.Next() {
+		token, err := scanApiToken(rows)
+		if err != nil {
+			return nil, err
+		}
+		tokens = append(tokens, token)
+	}
+	return tokens, rows.Err()
+}
+
+// Users can only revoke their own tokens.
+func ApiTokenRevoke(userId int64, id int64) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	sqlUpdate := `UPDATE api_tokens SET revokedOn = ? WHERE id = ? AND userId = ? AND revokedOn IS NULL`
+	_, err = db.Exec(sqlUpdate, time.Now().UTC(), id, userId)
+	return err
+}
+
+// Returns the token and the user for a token value. Fails if the
+// token is not active or the user has been disabled.
+func ApiTokenAuth(value string) (ApiToken, User, error) {
+	if !strings.HasPrefix(value, apiTokenPrefix) {
+		return ApiToken{}, User{}, ErrApiTokenInvalid
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return ApiToken{}, User{}, err
+	}
+
+	hash := apiTokenHash(value)
+	row := db.QueryRow("SELECT "+apiTokenColumns+" FROM api_tokens WHERE tokenHash = ?", hash)
+	token, err := scanApiToken(row)
+	if err == sql.ErrNoRows || (err == nil && !token.IsActive(time.Now().UTC())) {
+		return ApiToken{}, User{}, ErrApiTokenInvalid
+	} else if err != nil {
+		return ApiToken{}, User{}, err
+	}
+
+	row = db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ? AND disabled = 0", token.UserId)
+	user, err := scanUser(row)
+	if err == sql.ErrNoRows {
+		return ApiToken{}, User{}, ErrApiTokenInvalid
+	} else if err != nil {
+		return ApiToken{}, User{}, err
+	}
+
+	_, err = db.Exec("UPDATE api_tokens SET lastUsedOn = ? WHERE id = ?", time.Now().UTC(), token.Id)
+	return token, user, err
+}
+
+func scanApiToken(row rowScanner) (ApiToken, error) {
+	var token ApiToken
+	var scopes string
+	var lastUsedOn, revokedOn mysql.NullTime
+	err := row.Scan(&token.Id, &token.UserId, &token.Name, &scopes,
+		&token.CreatedOn, &token.ExpiresOn, &lastUsedOn, &revokedOn)
+	if err != nil {
+		return ApiToken{}, err
+	}
+	if scopes != "" {
+		token.Scopes = strings.Split(scopes, ",")
+	}
+	if lastUsedOn.Valid {
+		token.LastUsedOn = lastUsedOn.Time
+	}
+	if revokedOn.Valid {
+		token.RevokedOn = revokedOn.Time
+	}
+	return token, nil
+}
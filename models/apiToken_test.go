@@ -0,0 +1,43 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+func TestApiTokenScopes(t *testing.T) {
+	token := ApiToken{Scopes: []string{ScopeRead, ScopeUpload}}
+	if !token.HasScope(ScopeRead) || !token.HasScope(ScopeUpload) {
+		t.Errorf("Token should have read and upload scopes")
+	}
+	if token.HasScope(ScopeWrite) {
+		t.Errorf("Token should not have write scope")
+	}
+
+	if !IsValidScope(ScopeWrite) || IsValidScope("admin") {
+		t.Errorf("Unexpected result validating scopes")
+	}
+}
+
+func TestApiTokenIsActive(t *testing.T) {
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	token := ApiToken{ExpiresOn: now.AddDate(0, 0, 1)}
+	if !token.IsActive(now) {
+		t.Errorf("Token should be active")
+	}
+	if token.IsActive(now.AddDate(0, 0, 1)) {
+		t.Errorf("Token should have expired")
+	}
+
+	token.RevokedOn = now
+	if token.IsActive(now) {
+		t.Errorf("Revoked token should not be active")
+	}
+}
+
+func TestApiTokenAuthPrefix(t *testing.T) {
+	// Tokens without the prefix are rejected without hitting the database.
+	if _, _, err := ApiTokenAuth("not-a-token"); err != ErrApiTokenInvalid {
+		t.Errorf("Expected invalid token error, got: %v", err)
+	}
+}
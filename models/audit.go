@@ -0,0 +1,194 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+	"time"
+	"unicode/utf8"
+)
+
+// An event worth keeping track of: logins, changes to users, changes
+// to blogs, et cetera. OldValue and NewValue are short summaries of
+// the target before and after the change (when it applies).
+type AuditEvent struct {
+	Id        int64
+	CreatedOn time.Time
+	Actor     string // login of the user that did it, empty for anonymous users
+	Action    string
+	TargetId  string
+	Ip        string
+	UserAgent string
+	OldValue  string
+	NewValue  string
+}
+
+const (
+	AuditLoginOk              = "login.ok"
+	AuditLoginFailed          = "login.failed"
+	AuditLoginThrottled       = "login.throttled"
+	AuditLogout               = "logout"
+	AuditTwoFactorFailed      = "2fa.failed"
+	AuditTwoFactorEnable      = "2fa.enable"
+	AuditTwoFactorDisable     = "2fa.disable"
+	AuditPasswordChange       = "password.change"
+	AuditPasswordResetRequest = "password.reset.request"
+	AuditPasswordReset        = "password.reset"
+	AuditTokenCreate          = "token.create"
+	AuditTokenRevoke          = "token.revoke"
+	AuditLinkCreate           = "link.create"
+	AuditLinkUse              = "link.use"
+	AuditLinkRevoke           = "link.revoke"
+	AuditInviteCreate         = "invite.create"
+	AuditInviteRevoke         = "invite.revoke"
+	AuditInviteAccept         = "invite.accept"
+	AuditBlogCreate           = "blog.create"
+	AuditBlogSave             = "blog.save"
+	AuditBlogPublish          = "blog.publish"
+	AuditBlogUnpublish        = "blog.unpublish"
+	AuditBlogDelete           = "blog.delete"
+	AuditUserAdd              = "user.add"
+	AuditUserType             = "user.type"
+	AuditUserDisable          = "user.disable"
+	AuditUserEnable           = "user.enable"
+	AuditUserDelete           = "user.delete"
+	AuditUserPassword         = "user.password"
+	AuditUserEmail            = "user.email"
+	AuditUserSignOut          = "user.signout"
+)
+
+var AuditActions = []string{
+	AuditLoginOk, AuditLoginFailed, AuditLoginThrottled, AuditLogout,
+	AuditTwoFactorFailed, AuditTwoFactorEnable, AuditTwoFactorDisable,
+	AuditPasswordChange, AuditPasswordResetRequest, AuditPasswordReset,
+	AuditTokenCreate, AuditTokenRevoke,
+	AuditLinkCreate, AuditLinkUse, AuditLinkRevoke,
+	AuditInviteCreate, AuditInviteRevoke, AuditInviteAccept,
+	AuditBlogCreate, AuditBlogSave, AuditBlogPublish, AuditBlogUnpublish,
+	AuditBlogDelete,
+	AuditUserAdd, AuditUserType, AuditUserDisable, AuditUserEnable,
+	AuditUserDelete, AuditUserPassword, AuditUserEmail, AuditUserSignOut,
+}
+
+// Criteria to search the audit events. Empty values are ignored.
+type AuditFilter struct {
+	Actor    string
+	Action   string
+	TargetId string
+	From     time.Time // inclusive
+	To       time.Time // exclusive
+	Limit    int
+}
+
+const auditMaxLimit = 5000
+
+// Returns the WHERE clause (without the WHERE keyword) and its
+// arguments for the filter.
+func (f AuditFilter) where() (string, []interface{}) {
+	conditions := []string{"1 = 1"}
+	args := []interface{}{}
+	if f.Actor != "" {
+		conditions = append(conditions, "actor = ?")
+		args = append(args, f.Actor)
+	}
+	if f.Action != "" {
+		conditions = append(conditions, "action = ?")
+		args = append(args, f.Action)
+	}
+	if f.TargetId != "" {
+		conditions = append(conditions, "targetId = ?")
+		args = append(args, f.TargetId)
+	}
+	if !f.From.IsZero() {
+		conditions = append(conditions, "createdOn >= ?")
+		args = append(args, f.From.UTC())
+	}
+	if !f.To.IsZero() {
+		conditions = append(conditions, "createdOn < ?")
+		args = append(args, f.To.UTC())
+	}
+	return strings.Join(conditions, " AND "), args
+}
+
+func (f AuditFilter) limit() int {
+	if f.Limit <= 0 || f.Limit > auditMaxLimit {
+		return auditMaxLimit
+	}
+	return f.Limit
+}
+
+func AuditAdd(event AuditEvent) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	if event.CreatedOn.IsZero() {
+		event.CreatedOn = time.Now()
+	}
+	sqlInsert := `
+		INSERT INTO audit_events(createdOn, actor, action, targetId, ip,
+			userAgent, oldValue, newValue)
+		VALUES(?, ?, ?, ?, ?, ?, ?, ?)`
+	event = event.truncated()
+	_, err = db.Exec(sqlInsert, event.CreatedOn.UTC(), event.Actor, event.Action,
+		event.TargetId, event.Ip, event.UserAgent, event.OldValue, event.NewValue)
+	return err
+}
+
+// The event with the values cut to the size of their columns. Some of
+// them come from the client (e.g. the login of a failed login) and a
+// value that is too long would make the insert fail and the event would
+// never be recorded.
+func (e AuditEvent) truncated() AuditEvent {
+	e.Actor = truncate(e.Actor, 255)
+	e.TargetId = truncate(e.TargetId, 255)
+	e.Ip = truncate(e.Ip, 64)
+	e.UserAgent = truncate(e.UserAgent, 512)
+	return e
+}
+
+// Returns the events that match the filter, newest first.
+func AuditGetAll(filter AuditFilter) ([]AuditEvent, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	where, args := filter.where()
+	sqlSelect := `
+		SELECT id, createdOn, actor, action, targetId, ip, userAgent, oldValue, newValue
+		FROM audit_events
+		WHERE ` + where + `
+		ORDER BY createdOn DESC, id DESC
+		LIMIT ?`
+	args = append(args, filter.limit())
+	rows, err := db.Query(sqlSelect, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	events := []AuditEvent{}
+	for rows.Next() {
+		var event AuditEvent
+		var oldValue, newValue sql.NullString
+		err := rows.Scan(&event.Id, &event.CreatedOn, &event.Actor, &event.Action,
+			&event.TargetId, &event.Ip, &event.UserAgent, &oldValue, &newValue)
+		if err != nil {
+			return nil, err
+		}
+		event.OldValue = stringValue(oldValue)
+		event.NewValue = stringValue(newValue)
+		events = append(events, event)
+	}
+	return events, rows.Err()
+}
+
+// Cuts the text to length characters (not bytes, like the columns) so
+// that a multi-byte character is never split.
+func truncate(text string, length int) string {
+	if utf8.RuneCountInString(text) <= length {
+		return text
+	}
+	return string([]rune(text)[0:length])
+}
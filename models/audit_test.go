@@ -0,0 +1,50 @@
+package models
+
+import (
+	"strings"
+	"testing"
+	"time"
+	"unicode/utf8"
+)
+
+func TestAuditFilterWhere(t *testing.T) {
+	where, args := AuditFilter{}.where()
+	if where != "1 = 1" || len(args) != 0 {
+		t.Errorf("Unexpected where for empty filter: %s %v", where, args)
+	}
+
+	from := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	filter := AuditFilter{Actor: "user1", Action: AuditLoginFailed, From: from}
+	where, args = filter.where()
+	expected := "1 = 1 AND actor = ? AND action = ? AND createdOn >= ?"
+	if where != expected {
+		t.Errorf("Unexpected where: %s", where)
+	}
+	if len(args) != 3 || args[0] != "user1" || args[1] != AuditLoginFailed || args[2] != from {
+		t.Errorf("Unexpected args: %v", args)
+	}
+}
+
+func TestAuditFilterLimit(t *testing.T) {
+	if (AuditFilter{}).limit() != auditMaxLimit {
+		t.Errorf("Empty limit should default to the max")
+	}
+	if (AuditFilter{Limit: 50}).limit() != 50 {
+		t.Errorf("Limit not honored")
+	}
+	if (AuditFilter{Limit: auditMaxLimit + 1}).limit() != auditMaxLimit {
+		t.Errorf("Limit should not go over the max")
+	}
+}
+
+func TestAuditEventTruncated(t *testing.T) {
+	login := strings.Repeat("é", 300)
+	event := AuditEvent{Actor: login, TargetId: login, Ip: "1.2.3.4", UserAgent: strings.Repeat("a", 600)}.truncated()
+	if event.Actor != strings.Repeat("é", 255) || event.TargetId != strings.Repeat("é", 255) {
+		t.Errorf("Actor and target should be cut to 255 characters: %d %d",
+			utf8.RuneCountInString(event.Actor), utf8.RuneCountInString(event.TargetId))
+	}
+	if event.Ip != "1.2.3.4" || len(event.UserAgent) != 512 {
+		t.Errorf("Unexpected values: %s %d", event.Ip, len(event.UserAgent))
+	}
+}
@@ -4,6 +4,7 @@ import (
 	"database/sql"
 	"errors"
 	"fmt"
+	"html"
 	"log"
 	"regexp"
 	"sort"
@@ -23,10 +24,12 @@ type Blog struct {
 	Year        int
 	Thumbnail   string
 	ShareAlias  string
+	Visibility  string // lowest role that can see the blog
 	ContentHtml string
 	CreatedOn   string
 	UpdatedOn   string
 	PostedOn    string
+	Published   bool // used when saving, sets (or clears) PostedOn
 	Photos      []string
 	Sections    []BlogSection
 }
@@ -58,6 +61,11 @@ func (s BlogSection) IsPhoto() bool {
 	return s.Type == "i"
 }
 
+// Album sections hold the ID of the album to embed in the blog.
+func (s BlogSection) IsAlbum() bool {
+	return s.Type == "a"
+}
+
 func (s BlogSection) Lines() []string {
 	lines := []string{}
 	for _, line := range strings.Split(s.Content, "\r") {
@@ -69,8 +77,6 @@ func (s BlogSection) Lines() []string {
 	return lines
 }
 
-var photoPath = "UNSET"
-
 func (b Blog) DebugString() string {
 	str := fmt.Sprintf("Id: %d\nTitle: %s\nSummary: %s\n",
 		b.Id, b.Title, b.Summary)
@@ -91,6 +97,14 @@ func (b Blog) URL(base string) string {
 	return fmt.Sprintf("%s/%d/%s/%d", base, b.Year, b.Slug, b.Id)
 }
 
+// Replaces the http:// links to the given hosts with https:// links.
+func upgradeToHttps(html string, hosts []string) string {
+	for _, host := range hosts {
+		html = strings.Replace(html, "http://"+host, "https://"+host, -1)
+	}
+	return html
+}
+
 // RFC 1123Z looks like "Mon, 02 Jan 2006 15:04:05 -0700"
 // https://golang.org/pkg/time/
 func (b Blog) PostedOnRFC1123Z() string {
@@ -102,24 +116,32 @@ func (b Blog) PostedOnRFC1123Z() string {
 	return t.Format(time.RFC1123Z)
 }
 
-func BlogGetAll() ([]Blog, error) {
-	blogs, err := getBlogsWhere("")
+// Returns true if users with the indicated role can see the blog.
+func (b Blog) IsVisibleTo(role string) bool {
+	if RoleRank(role) == RoleRank(RolePublic) && b.PostedOn == "" {
+		return false
+	}
+	return RoleCanView(role, b.Visibility)
+}
+
+func BlogGetAll(role string) ([]Blog, error) {
+	blogs, err := getBlogsWhere("", role)
 	return blogs, err
 }
 
-func BlogGetYear(year int) ([]Blog, error) {
+func BlogGetYear(year int, role string) ([]Blog, error) {
 	if year < 2000 || year > time.Now().Year() {
 		return []Blog{}, errors.New(fmt.Sprintf("Invalid year received (%d)", year))
 	}
 
-	where := fmt.Sprintf("WHERE year = %d", year)
-	blogs, err := getBlogsWhere(where)
+	where := fmt.Sprintf("year = %d", year)
+	blogs, err := getBlogsWhere(where, role)
 	return blogs, err
 }
 
-func BlogGetRecent() ([]Blog, error) {
-	where := fmt.Sprintf("WHERE year >= %d", time.Now().Year()-1)
-	blogs, err := getBlogsWhere(where)
+func BlogGetRecent(role string) ([]Blog, error) {
+	where := fmt.Sprintf("year >= %d", time.Now().Year()-1)
+	blogs, err := getBlogsWhere(where, role)
 	return blogs, err
 }
 
@@ -156,8 +178,7 @@ func (b *Blog) beforeSave() error {
 		b.ContentHtml = html
 	}
 
-	b.ContentHtml = strings.Replace(b.ContentHtml, "http://www.hectorykarla.com", "https://www.hectorykarla.com", -1)
-	b.ContentHtml = strings.Replace(b.ContentHtml, "http://hectorykarla.com", "https://hectorykarla.com", -1)
+	b.ContentHtml = upgradeToHttps(b.ContentHtml, settings.Site.HttpsHosts)
 	if b.BlogDate == "" {
 		b.BlogDate = b.UpdatedOn
 	}
@@ -199,6 +220,25 @@ func (b *Blog) getPhotos() []string {
 	return photos
 }
 
+// Returns true if the photo URL is referenced by the blog, either
+// directly or as the full size version of a thumbnail.
+func (b Blog) HasPhoto(url string) bool {
+	if url == "" {
+		return false
+	}
+	candidates := append(b.getPhotos(), b.Thumbnail)
+	for _, photo := range candidates {
+		if i := strings.Index(photo, "?"); i != -1 {
+			photo = photo[0:i]
+		}
+		full := strings.Replace(photo, "_thumb.jpg", ".jpg", 1)
+		if url == photo || url == full {
+			return true
+		}
+	}
+	return false
+}
+
 func yearFromDbDate(dbDate string) int {
 	if len(dbDate) < 4 {
 		return 0
@@ -240,12 +280,37 @@ func getSlug(title string) string {
 	return slug
 }
 
+// Deletes the blog along with its sections and the record of the photos
+// that it references (the photos themselves are kept).
+func BlogDelete(id int64) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	sqlDeletes := []string{
+		"DELETE FROM blog_sections WHERE blogId = ?",
+		"DELETE FROM blogs_photos WHERE blog_id = ?",
+		"DELETE FROM blogs WHERE id = ?",
+	}
+	for _, sqlDelete := range sqlDeletes {
+		if _, err := tx.Exec(sqlDelete, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
 func SaveNew() (int64, error) {
 	db, err := connectDB()
 	if err != nil {
 		return 0, err
 	}
-	defer db.Close()
 
 	dbNow := dbUtcNow()
 	sqlInsert := `
@@ -265,9 +330,12 @@ func (b *Blog) Save() error {
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 	b.beforeSave()
 
+	if !IsValidVisibility(b.Visibility) {
+		b.Visibility = DefaultVisibility
+	}
+
 	shareAlias := sql.NullString{String: "", Valid: false}
 	if b.ShareAlias != "" {
 		shareAlias = sql.NullString{String: b.ShareAlias, Valid: true}
@@ -279,10 +347,12 @@ func (b *Blog) Save() error {
 			UPDATE blogs
 			SET title = ?, slug = ?, content = ?,
 				blogDate = ?, year = ?, updatedOn = ?,
-				thumbnail = ?, shareAlias = ?
+				thumbnail = ?, shareAlias = ?, visibility = ?,
+				postedOn = CASE WHEN ? THEN COALESCE(postedOn, ?) ELSE NULL END
 			WHERE id = ?`
 		_, err = db.Exec(sqlUpdate, b.Title, b.Slug, b.ContentHtml,
-			b.BlogDate, b.Year, dbUtcNow(), b.Thumbnail, shareAlias, b.Id)
+			b.BlogDate, b.Year, dbUtcNow(), b.Thumbnail, shareAlias, b.Visibility,
+			b.Published, dbUtcNow(), b.Id)
 	} else {
 		// No ContentHtml received, don't update the content field
 		// (this is so that we don't overwrite old blog entries
@@ -291,10 +361,12 @@ func (b *Blog) Save() error {
 			UPDATE blogs
 			SET title = ?, slug = ?,
 				blogDate = ?, year = ?, updatedOn = ?,
-				thumbnail = ?, shareAlias = ?
+				thumbnail = ?, shareAlias = ?, visibility = ?,
+				postedOn = CASE WHEN ? THEN COALESCE(postedOn, ?) ELSE NULL END
 			WHERE id = ?`
 		_, err = db.Exec(sqlUpdate, b.Title, b.Slug,
-			b.BlogDate, b.Year, dbUtcNow(), b.Thumbnail, shareAlias, b.Id)
+			b.BlogDate, b.Year, dbUtcNow(), b.Thumbnail, shareAlias, b.Visibility,
+			b.Published, dbUtcNow(), b.Id)
 	}
 	if err != nil {
 		return err
@@ -326,6 +398,27 @@ func (b *Blog) Save() error {
 		}
 	}
 
+	if b.ContentHtml != "" {
+		return b.savePhotos(db)
+	}
+	return nil
+}
+
+// Keeps track of the photos referenced by the blog.
+func (b *Blog) savePhotos(db *sql.DB) error {
+	sqlDelete := `DELETE FROM blogs_photos WHERE blog_id = ?`
+	_, err := db.Exec(sqlDelete, b.Id)
+	if err != nil {
+		return err
+	}
+
+	for _, photo := range b.Photos {
+		sqlInsert := `INSERT INTO blogs_photos(blog_id, path) VALUES(?, ?)`
+		_, err = db.Exec(sqlInsert, b.Id, photo)
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -334,20 +427,19 @@ func getOne(id int64) (Blog, error) {
 	if err != nil {
 		return Blog{}, err
 	}
-	defer db.Close()
 
 	sqlSelect := `
 		SELECT title, slug, blogDate, year, content, thumbnail, shareAlias,
-			createdOn, updatedOn, postedOn
+			createdOn, updatedOn, postedOn, visibility
 		FROM blogs
 		WHERE id = ?`
 	row := db.QueryRow(sqlSelect, id)
 
 	var year sql.NullInt64
-	var title, slug, content, thumbnail, shareAlias sql.NullString
+	var title, slug, content, thumbnail, shareAlias, visibility sql.NullString
 	var blogDate, createdOn, updatedOn, postedOn mysql.NullTime
 	err = row.Scan(&title, &slug, &blogDate, &year, &content, &thumbnail, &shareAlias,
-		&createdOn, &updatedOn, &postedOn)
+		&createdOn, &updatedOn, &postedOn, &visibility)
 	if err != nil {
 		return Blog{}, err
 	}
@@ -360,9 +452,11 @@ func getOne(id int64) (Blog, error) {
 	blog.BlogDate = dateValue(blogDate)
 	blog.Thumbnail = stringValue(thumbnail)
 	blog.ShareAlias = stringValue(shareAlias)
+	blog.Visibility = stringValue(visibility)
 	blog.CreatedOn = timeValue(createdOn)
 	blog.UpdatedOn = timeValue(updatedOn)
 	blog.PostedOn = timeValue(postedOn)
+	blog.Published = postedOn.Valid
 	blog.ContentHtml = stringValue(content)
 	blog.Sections, err = blog.getSections()
 	return blog, err
@@ -413,6 +507,8 @@ func (b Blog) sectionsAsHtml() string {
 					html += fmt.Sprintf("<img src=\"%s\" alt=\"%s\" data-description=\"%s\" />", image, imageTitle, imageDescID)
 				}
 			}
+		} else if section.Type == "a" {
+			html += albumAsHtml(content)
 		} else if section.Type == "p-en" {
 			html += "<p class=\"en\">" + section.Content + "</p>"
 		} else if section.Type == "p-es" {
@@ -428,12 +524,27 @@ func (b Blog) sectionsAsHtml() string {
 	return html
 }
 
+func albumAsHtml(albumId string) string {
+	id, _ := strconv.ParseInt(albumId, 10, 64)
+	album, err := AlbumGetById(id)
+	if err != nil {
+		log.Printf("Error embedding album %s: %s", albumId, err)
+		return ""
+	}
+
+	text := fmt.Sprintf("<div class=\"album\" id=\"album_%d\">", album.Id)
+	for _, photo := range album.Photos {
+		text += fmt.Sprintf("<img src=\"%s\" alt=\"%s\" data-description=\"\" />", photo, html.EscapeString(album.Name))
+	}
+	text += "</div>"
+	return text
+}
+
 func (b *Blog) getSections() ([]BlogSection, error) {
 	db, err := connectDB()
 	if err != nil {
 		return []BlogSection{}, err
 	}
-	defer db.Close()
 
 	sqlSelect := `
 		SELECT id, sectionType, content, sequence
@@ -466,37 +577,20 @@ func (b *Blog) getSections() ([]BlogSection, error) {
 	return sections, nil
 }
 
-func PhotoPath() (string, error) {
-	if photoPath != "UNSET" {
-		return photoPath, nil
-	}
-
-	// Fetch if from the database...
-	db, err := connectDB()
-	if err != nil {
-		// Bail out but don't cache a value for it, so it will be retried
-		// in the next call to getPhotoPath()
-		return "", err
-	}
-	defer db.Close()
-
-	var path sql.NullString
-	sqlSelect := "SELECT photoPath FROM settings LIMIT 1;"
-	row := db.QueryRow(sqlSelect)
-	err = row.Scan(&path)
-	if err != nil {
-		photoPath = "" // cache a value to prevent retries
-		return photoPath, err
-	}
-
-	// ...and cache it
-	photoPath = stringValue(path)
-	return photoPath, nil
+// Returns the folder on disk where the photos are stored when they are
+// served by the web server (rather than exposed via a masked path).
+func PhotoFolder() string {
+	return settings.Photos.Folder
 }
 
 func MaskPhotoPaths(text string) string {
-	path, err := PhotoPath()
-	if err != nil {
+	if PhotoFolder() != "" {
+		// Photos are served (with access control) under /photos/
+		// so there is no need to mask them.
+		return text
+	}
+	path := settings.Photos.MaskedPath
+	if path == "" {
 		return text
 	}
 	return strings.Replace(text, "/photos/", "/"+path+"/", -1)
@@ -507,7 +601,6 @@ func getIdBySlug(slug string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	defer db.Close()
 	var id int64
 	sqlSelect := "SELECT id FROM blogs WHERE slug = ? LIMIT 1"
 	row := db.QueryRow(sqlSelect, slug)
@@ -523,7 +616,6 @@ func getIdByAlias(alias string) (int64, error) {
 	if err != nil {
 		return 0, err
 	}
-	defer db.Close()
 
 	var id int64
 	sqlSelect := "SELECT id FROM blogs WHERE shareAlias = ? LIMIT 1"
@@ -536,15 +628,19 @@ func getIdByAlias(alias string) (int64, error) {
 	return id, nil
 }
 
-func getBlogsWhere(where string) ([]Blog, error) {
+// Returns the blogs that match the condition and that can be seen
+// by users with the indicated role.
+func getBlogsWhere(where string, role string) ([]Blog, error) {
 	db, err := connectDB()
 	if err != nil {
 		return nil, err
 	}
-	defer db.Close()
 
-	sqlSelect := "SELECT id, title, summary, slug, year, postedOn, thumbnail FROM blogs "
-	sqlSelect += where + " "
+	sqlSelect := "SELECT id, title, summary, slug, year, postedOn, thumbnail, visibility FROM blogs "
+	sqlSelect += "WHERE " + blogFilter(role, "") + " "
+	if where != "" {
+		sqlSelect += "AND " + where + " "
+	}
 	sqlSelect += "ORDER BY blogDate DESC"
 	rows, err := db.Query(sqlSelect)
 	if err != nil {
@@ -555,21 +651,22 @@ func getBlogsWhere(where string) ([]Blog, error) {
 	var blogs []Blog
 	var id int64
 	var year sql.NullInt64
-	var title, summary, slug, thumbnail sql.NullString
+	var title, summary, slug, thumbnail, visibility sql.NullString
 	var postedOn mysql.NullTime
 	for rows.Next() {
-		err := rows.Scan(&id, &title, &summary, &slug, &year, &postedOn, &thumbnail)
+		err := rows.Scan(&id, &title, &summary, &slug, &year, &postedOn, &thumbnail, &visibility)
 		if err != nil {
 			return nil, err
 		}
 		blog := Blog{
-			Id:        id,
-			Title:     stringValue(title),
-			Summary:   stringValue(summary),
-			Slug:      stringValue(slug),
-			Thumbnail: stringValue(thumbnail),
-			Year:      intValue(year),
-			PostedOn:  timeValue(postedOn),
+			Id:         id,
+			Title:      stringValue(title),
+			Summary:    stringValue(summary),
+			Slug:       stringValue(slug),
+			Thumbnail:  stringValue(thumbnail),
+			Year:       intValue(year),
+			PostedOn:   timeValue(postedOn),
+			Visibility: stringValue(visibility),
 		}
 		blogs = append(blogs, blog)
 	}
@@ -1,8 +1,6 @@
 package models
 
 import (
-	"regexp"
-	"strings"
 	"testing"
 )
 
@@ -63,3 +61,35 @@ func TestSlug(t *testing.T) {
 // 	// str := x[0] //+ " ** " + x[1]
 // 	t.Errorf("%s", testHtml)
 // }
+
+func TestUpgradeToHttps(t *testing.T) {
+	html := `<a href="http://example.com/a">a</a> <img src="http://www.example.com/b.jpg"> <a href="http://other.com">c</a>`
+	expected := `<a href="https://example.com/a">a</a> <img src="https://www.example.com/b.jpg"> <a href="http://other.com">c</a>`
+	upgraded := upgradeToHttps(html, []string{"example.com", "www.example.com"})
+	if upgraded != expected {
+		t.Errorf("Unexpected HTML: %s", upgraded)
+	}
+
+	if upgradeToHttps(html, nil) != html {
+		t.Errorf("HTML should not change without hosts")
+	}
+}
+
+func TestMaskPhotoPaths(t *testing.T) {
+	html := `<img src="/photos/2008/paris.jpg">`
+	if MaskPhotoPaths(html) != html {
+		t.Errorf("HTML should not change without a masked path")
+	}
+
+	settings.Photos.MaskedPath = "photos-abc"
+	defer func() { settings.Photos.MaskedPath = "" }()
+	if masked := MaskPhotoPaths(html); masked != `<img src="/photos-abc/2008/paris.jpg">` {
+		t.Errorf("Unexpected HTML: %s", masked)
+	}
+
+	settings.Photos.Folder = "/data/photos"
+	defer func() { settings.Photos.Folder = "" }()
+	if MaskPhotoPaths(html) != html {
+		t.Errorf("HTML should not change when the site serves the photos")
+	}
+}
@@ -0,0 +1,32 @@
+package models
+
+import (
+	"time"
+)
+
+// Failed login attempts are only used for throttling (which looks at
+// the last hour) and for the list that admins see, so we don't need
+// to keep them for long.
+const loginAttemptsKeep = 30 * 24 * time.Hour
+
+// Deletes the expired sessions and password reset links, and the old
+// login attempts. Meant to be run periodically.
+func CleanExpired(now time.Time) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	if err := cleanSessions(db); err != nil {
+		return err
+	}
+
+	sqlDelete := "DELETE FROM password_resets WHERE expiresOn < ?"
+	if _, err := db.Exec(sqlDelete, now.UTC()); err != nil {
+		return err
+	}
+
+	sqlDelete = "DELETE FROM login_attempts WHERE attemptedOn < ?"
+	_, err = db.Exec(sqlDelete, now.Add(-loginAttemptsKeep).UTC())
+	return err
+}
@@ -3,10 +3,11 @@ package models
 import (
 	"database/sql"
 	"fmt"
-	"os"
+	"sync"
 	"time"
 
 	"github.com/go-sql-driver/mysql"
+	"hectorcorrea.com/hk/config"
 )
 
 type DbSettings struct {
@@ -19,12 +20,22 @@ type DbSettings struct {
 
 var dbSettings DbSettings
 
-func InitDB() error {
+// Settings of the site (see InitDB). The defaults are used until then,
+// e.g. in tests.
+var settings = config.Default()
+
+// All the functions share this pool of connections, it is opened on
+// first use and closed via CloseDB when the server shuts down.
+var dbPool *sql.DB
+var dbPoolMutex sync.Mutex
+
+func InitDB(c config.Config) error {
+	settings = c
 	dbSettings = DbSettings{
-		driver:   env("DB_DRIVER", "mysql"),
-		user:     env("DB_USER", "root"),
-		password: env("DB_PASSWORD", ""),
-		database: env("DB_NAME", "hkdb"),
+		driver:   c.Database.Driver,
+		user:     c.Database.User,
+		password: c.Database.Password,
+		database: c.Database.Name,
 	}
 	dbSettings.connString = fmt.Sprintf("%s:%s@/%s?parseTime=true", dbSettings.user, dbSettings.password, dbSettings.database)
 	return CreateDefaultUsers()
@@ -34,16 +45,36 @@ func DbConnStringSafe() string {
 	return fmt.Sprintf("%s:%s@/%s", dbSettings.user, "***", dbSettings.database)
 }
 
+// Returns the shared pool of connections. Callers must not close it.
 func connectDB() (*sql.DB, error) {
-	return sql.Open(dbSettings.driver, dbSettings.connString)
+	dbPoolMutex.Lock()
+	defer dbPoolMutex.Unlock()
+	if dbPool != nil {
+		return dbPool, nil
+	}
+
+	db, err := sql.Open(dbSettings.driver, dbSettings.connString)
+	if err != nil {
+		return nil, err
+	}
+	db.SetMaxOpenConns(settings.Database.MaxOpenConns)
+	db.SetMaxIdleConns(settings.Database.MaxIdleConns)
+	db.SetConnMaxLifetime(5 * time.Minute)
+	dbPool = db
+	return dbPool, nil
 }
 
-func env(key, defaultValue string) string {
-	value := os.Getenv(key)
-	if value == "" {
-		value = defaultValue
+// Closes the pool of connections (e.g. when the server shuts down).
+// A later call to connectDB opens a new pool.
+func CloseDB() error {
+	dbPoolMutex.Lock()
+	defer dbPoolMutex.Unlock()
+	if dbPool == nil {
+		return nil
 	}
-	return value
+	err := dbPool.Close()
+	dbPool = nil
+	return err
 }
 
 // Returns UTC Now in a format that is recognized by MySQL
@@ -84,3 +115,25 @@ func intValue(v sql.NullInt64) int {
 	}
 	return 0
 }
+
+func nullString(s string) sql.NullString {
+	return sql.NullString{String: s, Valid: s != ""}
+}
+
+func boolToInt(value bool) int {
+	if value {
+		return 1
+	}
+	return 0
+}
+
+func nullTime(t time.Time) mysql.NullTime {
+	return mysql.NullTime{Time: t, Valid: !t.IsZero()}
+}
+
+// Returns true if err is MySQL's "duplicate entry" error (i.e. a row
+// violated a unique index).
+func isDuplicateKey(err error) bool {
+	mysqlErr, ok := err.(*mysql.MySQLError)
+	return ok && mysqlErr.Number == 1062
+}
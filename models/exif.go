@@ -0,0 +1,212 @@
+package models
+
+// Reads the GPS coordinates from the EXIF data of a JPEG file.
+// Only the bits of EXIF needed for that are implemented:
+//
+//	JPEG APP1 segment ("Exif\0\0" header)
+//	  TIFF header (byte order + offset to IFD0)
+//	    IFD0 -> GPS IFD pointer (tag 0x8825)
+//	      GPS IFD -> latitude/longitude and their N/S E/W references
+//
+// Reference: https://www.exif.org/Exif2-2.PDF
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"errors"
+	"io"
+	"os"
+)
+
+const (
+	exifTagGpsIfd     = 0x8825
+	exifTagLatRef     = 0x0001
+	exifTagLat        = 0x0002
+	exifTagLngRef     = 0x0003
+	exifTagLng        = 0x0004
+	exifTypeAscii     = 2
+	exifTypeLong      = 4
+	exifTypeRational  = 5
+	exifMaxEntries    = 1000
+	jpegMarkerSOI     = 0xD8
+	jpegMarkerAPP1    = 0xE1
+	jpegMarkerSOS     = 0xDA
+	jpegMaxSegmentLen = 0xFFFF
+)
+
+var errNoExif = errors.New("No EXIF data found")
+
+type exifEntry struct {
+	tag    uint16
+	format uint16
+	count  uint32
+	value  []byte // the 4 bytes of the value/offset field
+}
+
+// Returns the GPS coordinates (in decimal degrees) stored in a JPEG
+// file. found is false if the file has no GPS information.
+func PhotoGPS(path string) (lat float64, lng float64, found bool, err error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, 0, false, err
+	}
+	defer file.Close()
+
+	exif, err := jpegExifSegment(bufio.NewReader(file))
+	if err == errNoExif {
+		return 0, 0, false, nil
+	} else if err != nil {
+		return 0, 0, false, err
+	}
+	return exifGPS(exif)
+}
+
+// Returns the TIFF data of the EXIF segment of a JPEG.
+func jpegExifSegment(reader io.Reader) ([]byte, error) {
+	header := make([]byte, 2)
+	if _, err := io.ReadFull(reader, header); err != nil {
+		return nil, err
+	}
+	if header[0] != 0xFF || header[1] != jpegMarkerSOI {
+		return nil, errors.New("Not a JPEG file")
+	}
+
+	for {
+		marker := make([]byte, 4)
+		if _, err := io.ReadFull(reader, marker); err != nil {
+			if err == io.EOF || err == io.ErrUnexpectedEOF {
+				return nil, errNoExif
+			}
+			return nil, err
+		}
+		if marker[0] != 0xFF || marker[1] == jpegMarkerSOS {
+			// EXIF is always before the image data.
+			return nil, errNoExif
+		}
+
+		length := int(binary.BigEndian.Uint16(marker[2:4])) - 2
+		if length < 0 {
+			return nil, errors.New("Invalid JPEG segment length")
+		}
+		segment := make([]byte, length)
+		if _, err := io.ReadFull(reader, segment); err != nil {
+			return nil, err
+		}
+
+		if marker[1] == jpegMarkerAPP1 && bytes.HasPrefix(segment, []byte("Exif\x00\x00")) {
+			return segment[6:], nil
+		}
+	}
+}
+
+func exifGPS(tiff []byte) (float64, float64, bool, error) {
+	order, ifd0, err := tiffHeader(tiff)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	entries, err := exifEntries(tiff, order, ifd0)
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	gpsIfd, found := entries[exifTagGpsIfd]
+	if !found || gpsIfd.format != exifTypeLong {
+		return 0, 0, false, nil
+	}
+
+	gps, err := exifEntries(tiff, order, order.Uint32(gpsIfd.value))
+	if err != nil {
+		return 0, 0, false, err
+	}
+
+	lat, err := exifDegrees(tiff, order, gps[exifTagLat], gps[exifTagLatRef], "S")
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	lng, err := exifDegrees(tiff, order, gps[exifTagLng], gps[exifTagLngRef], "W")
+	if err != nil {
+		return 0, 0, false, nil
+	}
+	return lat, lng, true, nil
+}
+
+func tiffHeader(tiff []byte) (binary.ByteOrder, uint32, error) {
+	if len(tiff) < 8 {
+		return nil, 0, errors.New("Invalid TIFF header")
+	}
+
+	var order binary.ByteOrder
+	switch string(tiff[0:2]) {
+	case "II":
+		order = binary.LittleEndian
+	case "MM":
+		order = binary.BigEndian
+	default:
+		return nil, 0, errors.New("Invalid TIFF byte order")
+	}
+
+	if order.Uint16(tiff[2:4]) != 42 {
+		return nil, 0, errors.New("Invalid TIFF magic number")
+	}
+	return order, order.Uint32(tiff[4:8]), nil
+}
+
+func exifEntries(tiff []byte, order binary.ByteOrder, offset uint32) (map[uint16]exifEntry, error) {
+	if int(offset)+2 > len(tiff) {
+		return nil, errors.New("Invalid IFD offset")
+	}
+
+	count := int(order.Uint16(tiff[offset : offset+2]))
+	if count > exifMaxEntries {
+		return nil, errors.New("Too many IFD entries")
+	}
+
+	entries := map[uint16]exifEntry{}
+	start := int(offset) + 2
+	for i := 0; i < count; i++ {
+		pos := start + i*12
+		if pos+12 > len(tiff) {
+			return nil, errors.New("Truncated IFD")
+		}
+		entry := exifEntry{
+			tag:    order.Uint16(tiff[pos : pos+2]),
+			format: order.Uint16(tiff[pos+2 : pos+4]),
+			count:  order.Uint32(tiff[pos+4 : pos+8]),
+			value:  tiff[pos+8 : pos+12],
+		}
+		entries[entry.tag] = entry
+	}
+	return entries, nil
+}
+
+// Converts a degrees/minutes/seconds value to decimal degrees. The value
+// is negative when the reference (e.g. "S" or "W") is the negative one.
+func exifDegrees(tiff []byte, order binary.ByteOrder, value exifEntry, ref exifEntry, negativeRef string) (float64, error) {
+	if value.format != exifTypeRational || value.count != 3 {
+		return 0, errors.New("Invalid GPS coordinate")
+	}
+
+	offset := int(order.Uint32(value.value))
+	if offset+24 > len(tiff) {
+		return 0, errors.New("Invalid GPS coordinate offset")
+	}
+
+	parts := []float64{}
+	for i := 0; i < 3; i++ {
+		pos := offset + i*8
+		numerator := order.Uint32(tiff[pos : pos+4])
+		denominator := order.Uint32(tiff[pos+4 : pos+8])
+		if denominator == 0 {
+			return 0, errors.New("Invalid GPS coordinate (zero denominator)")
+		}
+		parts = append(parts, float64(numerator)/float64(denominator))
+	}
+
+	degrees := parts[0] + parts[1]/60 + parts[2]/3600
+	if ref.format == exifTypeAscii && ref.count > 0 && string(ref.value[0]) == negativeRef {
+		degrees = -degrees
+	}
+	return degrees, nil
+}
@@ -0,0 +1,111 @@
+package models
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io/ioutil"
+	"math"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+// Builds a minimal JPEG with an EXIF segment that only has GPS data.
+func testJpegWithGPS(order binary.ByteOrder, latRef string, lat [3]uint32, lngRef string, lng [3]uint32) []byte {
+	tiff := &bytes.Buffer{}
+	if order == binary.LittleEndian {
+		tiff.WriteString("II")
+	} else {
+		tiff.WriteString("MM")
+	}
+	binary.Write(tiff, order, uint16(42))
+	binary.Write(tiff, order, uint32(8)) // IFD0 right after the header
+
+	// IFD0 with a single entry pointing to the GPS IFD
+	gpsOffset := uint32(8 + 2 + 12 + 4)
+	binary.Write(tiff, order, uint16(1))
+	binary.Write(tiff, order, uint16(exifTagGpsIfd))
+	binary.Write(tiff, order, uint16(exifTypeLong))
+	binary.Write(tiff, order, uint32(1))
+	binary.Write(tiff, order, gpsOffset)
+	binary.Write(tiff, order, uint32(0)) // no next IFD
+
+	// GPS IFD with 4 entries, followed by the rational values
+	valuesOffset := gpsOffset + 2 + 4*12 + 4
+	binary.Write(tiff, order, uint16(4))
+	writeAscii := func(tag uint16, value string) {
+		binary.Write(tiff, order, tag)
+		binary.Write(tiff, order, uint16(exifTypeAscii))
+		binary.Write(tiff, order, uint32(2))
+		tiff.Write([]byte{value[0], 0, 0, 0})
+	}
+	writeRational := func(tag uint16, offset uint32) {
+		binary.Write(tiff, order, tag)
+		binary.Write(tiff, order, uint16(exifTypeRational))
+		binary.Write(tiff, order, uint32(3))
+		binary.Write(tiff, order, offset)
+	}
+	writeAscii(exifTagLatRef, latRef)
+	writeRational(exifTagLat, valuesOffset)
+	writeAscii(exifTagLngRef, lngRef)
+	writeRational(exifTagLng, valuesOffset+24)
+	binary.Write(tiff, order, uint32(0))
+	for _, value := range append(lat[:], lng[:]...) {
+		binary.Write(tiff, order, value)
+		binary.Write(tiff, order, uint32(1))
+	}
+
+	jpeg := &bytes.Buffer{}
+	jpeg.Write([]byte{0xFF, jpegMarkerSOI})
+	jpeg.Write([]byte{0xFF, jpegMarkerAPP1})
+	binary.Write(jpeg, binary.BigEndian, uint16(2+6+tiff.Len()))
+	jpeg.WriteString("Exif\x00\x00")
+	jpeg.Write(tiff.Bytes())
+	jpeg.Write([]byte{0xFF, jpegMarkerSOS, 0x00, 0x02})
+	return jpeg.Bytes()
+}
+
+func TestPhotoGPS(t *testing.T) {
+	folder, err := ioutil.TempDir("", "exif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	orders := []binary.ByteOrder{binary.LittleEndian, binary.BigEndian}
+	for _, order := range orders {
+		// 12° 3' 36" S, 77° 1' 48" W (Lima)
+		data := testJpegWithGPS(order, "S", [3]uint32{12, 3, 36}, "W", [3]uint32{77, 1, 48})
+		path := filepath.Join(folder, "lima.jpg")
+		if err := ioutil.WriteFile(path, data, 0644); err != nil {
+			t.Fatal(err)
+		}
+
+		lat, lng, found, err := PhotoGPS(path)
+		if err != nil || !found {
+			t.Fatalf("GPS not found (%s): %v", order, err)
+		}
+		if math.Abs(lat-(-12.06)) > 0.0001 || math.Abs(lng-(-77.03)) > 0.0001 {
+			t.Errorf("Unexpected coordinates (%s): %f, %f", order, lat, lng)
+		}
+	}
+}
+
+func TestPhotoGPSNoExif(t *testing.T) {
+	folder, err := ioutil.TempDir("", "exif")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(folder)
+
+	path := filepath.Join(folder, "plain.jpg")
+	data := []byte{0xFF, jpegMarkerSOI, 0xFF, jpegMarkerSOS, 0x00, 0x02}
+	if err := ioutil.WriteFile(path, data, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	_, _, found, err := PhotoGPS(path)
+	if err != nil || found {
+		t.Errorf("Unexpected GPS data found in a file without EXIF: %v", err)
+	}
+}
@@ -0,0 +1,256 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// An invite lets somebody create their own account (choosing their
+// login and password) with the role that the admin assigned to the
+// invite. Invites expire, can be revoked, and can only be accepted
+// once. Like password resets, we only store the hash of the token so
+// the link is only shown when the invite is created.
+type Invite struct {
+	Id         int64
+	Role       string
+	Note       string // who is it for
+	Email      string // optional, given to the new user
+	CreatedBy  string
+	CreatedOn  time.Time
+	ExpiresOn  time.Time
+	AcceptedOn time.Time
+	AcceptedBy string // login of the user created with the invite
+	RevokedOn  time.Time
+}
+
+var ErrInviteInvalid = errors.New("The invite is not valid, has expired, or has already been used")
+
+const inviteColumns = `id, role, note, email, createdBy, createdOn, expiresOn,
+	acceptedOn, acceptedBy, revokedOn`
+
+func (i Invite) Status(now time.Time) string {
+	switch {
+	case !i.AcceptedOn.IsZero():
+		return "accepted"
+	case !i.RevokedOn.IsZero():
+		return "revoked"
+	case !now.Before(i.ExpiresOn):
+		return "expired"
+	default:
+		return "pending"
+	}
+}
+
+func (i Invite) IsPending(now time.Time) bool {
+	return i.Status(now) == "pending"
+}
+
+func inviteHash(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// Logins are what users type to login, keep them simple.
+func IsValidLogin(login string) bool {
+	if login == "" || len(login) > 100 {
+		return false
+	}
+	return !strings.ContainsAny(login, " \t\r\n/\\<>\"'")
+}
+
+// Creates a new invite and returns it with its token.
+func InviteNew(role, note, email, createdBy string, days int) (Invite, string, error) {
+	if !IsValidUserType(role) {
+		return Invite{}, "", fmt.Errorf("Invalid role (%s)", role)
+	}
+	if days <= 0 {
+		return Invite{}, "", errors.New("Invites must expire")
+	}
+	email = strings.TrimSpace(email)
+	if email != "" && !strings.Contains(email, "@") {
+		return Invite{}, "", fmt.Errorf("Invalid e-mail address (%s)", email)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return Invite{}, "", err
+	}
+
+	token, err := newId()
+	if err != nil {
+		return Invite{}, "", err
+	}
+
+	now := time.Now().UTC().Truncate(time.Second)
+	invite := Invite{
+		Role:      role,
+		Note:      note,
+		Email:     email,
+		CreatedBy: createdBy,
+		CreatedOn: now,
+		ExpiresOn: now.AddDate(0, 0, days),
+	}
+	sqlInsert := `
+		INSERT INTO invites(tokenHash, role, note, email, createdBy, createdOn, expiresOn)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`
+	result, err := db.Exec(sqlInsert, inviteHash(token), invite.Role, invite.Note,
+		nullString(invite.Email), invite.CreatedBy, invite.CreatedOn, invite.ExpiresOn)
+	if err != nil {
+		return Invite{}, "", err
+	}
+	invite.Id, err = result.LastInsertId()
+	return invite, token, err
+}
+
+func InviteGetAll() ([]Invite, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT " + inviteColumns + " FROM invites ORDER BY createdOn DESC")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	invites := []Invite{}
+	for rows.Next() {
+		invite, err := scanInvite(rows)
+		if err != nil {
+			return nil, err
+		}
+		invites = append(invites, invite)
+	}
+	return invites, rows.Err()
+}
+
+// Returns the invite for the token if it can still be accepted.
+func InviteFromToken(token string) (Invite, error) {
+	db, err := connectDB()
+	if err != nil {
+		return Invite{}, err
+	}
+
+	row := db.QueryRow("SELECT "+inviteColumns+" FROM invites WHERE tokenHash = ?", inviteHash(token))
+	invite, err := scanInvite(row)
+	if err == sql.ErrNoRows || (err == nil && !invite.IsPending(time.Now().UTC())) {
+		return Invite{}, ErrInviteInvalid
+	}
+	return invite, err
+}
+
+// Creates the user for the invite and marks the invite as accepted
+// by them.
+func InviteAccept(token, login, password string) (Invite, error) {
+	login = strings.TrimSpace(login)
+	if !IsValidLogin(login) {
+		return Invite{}, errors.New("Login cannot be empty or include spaces, quotes, or slashes")
+	}
+	if password == "" {
+		return Invite{}, errors.New("Password cannot be empty")
+	}
+
+	invite, err := InviteFromToken(token)
+	if err != nil {
+		return Invite{}, err
+	}
+
+	hashedPwd, err := hashPassword(password)
+	if err != nil {
+		return Invite{}, err
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return Invite{}, err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return Invite{}, err
+	}
+
+	var count int
+	if err := tx.QueryRow("SELECT count(*) FROM users WHERE login = ?", login).Scan(&count); err != nil {
+		tx.Rollback()
+		return Invite{}, err
+	}
+	if count > 0 {
+		tx.Rollback()
+		return Invite{}, loginTakenError(login)
+	}
+
+	// The conditions make sure the invite is only accepted once even
+	// with concurrent requests.
+	now := time.Now().UTC().Truncate(time.Second)
+	sqlUpdate := `
+		UPDATE invites
+		SET acceptedOn = ?, acceptedBy = ?
+		WHERE id = ? AND acceptedOn IS NULL AND revokedOn IS NULL AND expiresOn > ?`
+	result, err := tx.Exec(sqlUpdate, now, login, invite.Id, now)
+	if err != nil {
+		tx.Rollback()
+		return Invite{}, err
+	}
+	if updated, err := result.RowsAffected(); err != nil || updated != 1 {
+		tx.Rollback()
+		return Invite{}, ErrInviteInvalid
+	}
+
+	sqlInsert := `INSERT INTO users(login, name, password, type, email) VALUES(?, ?, ?, ?, ?)`
+	_, err = tx.Exec(sqlInsert, login, login, hashedPwd, invite.Role, nullString(invite.Email))
+	if err != nil {
+		tx.Rollback()
+		if isDuplicateKey(err) {
+			// Somebody else took the login since we checked.
+			return Invite{}, loginTakenError(login)
+		}
+		return Invite{}, err
+	}
+
+	invite.AcceptedOn = now
+	invite.AcceptedBy = login
+	return invite, tx.Commit()
+}
+
+func loginTakenError(login string) error {
+	return fmt.Errorf("Login %s is already taken, please choose another one", login)
+}
+
+func InviteRevoke(id int64) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	sqlUpdate := `UPDATE invites SET revokedOn = ? WHERE id = ? AND revokedOn IS NULL AND acceptedOn IS NULL`
+	_, err = db.Exec(sqlUpdate, time.Now().UTC(), id)
+	return err
+}
+
+func scanInvite(row rowScanner) (Invite, error) {
+	var invite Invite
+	var note, email, acceptedBy sql.NullString
+	var acceptedOn, revokedOn mysql.NullTime
+	err := row.Scan(&invite.Id, &invite.Role, &note, &email, &invite.CreatedBy,
+		&invite.CreatedOn, &invite.ExpiresOn, &acceptedOn, &acceptedBy, &revokedOn)
+	if err != nil {
+		return Invite{}, err
+	}
+	invite.Note = stringValue(note)
+	invite.Email = stringValue(email)
+	invite.AcceptedBy = stringValue(acceptedBy)
+	if acceptedOn.Valid {
+		invite.AcceptedOn = acceptedOn.Time
+	}
+	if revokedOn.Valid {
+		invite.RevokedOn = revokedOn.Time
+	}
+	return invite, nil
+}
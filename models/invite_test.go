@@ -0,0 +1,58 @@
+package models
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+func TestInviteStatus(t *testing.T) {
+	now := time.Date(2020, 1, 10, 0, 0, 0, 0, time.UTC)
+	invite := Invite{ExpiresOn: now.AddDate(0, 0, 1)}
+	if invite.Status(now) != "pending" || !invite.IsPending(now) {
+		t.Errorf("New invite should be pending: %s", invite.Status(now))
+	}
+
+	if invite.Status(now.AddDate(0, 0, 1)) != "expired" {
+		t.Errorf("Invite should have expired")
+	}
+
+	revoked := invite
+	revoked.RevokedOn = now
+	if revoked.Status(now) != "revoked" || revoked.IsPending(now) {
+		t.Errorf("Invite should be revoked")
+	}
+
+	accepted := invite
+	accepted.AcceptedOn = now
+	if accepted.Status(now.AddDate(0, 0, 5)) != "accepted" {
+		t.Errorf("Accepted invites should stay accepted after they expire")
+	}
+}
+
+func TestIsValidLogin(t *testing.T) {
+	valid := []string{"user1", "karla.c", "someone@somewhere.com"}
+	for _, login := range valid {
+		if !IsValidLogin(login) {
+			t.Errorf("Login should be valid: %s", login)
+		}
+	}
+
+	invalid := []string{"", "with space", "a/b", "<script>", "quote'"}
+	for _, login := range invalid {
+		if IsValidLogin(login) {
+			t.Errorf("Login should not be valid: %s", login)
+		}
+	}
+}
+
+func TestIsDuplicateKey(t *testing.T) {
+	if !isDuplicateKey(&mysql.MySQLError{Number: 1062, Message: "Duplicate entry"}) {
+		t.Errorf("Duplicate entry error not detected")
+	}
+	if isDuplicateKey(&mysql.MySQLError{Number: 1045}) || isDuplicateKey(errors.New("1062")) {
+		t.Errorf("Other errors detected as duplicates")
+	}
+}
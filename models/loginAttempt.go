@@ -0,0 +1,191 @@
+package models
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
+)
+
+// A failed login attempt.
+type LoginAttempt struct {
+	Login       string
+	Ip          string
+	AttemptedOn time.Time
+}
+
+// Keeps track of the failed login attempts so that we can throttle
+// clients that keep trying.
+type LoginAttemptStore interface {
+	Add(attempt LoginAttempt) error
+	// Forgets the failed attempts for a login (e.g. after a successful one)
+	Clear(login string) error
+	CountByLogin(login string, since time.Time) (int, time.Time, error)
+	CountByIp(ip string, since time.Time) (int, time.Time, error)
+	Recent(limit int) ([]LoginAttempt, error)
+}
+
+// Returns the store indicated by kind ("db" or "memory").
+func NewLoginAttemptStore(kind string) LoginAttemptStore {
+	if kind == "db" {
+		return &DbLoginAttempts{}
+	}
+	return &MemoryLoginAttempts{}
+}
+
+// In-memory store, the attempts are lost when the server is restarted.
+type MemoryLoginAttempts struct {
+	mutex    sync.Mutex
+	attempts []LoginAttempt
+}
+
+// Attempts older than this are discarded by the in-memory store.
+const memoryLoginAttemptsTTL = 24 * time.Hour
+
+func (m *MemoryLoginAttempts) Add(attempt LoginAttempt) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	cutoff := attempt.AttemptedOn.Add(-memoryLoginAttemptsTTL)
+	attempts := []LoginAttempt{}
+	for _, a := range m.attempts {
+		if a.AttemptedOn.After(cutoff) {
+			attempts = append(attempts, a)
+		}
+	}
+	m.attempts = append(attempts, attempt)
+	return nil
+}
+
+func (m *MemoryLoginAttempts) Clear(login string) error {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	attempts := []LoginAttempt{}
+	for _, a := range m.attempts {
+		if a.Login != login {
+			attempts = append(attempts, a)
+		}
+	}
+	m.attempts = attempts
+	return nil
+}
+
+func (m *MemoryLoginAttempts) CountByLogin(login string, since time.Time) (int, time.Time, error) {
+	return m.count(func(a LoginAttempt) bool { return a.Login == login }, since)
+}
+
+func (m *MemoryLoginAttempts) CountByIp(ip string, since time.Time) (int, time.Time, error) {
+	return m.count(func(a LoginAttempt) bool { return a.Ip == ip }, since)
+}
+
+func (m *MemoryLoginAttempts) count(match func(LoginAttempt) bool, since time.Time) (int, time.Time, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	count := 0
+	last := time.Time{}
+	for _, a := range m.attempts {
+		if match(a) && !a.AttemptedOn.Before(since) {
+			count += 1
+			if a.AttemptedOn.After(last) {
+				last = a.AttemptedOn
+			}
+		}
+	}
+	return count, last, nil
+}
+
+func (m *MemoryLoginAttempts) Recent(limit int) ([]LoginAttempt, error) {
+	m.mutex.Lock()
+	defer m.mutex.Unlock()
+
+	attempts := make([]LoginAttempt, len(m.attempts))
+	copy(attempts, m.attempts)
+	sort.Slice(attempts, func(i, j int) bool {
+		return attempts[i].AttemptedOn.After(attempts[j].AttemptedOn)
+	})
+	if len(attempts) > limit {
+		attempts = attempts[0:limit]
+	}
+	return attempts, nil
+}
+
+// Store backed by the login_attempts table.
+type DbLoginAttempts struct{}
+
+func (d *DbLoginAttempts) Add(attempt LoginAttempt) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	sqlInsert := `INSERT INTO login_attempts(login, ip, attemptedOn) VALUES(?, ?, ?)`
+	_, err = db.Exec(sqlInsert, attempt.Login, attempt.Ip, attempt.AttemptedOn.UTC())
+	return err
+}
+
+func (d *DbLoginAttempts) Clear(login string) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	sqlDelete := `DELETE FROM login_attempts WHERE login = ?`
+	_, err = db.Exec(sqlDelete, login)
+	return err
+}
+
+func (d *DbLoginAttempts) CountByLogin(login string, since time.Time) (int, time.Time, error) {
+	sqlSelect := `SELECT count(*), max(attemptedOn) FROM login_attempts WHERE login = ? AND attemptedOn >= ?`
+	return d.count(sqlSelect, login, since)
+}
+
+func (d *DbLoginAttempts) CountByIp(ip string, since time.Time) (int, time.Time, error) {
+	sqlSelect := `SELECT count(*), max(attemptedOn) FROM login_attempts WHERE ip = ? AND attemptedOn >= ?`
+	return d.count(sqlSelect, ip, since)
+}
+
+func (d *DbLoginAttempts) count(sqlSelect string, key string, since time.Time) (int, time.Time, error) {
+	db, err := connectDB()
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+
+	var count int
+	var last mysql.NullTime
+	err = db.QueryRow(sqlSelect, key, since.UTC()).Scan(&count, &last)
+	if err != nil {
+		return 0, time.Time{}, err
+	}
+	return count, last.Time, nil
+}
+
+func (d *DbLoginAttempts) Recent(limit int) ([]LoginAttempt, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := `
+		SELECT login, ip, attemptedOn
+		FROM login_attempts
+		ORDER BY attemptedOn DESC
+		LIMIT ?`
+	rows, err := db.Query(sqlSelect, limit)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	attempts := []LoginAttempt{}
+	for rows.Next() {
+		var attempt LoginAttempt
+		if err := rows.Scan(&attempt.Login, &attempt.Ip, &attempt.AttemptedOn); err != nil {
+			return nil, err
+		}
+		attempts = append(attempts, attempt)
+	}
+	return attempts, rows.Err()
+}
@@ -0,0 +1,115 @@
+package models
+
+import (
+	"database/sql"
+	"strings"
+)
+
+// A location to show on a map, either a blog (located at the average
+// of its geotagged photos) or a single photo.
+type MapPoint struct {
+	BlogId    int64
+	Title     string
+	Url       string
+	Thumbnail string
+	Latitude  float64
+	Longitude float64
+}
+
+type MapTiles struct {
+	Url         string // e.g. https://tile.openstreetmap.org/{z}/{x}/{y}.png
+	Attribution string
+}
+
+// Photos are referenced in the blogs by their thumbnail but it's
+// usually the full size version the one that has the GPS data.
+const sqlJoinBlogPhotos = `
+	INNER JOIN blogs_photos bp ON bp.blog_id = b.id
+	INNER JOIN photos p ON p.url = bp.path OR p.url = REPLACE(bp.path, '_thumb.jpg', '.jpg')`
+
+// Returns one point for each blog that has geotagged photos (and that
+// users with the indicated role can see).
+func MapBlogs(role string) ([]MapPoint, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := `
+		SELECT b.id, b.title, b.slug, b.year, b.thumbnail,
+			AVG(p.latitude), AVG(p.longitude)
+		FROM blogs b ` + sqlJoinBlogPhotos + `
+		WHERE p.latitude IS NOT NULL AND p.longitude IS NOT NULL
+			AND ` + blogFilter(role, "b.") + `
+		GROUP BY b.id, b.title, b.slug, b.year, b.thumbnail`
+	rows, err := db.Query(sqlSelect)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MapPoint{}
+	for rows.Next() {
+		var id int64
+		var year sql.NullInt64
+		var title, slug, thumbnail sql.NullString
+		var lat, lng float64
+		err := rows.Scan(&id, &title, &slug, &year, &thumbnail, &lat, &lng)
+		if err != nil {
+			return nil, err
+		}
+		blog := Blog{Id: id, Slug: stringValue(slug), Year: intValue(year)}
+		point := MapPoint{
+			BlogId:    id,
+			Title:     stringValue(title),
+			Url:       blog.URL(""),
+			Thumbnail: stringValue(thumbnail),
+			Latitude:  lat,
+			Longitude: lng,
+		}
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
+
+// Returns one point for each geotagged photo in a blog.
+func MapBlogPhotos(blogId int64) ([]MapPoint, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := `
+		SELECT MIN(bp.path), AVG(p.latitude), AVG(p.longitude)
+		FROM blogs b ` + sqlJoinBlogPhotos + `
+		WHERE b.id = ? AND p.latitude IS NOT NULL AND p.longitude IS NOT NULL
+		GROUP BY REPLACE(p.url, '_thumb.jpg', '.jpg')`
+	rows, err := db.Query(sqlSelect, blogId)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	points := []MapPoint{}
+	for rows.Next() {
+		var path string
+		var lat, lng float64
+		if err := rows.Scan(&path, &lat, &lng); err != nil {
+			return nil, err
+		}
+		point := MapPoint{
+			BlogId:    blogId,
+			Url:       strings.Replace(path, "_thumb.jpg", ".jpg", 1),
+			Thumbnail: path,
+			Latitude:  lat,
+			Longitude: lng,
+		}
+		points = append(points, point)
+	}
+	return points, rows.Err()
+}
+
+// Returns the tile server to use for the maps (see config.Map).
+func MapTileSettings() MapTiles {
+	return MapTiles{Url: settings.Map.TileUrl, Attribution: settings.Map.TileAttribution}
+}
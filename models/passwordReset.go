@@ -0,0 +1,133 @@
+package models
+
+import (
+	"crypto/sha256"
+	"database/sql"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Password resets are requested by users that forgot their password.
+// The token is e-mailed to them and can only be used once before it
+// expires (PASSWORD_RESET_MINUTES, default 60). We only store the hash
+// of the token.
+
+var ErrResetInvalid = errors.New("The reset link is not valid or has expired")
+
+func PasswordResetMinutes() int {
+	return settings.Auth.PasswordResetMinutes
+}
+
+func passwordResetHash(token string) string {
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+}
+
+// Creates a reset token for the user with the given login or e-mail.
+// Returns the user so that the caller can e-mail them the token.
+// Returns sql.ErrNoRows when there is no (enabled) user with an
+// e-mail for it.
+func PasswordResetNew(loginOrEmail string, ip string) (User, string, error) {
+	loginOrEmail = strings.TrimSpace(loginOrEmail)
+	if loginOrEmail == "" {
+		return User{}, "", sql.ErrNoRows
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return User{}, "", err
+	}
+
+	sqlSelect := `
+		SELECT ` + userColumns + `
+		FROM users
+		WHERE (login = ? OR email = ?) AND disabled = 0 AND email IS NOT NULL AND email <> ''
+		LIMIT 1`
+	user, err := scanUser(db.QueryRow(sqlSelect, loginOrEmail, loginOrEmail))
+	if err != nil {
+		return User{}, "", err
+	}
+
+	token, err := newId()
+	if err != nil {
+		return User{}, "", err
+	}
+
+	now := time.Now().UTC()
+	expiresOn := now.Add(time.Duration(PasswordResetMinutes()) * time.Minute)
+	sqlInsert := `
+		INSERT INTO password_resets(tokenHash, userId, createdOn, expiresOn, ip)
+		VALUES(?, ?, ?, ?, ?)`
+	_, err = db.Exec(sqlInsert, passwordResetHash(token), user.Id, now, expiresOn, ip)
+	if err != nil {
+		return User{}, "", err
+	}
+	return user, token, nil
+}
+
+// Returns the user for a reset token that has not been used and has
+// not expired.
+func PasswordResetUser(token string) (User, error) {
+	db, err := connectDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	sqlSelect := `
+		SELECT ` + userColumns + `
+		FROM password_resets r INNER JOIN users ON r.userId = users.id
+		WHERE r.tokenHash = ? AND r.usedOn IS NULL AND r.expiresOn > ? AND users.disabled = 0`
+	user, err := scanUser(db.QueryRow(sqlSelect, passwordResetHash(token), time.Now().UTC()))
+	if err == sql.ErrNoRows {
+		return User{}, ErrResetInvalid
+	}
+	return user, err
+}
+
+// Sets the new password for the user of the token and marks the token
+// as used. Other reset tokens and all the sessions of the user are
+// deleted since whoever had them might not be the user.
+func PasswordResetUse(token string, newPassword string) (User, error) {
+	if newPassword == "" {
+		return User{}, errors.New("Password cannot be empty")
+	}
+
+	user, err := PasswordResetUser(token)
+	if err != nil {
+		return User{}, err
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	// Mark it as used first (and atomically) so that the token cannot
+	// be used twice by concurrent requests.
+	sqlUpdate := `
+		UPDATE password_resets
+		SET usedOn = ?
+		WHERE tokenHash = ? AND usedOn IS NULL AND expiresOn > ?`
+	now := time.Now().UTC()
+	result, err := db.Exec(sqlUpdate, now, passwordResetHash(token), now)
+	if err != nil {
+		return User{}, err
+	}
+	if count, err := result.RowsAffected(); err != nil || count != 1 {
+		return User{}, ErrResetInvalid
+	}
+
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return User{}, err
+	}
+	if _, err = db.Exec("UPDATE users SET password = ? WHERE id = ?", hashedPassword, user.Id); err != nil {
+		return User{}, err
+	}
+	if _, err = db.Exec("DELETE FROM password_resets WHERE userId = ? AND usedOn IS NULL", user.Id); err != nil {
+		return User{}, err
+	}
+	_, err = db.Exec("DELETE FROM sessions WHERE userId = ?", user.Id)
+	return user, err
+}
@@ -2,45 +2,319 @@ package models
 
 import (
 	"database/sql"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/go-sql-driver/mysql"
 )
 
 type Photo struct {
-	Id   int64
-	Path string
+	Id         int64
+	Path       string
+	Url        string // URL used in the blogs for the photo (e.g. /photos/2008/paris.jpg)
+	Hash       string
+	OnDisk     bool
+	Size       int64
+	ModifiedOn time.Time // modification time of the file on disk
+	HasGPS     bool
+	Latitude   float64
+	Longitude  float64
 }
 
-func PhotoExists(path string) (bool, error) {
+const photoColumns = "id, path, url, hash, on_disk, size, modifiedOn, latitude, longitude"
+
+func PhotoGetAll() ([]Photo, error) {
 	db, err := connectDB()
 	if err != nil {
-		return false, err
+		return nil, err
 	}
-	defer db.Close()
 
-	var id int64
-	sqlSelect := `SELECT id FROM photos WHERE path = ?`
-	err = db.QueryRow(sqlSelect, path).Scan(&id)
-	if err == sql.ErrNoRows {
-		return false, nil
+	sqlSelect := `SELECT ` + photoColumns + ` FROM photos`
+	return queryPhotos(db, sqlSelect)
+}
+
+// Saves a batch of photos in a single transaction. Photos without
+// an Id are inserted (a single INSERT for all of them), the rest are
+// updated.
+func PhotoSaveBatch(photos []Photo) error {
+	if len(photos) == 0 {
+		return nil
 	}
-	return true, err
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+
+	newPhotos := []Photo{}
+	for _, photo := range photos {
+		if photo.Id == 0 {
+			newPhotos = append(newPhotos, photo)
+			continue
+		}
+		sqlUpdate := `UPDATE photos
+			SET path = ?, url = ?, hash = ?, on_disk = ?, size = ?, modifiedOn = ?,
+				latitude = ?, longitude = ?
+			WHERE id = ?`
+		lat, lng := photo.coordinates()
+		_, err = tx.Exec(sqlUpdate, photo.Path, nullString(photo.Url), nullString(photo.Hash),
+			boolToInt(photo.OnDisk), photo.Size, nullTime(photo.ModifiedOn), lat, lng, photo.Id)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	if len(newPhotos) > 0 {
+		values := []interface{}{}
+		placeholders := []string{}
+		for _, photo := range newPhotos {
+			lat, lng := photo.coordinates()
+			placeholders = append(placeholders, "(?, ?, ?, ?, ?, ?, ?, ?)")
+			values = append(values, photo.Path, nullString(photo.Url), nullString(photo.Hash),
+				boolToInt(photo.OnDisk), photo.Size, nullTime(photo.ModifiedOn), lat, lng)
+		}
+		sqlInsert := `INSERT INTO photos(path, url, hash, on_disk, size, modifiedOn, latitude, longitude) VALUES ` +
+			strings.Join(placeholders, ", ")
+		_, err = tx.Exec(sqlInsert, values...)
+		if err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+
+	return tx.Commit()
 }
 
-func PhotoAdd(path string, onDisk bool) (int64, error) {
+// Flags the given photos as being (or not) on disk.
+func PhotoSetOnDisk(ids []int64, onDisk bool) error {
+	if len(ids) == 0 {
+		return nil
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	values := []interface{}{boolToInt(onDisk)}
+	placeholders := []string{}
+	for _, id := range ids {
+		placeholders = append(placeholders, "?")
+		values = append(values, id)
+	}
+	sqlUpdate := fmt.Sprintf(`UPDATE photos SET on_disk = ? WHERE id IN (%s)`, strings.Join(placeholders, ", "))
+	_, err = db.Exec(sqlUpdate, values...)
+	return err
+}
+
+// Updates the references to a photo URL in the blogs_photos table.
+func BlogPhotosMove(oldUrl, newUrl string) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	sqlUpdate := `UPDATE blogs_photos SET path = ? WHERE path = ?`
+	_, err = db.Exec(sqlUpdate, newUrl, oldUrl)
+	return err
+}
+
+// Rewrites the content of the blogs (and their sections) that reference
+// oldUrl so that they reference newUrl instead. Returns the number of
+// rows updated.
+//
+// Only quoted URLs (e.g. src="/photos/a.jpg") and the lines of the
+// photo sections that are exactly oldUrl are replaced so that other URLs
+// that start with oldUrl (e.g. /photos/a.jpg.bak) are left alone.
+func BlogContentReplace(oldUrl, newUrl string) (int64, error) {
 	db, err := connectDB()
 	if err != nil {
 		return 0, err
 	}
-	defer db.Close()
 
-	var onDiskInt int
-	if onDisk {
-		onDiskInt = 1
+	var count int64
+	sqlUpdates := []string{
+		`UPDATE blog_sections SET content = REPLACE(content, ?, ?) WHERE INSTR(content, ?) > 0`,
+		`UPDATE blogs SET content = REPLACE(content, ?, ?) WHERE INSTR(content, ?) > 0`,
+	}
+	for _, quote := range []string{"\"", "'"} {
+		oldValue := quote + oldUrl + quote
+		newValue := quote + newUrl + quote
+		for _, sqlUpdate := range sqlUpdates {
+			result, err := db.Exec(sqlUpdate, oldValue, newValue, oldValue)
+			if err != nil {
+				return count, err
+			}
+			rows, _ := result.RowsAffected()
+			count += rows
+		}
+	}
+
+	rows, err := photoSectionsReplace(db, oldUrl, newUrl)
+	count += rows
+	if err != nil {
+		return count, err
 	}
-	sqlInsert := `INSERT INTO photos(path, on_disk) VALUES(?, ?)`
-	result, err := db.Exec(sqlInsert, path, onDiskInt)
+
+	sqlUpdate := `UPDATE blogs SET thumbnail = ? WHERE thumbnail = ?`
+	result, err := db.Exec(sqlUpdate, newUrl, oldUrl)
+	if err != nil {
+		return count, err
+	}
+	rows, _ = result.RowsAffected()
+	return count + rows, nil
+}
+
+// Photo sections store the bare URLs of their photos, one per line.
+func photoSectionsReplace(db *sql.DB, oldUrl, newUrl string) (int64, error) {
+	sqlSelect := `SELECT id, content FROM blog_sections WHERE sectionType = 'i' AND INSTR(content, ?) > 0`
+	rows, err := db.Query(sqlSelect, oldUrl)
 	if err != nil {
 		return 0, err
 	}
+	defer rows.Close()
+
+	updated := map[int64]string{}
+	for rows.Next() {
+		var id int64
+		var content string
+		if err := rows.Scan(&id, &content); err != nil {
+			return 0, err
+		}
+		if newContent, changed := replacePhotoLines(content, oldUrl, newUrl); changed {
+			updated[id] = newContent
+		}
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	var count int64
+	for id, content := range updated {
+		sqlUpdate := `UPDATE blog_sections SET content = ? WHERE id = ?`
+		if _, err := db.Exec(sqlUpdate, content, id); err != nil {
+			return count, err
+		}
+		count++
+	}
+	return count, nil
+}
+
+// Replaces the lines that are exactly oldUrl (ignoring the spaces and
+// line breaks around them, like sectionsAsHtml does).
+func replacePhotoLines(content, oldUrl, newUrl string) (string, bool) {
+	changed := false
+	lines := strings.Split(content, "\r")
+	for i, line := range lines {
+		if strings.Trim(line, " \r\n") == oldUrl {
+			lines[i] = strings.Replace(line, oldUrl, newUrl, 1)
+			changed = true
+		}
+	}
+	return strings.Join(lines, "\r"), changed
+}
 
-	return result.LastInsertId()
+type rowScanner interface {
+	Scan(dest ...interface{}) error
+}
+
+func queryPhotos(db *sql.DB, sqlSelect string, args ...interface{}) ([]Photo, error) {
+	rows, err := db.Query(sqlSelect, args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var photos []Photo
+	for rows.Next() {
+		photo, err := scanPhoto(rows)
+		if err != nil {
+			return nil, err
+		}
+		photos = append(photos, photo)
+	}
+	return photos, rows.Err()
+}
+
+func scanPhoto(row rowScanner) (Photo, error) {
+	var id int64
+	var path, url, hash sql.NullString
+	var onDisk, size sql.NullInt64
+	var modifiedOn mysql.NullTime
+	var lat, lng sql.NullFloat64
+	err := row.Scan(&id, &path, &url, &hash, &onDisk, &size, &modifiedOn, &lat, &lng)
+	if err != nil {
+		return Photo{}, err
+	}
+	photo := Photo{
+		Id:        id,
+		Path:      stringValue(path),
+		Url:       stringValue(url),
+		Hash:      stringValue(hash),
+		OnDisk:    intValue(onDisk) == 1,
+		Size:      size.Int64,
+		HasGPS:    lat.Valid && lng.Valid,
+		Latitude:  lat.Float64,
+		Longitude: lng.Float64,
+	}
+	if modifiedOn.Valid {
+		photo.ModifiedOn = modifiedOn.Time
+	}
+	return photo, nil
+}
+
+func (p Photo) coordinates() (sql.NullFloat64, sql.NullFloat64) {
+	lat := sql.NullFloat64{Float64: p.Latitude, Valid: p.HasGPS}
+	lng := sql.NullFloat64{Float64: p.Longitude, Valid: p.HasGPS}
+	return lat, lng
+}
+
+// Returns true if the photo is used in a blog that users with the
+// indicated role can see, or in an album (albums can be seen by any
+// user that is logged in).
+func PhotoIsVisible(url string, role string) (bool, error) {
+	db, err := connectDB()
+	if err != nil {
+		return false, err
+	}
+
+	// Blogs reference the thumbnail but the full size version can be
+	// seen too.
+	thumb := url
+	if strings.HasSuffix(url, ".jpg") && !strings.HasSuffix(url, "_thumb.jpg") {
+		thumb = strings.TrimSuffix(url, ".jpg") + "_thumb.jpg"
+	}
+
+	sqlSelect := `
+		SELECT COUNT(*)
+		FROM blogs b
+		WHERE ` + blogFilter(role, "b.") + `
+			AND (b.thumbnail IN (?, ?) OR EXISTS (
+				SELECT 1 FROM blogs_photos bp
+				WHERE bp.blog_id = b.id AND bp.path IN (?, ?)))`
+	var count int
+	if err := db.QueryRow(sqlSelect, url, thumb, url, thumb).Scan(&count); err != nil {
+		return false, err
+	}
+	if count > 0 || RoleRank(role) < RoleRank(RoleFriend) {
+		return count > 0, nil
+	}
+
+	sqlSelect = `
+		SELECT COUNT(*)
+		FROM albums a
+		WHERE a.cover IN (?, ?) OR EXISTS (
+			SELECT 1 FROM albums_photos ap
+			WHERE ap.albumId = a.id AND ap.path IN (?, ?))`
+	if err := db.QueryRow(sqlSelect, url, thumb, url, thumb).Scan(&count); err != nil {
+		return false, err
+	}
+	return count > 0, nil
 }
@@ -0,0 +1,16 @@
+package models
+
+import "testing"
+
+func TestReplacePhotoLines(t *testing.T) {
+	content := "/photos/a.jpg\r\n/photos/a.jpg.bak\r\n /photos/b/a.jpg\r\n/photos/a.jpg "
+	expected := "/photos/new/a.jpg\r\n/photos/a.jpg.bak\r\n /photos/b/a.jpg\r\n/photos/new/a.jpg "
+	replaced, changed := replacePhotoLines(content, "/photos/a.jpg", "/photos/new/a.jpg")
+	if !changed || replaced != expected {
+		t.Errorf("Unexpected content: %q", replaced)
+	}
+
+	if _, changed := replacePhotoLines("/photos/a.jpg.bak", "/photos/a.jpg", "/photos/new/a.jpg"); changed {
+		t.Errorf("Only whole lines should be replaced")
+	}
+}
@@ -0,0 +1,74 @@
+package models
+
+import "strings"
+
+// Users have one of these roles and each blog has a visibility level
+// (one of the same values). A user can see a blog if their role is at
+// or above the visibility of the blog. Editors (and admins) can also
+// create and edit blogs and albums.
+const (
+	RoleAdmin  = "admin"
+	RoleEditor = "editor"
+	RoleFamily = "family"
+	RoleFriend = "friend"
+	RolePublic = "public"
+)
+
+// From most to least privileged.
+var Roles = []string{RoleAdmin, RoleEditor, RoleFamily, RoleFriend, RolePublic}
+
+const DefaultVisibility = RoleFamily
+
+func RoleRank(role string) int {
+	if role == "guest" {
+		// Before we had roles guests could see all the posts.
+		role = RoleFamily
+	}
+	for i, r := range Roles {
+		if r == role {
+			return len(Roles) - 1 - i
+		}
+	}
+	return 0
+}
+
+func RoleCanView(role string, visibility string) bool {
+	return RoleRank(role) >= RoleRank(visibility)
+}
+
+func RoleCanEdit(role string) bool {
+	return RoleRank(role) >= RoleRank(RoleEditor)
+}
+
+func IsValidVisibility(visibility string) bool {
+	for _, r := range Roles {
+		if r == visibility {
+			return true
+		}
+	}
+	return false
+}
+
+// Returns the SQL condition to select the blogs a role can see,
+// e.g. "b.visibility IN ('friend','public')". Only the constant role
+// names go in the string.
+func visibilityFilter(role string, column string) string {
+	levels := []string{}
+	for _, r := range Roles {
+		if RoleCanView(role, r) {
+			levels = append(levels, "'"+r+"'")
+		}
+	}
+	return column + " IN (" + strings.Join(levels, ",") + ")"
+}
+
+// Returns the SQL condition to select the blogs a role can see (table
+// is the alias of the blogs table, e.g. "b."). Anonymous users (the
+// public role) can only see the blogs that have been published.
+func blogFilter(role string, table string) string {
+	filter := visibilityFilter(role, table+"visibility")
+	if RoleRank(role) == RoleRank(RolePublic) {
+		filter += " AND " + table + "postedOn IS NOT NULL"
+	}
+	return "(" + filter + ")"
+}
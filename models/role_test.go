@@ -0,0 +1,51 @@
+package models
+
+import "testing"
+
+func TestRoleCanView(t *testing.T) {
+	visible := [][]string{
+		{"admin", "admin"}, {"admin", "public"}, {"editor", "family"},
+		{"family", "family"}, {"guest", "family"}, {"friend", "friend"},
+		{"friend", "public"}, {"public", "public"}, {"", "public"},
+	}
+	for _, test := range visible {
+		if !RoleCanView(test[0], test[1]) {
+			t.Errorf("%s should see %s posts", test[0], test[1])
+		}
+	}
+
+	hidden := [][]string{
+		{"editor", "admin"}, {"family", "editor"}, {"guest", "editor"},
+		{"friend", "family"}, {"public", "friend"}, {"", "family"}, {"unknown", "friend"},
+	}
+	for _, test := range hidden {
+		if RoleCanView(test[0], test[1]) {
+			t.Errorf("%s should not see %s posts", test[0], test[1])
+		}
+	}
+}
+
+func TestRoleCanEdit(t *testing.T) {
+	if !RoleCanEdit("admin") || !RoleCanEdit("editor") {
+		t.Errorf("Admins and editors should be able to edit")
+	}
+	if RoleCanEdit("family") || RoleCanEdit("guest") || RoleCanEdit("") {
+		t.Errorf("Only admins and editors should be able to edit")
+	}
+}
+
+func TestVisibilityFilter(t *testing.T) {
+	filter := visibilityFilter("friend", "b.visibility")
+	if filter != "b.visibility IN ('friend','public')" {
+		t.Errorf("Unexpected filter: %s", filter)
+	}
+}
+
+func TestBlogFilter(t *testing.T) {
+	if filter := blogFilter("family", "b."); filter != "(b.visibility IN ('family','friend','public'))" {
+		t.Errorf("Unexpected filter: %s", filter)
+	}
+	if filter := blogFilter("public", ""); filter != "(visibility IN ('public') AND postedOn IS NOT NULL)" {
+		t.Errorf("Unexpected filter: %s", filter)
+	}
+}
@@ -37,7 +37,7 @@ type Channel struct {
 	Description   string   `xml:"description"`
 	Link          string   `xml:"link"`
 	Generator     string   `xml:"generator"`
-	lastBuildDate string   `xml:"lastBuildDate"`
+	LastBuildDate string   `xml:"lastBuildDate"`
 	AtomLink      AtomLink `xml:"atom:link"`
 	ItemList      []Item   `xml:"item"`
 }
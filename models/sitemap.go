@@ -0,0 +1,44 @@
+package models
+
+// Code to produce the XML for a sitemap
+// https://www.sitemaps.org/protocol.html
+//
+// sitemap := NewSitemap()
+// sitemap.Add("https://somewhere.com/2019/some-post/1", "2019-05-01")
+// sitemap.ToXml()
+//
+
+import (
+	"bytes"
+	"encoding/xml"
+)
+
+type SitemapUrl struct {
+	Loc     string `xml:"loc"`
+	LastMod string `xml:"lastmod,omitempty"`
+}
+
+type Sitemap struct {
+	XMLName xml.Name     `xml:"urlset"`
+	Xmlns   string       `xml:"xmlns,attr"`
+	Urls    []SitemapUrl `xml:"url"`
+}
+
+func NewSitemap() Sitemap {
+	return Sitemap{Xmlns: "http://www.sitemaps.org/schemas/sitemap/0.9"}
+}
+
+func (s *Sitemap) Add(loc, lastMod string) {
+	s.Urls = append(s.Urls, SitemapUrl{Loc: loc, LastMod: lastMod})
+}
+
+func (s Sitemap) ToXml() (string, error) {
+	text := "<?xml version=\"1.0\" encoding=\"UTF-8\"?>\r\n"
+	buffer := bytes.NewBufferString(text)
+	enc := xml.NewEncoder(buffer)
+	enc.Indent("  ", "    ")
+	if err := enc.Encode(s); err != nil {
+		return "", err
+	}
+	return buffer.String(), nil
+}
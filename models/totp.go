@@ -0,0 +1,287 @@
+package models
+
+// Time-based one-time passwords (TOTP) as described in RFC 6238, which
+// is HOTP (RFC 4226) using the number of 30 second periods since the
+// Unix epoch as the counter. These are the codes shown by apps like
+// Google Authenticator.
+//
+// References:
+//	https://tools.ietf.org/html/rfc4226
+//	https://tools.ietf.org/html/rfc6238
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha1"
+	"crypto/sha256"
+	"crypto/subtle"
+	"database/sql"
+	"encoding/base32"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net/url"
+	"strings"
+	"time"
+)
+
+type UserTotp struct {
+	UserId      int64
+	Secret      string // base32 encoded
+	Enabled     bool
+	LastCounter int64 // to prevent a code from being used twice
+}
+
+const (
+	totpDigits        = 6
+	totpPeriod        = 30
+	totpSkew          = 1 // periods before/after the current one to accept
+	totpSecretSize    = 20
+	recoveryCodeCount = 10
+)
+
+var totpEncoding = base32.StdEncoding.WithPadding(base32.NoPadding)
+
+// Returns the HOTP value for a counter.
+func hotpCode(key []byte, counter uint64, digits int) string {
+	msg := make([]byte, 8)
+	binary.BigEndian.PutUint64(msg, counter)
+	mac := hmac.New(sha1.New, key)
+	mac.Write(msg)
+	sum := mac.Sum(nil)
+
+	// Dynamic truncation (RFC 4226 section 5.3)
+	offset := sum[len(sum)-1] & 0x0f
+	value := binary.BigEndian.Uint32(sum[offset:offset+4]) & 0x7fffffff
+
+	mod := uint32(1)
+	for i := 0; i < digits; i++ {
+		mod *= 10
+	}
+	return fmt.Sprintf("%0*d", digits, value%mod)
+}
+
+func totpCounter(t time.Time) int64 {
+	return t.Unix() / totpPeriod
+}
+
+// Returns the counter that matches the code, or zero if the code is not
+// valid at the indicated time. Counters at or before lastCounter are
+// not accepted so that a code cannot be used twice.
+func totpMatch(secret string, code string, now time.Time, lastCounter int64) int64 {
+	key, err := totpEncoding.DecodeString(strings.ToUpper(secret))
+	if err != nil || len(code) != totpDigits {
+		return 0
+	}
+
+	current := totpCounter(now)
+	for counter := current - totpSkew; counter <= current+totpSkew; counter++ {
+		if counter <= lastCounter {
+			continue
+		}
+		expected := hotpCode(key, uint64(counter), totpDigits)
+		if subtle.ConstantTimeCompare([]byte(expected), []byte(code)) == 1 {
+			return counter
+		}
+	}
+	return 0
+}
+
+func totpNewSecret() (string, error) {
+	rb := make([]byte, totpSecretSize)
+	if _, err := rand.Read(rb); err != nil {
+		return "", err
+	}
+	return totpEncoding.EncodeToString(rb), nil
+}
+
+// Returns the otpauth:// URI that authenticator apps read from the
+// QR code. See https://github.com/google/google-authenticator/wiki/Key-Uri-Format
+func TotpUri(secret string, login string) string {
+	issuer := settings.Site.Name // shown by the authenticator app
+	label := url.PathEscape(issuer + ":" + login)
+	values := url.Values{}
+	values.Set("secret", secret)
+	values.Set("issuer", issuer)
+	return "otpauth://totp/" + label + "?" + values.Encode()
+}
+
+// Returns true if users of the indicated type must use two-factor
+// authentication (auth.require_2fa)
+func TotpRequired(userType string) bool {
+	return settings.Auth.Requires2fa(userType)
+}
+
+func TotpGet(login string) (UserTotp, error) {
+	db, err := connectDB()
+	if err != nil {
+		return UserTotp{}, err
+	}
+	return totpGet(db, login)
+}
+
+func totpGet(db *sql.DB, login string) (UserTotp, error) {
+	sqlSelect := `
+		SELECT id, totpSecret, totpEnabled, totpLastCounter
+		FROM users
+		WHERE login = ?`
+	var totp UserTotp
+	var secret sql.NullString
+	var enabled int
+	row := db.QueryRow(sqlSelect, login)
+	err := row.Scan(&totp.UserId, &secret, &enabled, &totp.LastCounter)
+	totp.Secret = stringValue(secret)
+	totp.Enabled = enabled != 0
+	return totp, err
+}
+
+// Returns the secret to use for enrolling the user. The secret is
+// stored but not enabled until the user confirms it with a code.
+func TotpStartEnrollment(login string) (string, error) {
+	db, err := connectDB()
+	if err != nil {
+		return "", err
+	}
+
+	totp, err := totpGet(db, login)
+	if err != nil {
+		return "", err
+	}
+	if totp.Enabled {
+		return "", errors.New("Two-factor authentication is already enabled")
+	}
+	if totp.Secret != "" {
+		return totp.Secret, nil
+	}
+
+	secret, err := totpNewSecret()
+	if err != nil {
+		return "", err
+	}
+	sqlUpdate := `UPDATE users SET totpSecret = ?, totpEnabled = 0, totpLastCounter = 0 WHERE id = ?`
+	_, err = db.Exec(sqlUpdate, secret, totp.UserId)
+	return secret, err
+}
+
+// Enables two-factor authentication for the user if the code matches
+// the secret being enrolled. Returns the recovery codes for the user.
+func TotpEnable(login string, code string) ([]string, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	totp, err := totpGet(db, login)
+	if err != nil {
+		return nil, err
+	}
+	if totp.Enabled || totp.Secret == "" {
+		return nil, errors.New("Two-factor authentication enrollment has not been started")
+	}
+
+	counter := totpMatch(totp.Secret, code, time.Now(), 0)
+	if counter == 0 {
+		return nil, errors.New("Invalid code")
+	}
+
+	sqlUpdate := `UPDATE users SET totpEnabled = 1, totpLastCounter = ? WHERE id = ?`
+	if _, err = db.Exec(sqlUpdate, counter, totp.UserId); err != nil {
+		return nil, err
+	}
+	return newRecoveryCodes(db, totp.UserId)
+}
+
+func TotpDisable(login string) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	totp, err := totpGet(db, login)
+	if err != nil {
+		return err
+	}
+
+	sqlUpdate := `UPDATE users SET totpSecret = NULL, totpEnabled = 0, totpLastCounter = 0 WHERE id = ?`
+	if _, err = db.Exec(sqlUpdate, totp.UserId); err != nil {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM recovery_codes WHERE userId = ?", totp.UserId)
+	return err
+}
+
+// Checks a code (either from the authenticator app or a recovery code)
+// for a user with two-factor authentication enabled.
+func TotpVerify(login string, code string) (bool, error) {
+	db, err := connectDB()
+	if err != nil {
+		return false, err
+	}
+
+	totp, err := totpGet(db, login)
+	if err != nil {
+		return false, err
+	}
+	if !totp.Enabled {
+		return false, errors.New("Two-factor authentication is not enabled")
+	}
+
+	code = strings.Replace(strings.TrimSpace(code), " ", "", -1)
+	if counter := totpMatch(totp.Secret, code, time.Now(), totp.LastCounter); counter != 0 {
+		// Only update it if nobody else used a later code in the meantime.
+		sqlUpdate := `UPDATE users SET totpLastCounter = ? WHERE id = ? AND totpLastCounter < ?`
+		result, err := db.Exec(sqlUpdate, counter, totp.UserId, counter)
+		if err != nil {
+			return false, err
+		}
+		count, _ := result.RowsAffected()
+		return count == 1, nil
+	}
+
+	return useRecoveryCode(db, totp.UserId, code)
+}
+
+// Recovery codes are random so a plain SHA-256 is enough to store them.
+func recoveryCodeHash(code string) string {
+	code = strings.ToUpper(strings.Replace(code, "-", "", -1))
+	return fmt.Sprintf("%x", sha256.Sum256([]byte(code)))
+}
+
+// Replaces the recovery codes of the user with new ones.
+func newRecoveryCodes(db *sql.DB, userId int64) ([]string, error) {
+	if _, err := db.Exec("DELETE FROM recovery_codes WHERE userId = ?", userId); err != nil {
+		return nil, err
+	}
+
+	codes := []string{}
+	for i := 0; i < recoveryCodeCount; i++ {
+		rb := make([]byte, 6)
+		if _, err := rand.Read(rb); err != nil {
+			return nil, err
+		}
+		code := totpEncoding.EncodeToString(rb)[0:10]
+		code = code[0:5] + "-" + code[5:]
+		sqlInsert := `INSERT INTO recovery_codes(userId, codeHash) VALUES(?, ?)`
+		if _, err := db.Exec(sqlInsert, userId, recoveryCodeHash(code)); err != nil {
+			return nil, err
+		}
+		codes = append(codes, code)
+	}
+	return codes, nil
+}
+
+func useRecoveryCode(db *sql.DB, userId int64, code string) (bool, error) {
+	if len(code) < 10 {
+		return false, nil
+	}
+	sqlUpdate := `
+		UPDATE recovery_codes
+		SET usedOn = ?
+		WHERE userId = ? AND codeHash = ? AND usedOn IS NULL`
+	result, err := db.Exec(sqlUpdate, time.Now().UTC(), userId, recoveryCodeHash(code))
+	if err != nil {
+		return false, err
+	}
+	count, _ := result.RowsAffected()
+	return count == 1, nil
+}
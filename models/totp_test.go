@@ -0,0 +1,67 @@
+package models
+
+import (
+	"testing"
+	"time"
+)
+
+// Test vectors from RFC 6238 (Appendix B) for SHA-1.
+func TestTotpRfcVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	vectors := map[int64]string{
+		59:          "94287082",
+		1111111109:  "07081804",
+		1111111111:  "14050471",
+		1234567890:  "89005924",
+		2000000000:  "69279037",
+		20000000000: "65353130",
+	}
+	for seconds, expected := range vectors {
+		counter := totpCounter(time.Unix(seconds, 0))
+		if code := hotpCode(key, uint64(counter), 8); code != expected {
+			t.Errorf("Unexpected code for %d: %s (expected %s)", seconds, code, expected)
+		}
+	}
+}
+
+// Test vectors from RFC 4226 (Appendix D).
+func TestHotpRfcVectors(t *testing.T) {
+	key := []byte("12345678901234567890")
+	expected := []string{"755224", "287082", "359152", "969429", "338314",
+		"254676", "287922", "162583", "399871", "520489"}
+	for counter, code := range expected {
+		if value := hotpCode(key, uint64(counter), 6); value != code {
+			t.Errorf("Unexpected code for counter %d: %s (expected %s)", counter, value, code)
+		}
+	}
+}
+
+func TestTotpMatch(t *testing.T) {
+	secret := totpEncoding.EncodeToString([]byte("12345678901234567890"))
+	now := time.Unix(1111111111, 0)
+	key, _ := totpEncoding.DecodeString(secret)
+	current := totpCounter(now)
+	code := hotpCode(key, uint64(current), totpDigits)
+
+	if totpMatch(secret, code, now, 0) != current {
+		t.Errorf("Valid code rejected")
+	}
+	if totpMatch(secret, code, now.Add(totpPeriod*time.Second), 0) != current {
+		t.Errorf("Code from the previous period rejected")
+	}
+	if totpMatch(secret, code, now.Add(3*totpPeriod*time.Second), 0) != 0 {
+		t.Errorf("Old code accepted")
+	}
+	if totpMatch(secret, code, now, current) != 0 {
+		t.Errorf("Code used twice")
+	}
+	if totpMatch(secret, "000000", now, 0) != 0 && code != "000000" {
+		t.Errorf("Invalid code accepted")
+	}
+}
+
+func TestRecoveryCodeHash(t *testing.T) {
+	if recoveryCodeHash("ABCDE-FGHIJ") != recoveryCodeHash("abcdefghij") {
+		t.Errorf("Recovery codes should be case and dash insensitive")
+	}
+}
@@ -2,15 +2,37 @@ package models
 
 import (
 	"crypto/sha256"
+	"crypto/subtle"
 	"database/sql"
 	"errors"
 	"fmt"
 	"log"
+	"strings"
+
+	"golang.org/x/crypto/bcrypt"
 )
 
 type User struct {
-	Id   int64
-	Type string // guest or admin
+	Id       int64
+	Login    string
+	Name     string
+	Type     string // the role of the user (see role.go)
+	Disabled bool
+	Email    string // used to send password reset links
+}
+
+const userColumns = "users.id, users.login, users.name, users.type, users.disabled, users.email"
+
+// Roles that can be given to users.
+var UserTypes = []string{RoleAdmin, RoleEditor, RoleFamily, RoleFriend}
+
+func IsValidUserType(userType string) bool {
+	for _, t := range UserTypes {
+		if t == userType {
+			return true
+		}
+	}
+	return false
 }
 
 func CreateDefaultUsers() error {
@@ -18,7 +40,6 @@ func CreateDefaultUsers() error {
 	if err != nil {
 		return err
 	}
-	defer db.Close()
 
 	row := db.QueryRow("SELECT count(*) FROM users")
 	var count int
@@ -41,8 +62,7 @@ func AddGuestUser(login, password string) error {
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	return createUser(db, login, password, "guest")
+	return createUser(db, login, password, RoleFamily)
 }
 
 func SetPassword(login, newPassword string) error {
@@ -50,78 +70,122 @@ func SetPassword(login, newPassword string) error {
 	if err != nil {
 		return err
 	}
-	defer db.Close()
-	hashedPassword := hashPassword(newPassword)
+	hashedPassword, err := hashPassword(newPassword)
+	if err != nil {
+		return err
+	}
 	sqlUpdate := "UPDATE users SET password = ? WHERE login = ?"
 	_, err = db.Exec(sqlUpdate, hashedPassword, login)
 	return err
 }
 
 func createDefaultAdmin(db *sql.DB) error {
-	login := env("BLOG_USR", "user1")
-	password := env("BLOG_PASS", "welcome1")
+	login := settings.Auth.AdminUser
+	password := settings.Auth.AdminPassword
 	log.Printf(fmt.Sprintf("Creating initial admin user: %s", login))
 	return createUser(db, login, password, "admin")
 }
 
 func createDefaultGuest(db *sql.DB) error {
-	login := env("BLOG_GUEST_USR", "user2")
-	password := env("BLOG_GUEST_PASS", "welcome2")
+	login := settings.Auth.GuestUser
+	password := settings.Auth.GuestPassword
 	log.Printf(fmt.Sprintf("Creating initial guest user: %s", login))
-	return createUser(db, login, password, "guest")
+	return createUser(db, login, password, RoleFamily)
 }
 
 func createUser(db *sql.DB, login, password, userType string) error {
-	hashedPwd := hashPassword(password)
+	hashedPwd, err := hashPassword(password)
+	if err != nil {
+		return err
+	}
 	sqlInsert := `INSERT INTO users(login, name, password, type) VALUES(?, ?, ?, ?)`
-	_, err := db.Exec(sqlInsert, login, login, hashedPwd, userType)
+	_, err = db.Exec(sqlInsert, login, login, hashedPwd, userType)
 	return err
 }
 
-func hashPassword(password string) string {
-	salt := env("BLOG_SALT", "")
+// bcrypt hashes include a per-user salt and the cost used to calculate
+// them, e.g. $2a$12$<salt+hash>
+const passwordCost = 12
+
+func hashPassword(password string) (string, error) {
+	hashed, err := bcrypt.GenerateFromPassword([]byte(password), passwordCost)
+	if err != nil {
+		return "", err
+	}
+	return string(hashed), nil
+}
+
+// Hash used before we switched to bcrypt: a single SHA-256 with a salt
+// shared by all users. We only use it to validate (and upgrade) the
+// passwords of accounts that have not logged in since then.
+func legacyHashPassword(password string) string {
+	salt := settings.Auth.LegacySalt
 	salted := password + salt
 	data := []byte(salted)
 	hashed := sha256.Sum256(data)
 	return fmt.Sprintf("%x", hashed)
 }
 
-func LoginUser(login, password string) (bool, error) {
-	db, err := connectDB()
-	if err != nil {
-		return false, err
+// Checks a password against the hash stored in the database. Returns
+// whether the password is valid and whether the hash should be
+// recalculated (because it uses the legacy format or an older cost).
+func checkPassword(hashedPassword, password string) (bool, bool) {
+	if !strings.HasPrefix(hashedPassword, "$2") {
+		legacy := legacyHashPassword(password)
+		valid := subtle.ConstantTimeCompare([]byte(legacy), []byte(hashedPassword)) == 1
+		return valid, valid
 	}
-	defer db.Close()
 
-	hashedPassword := hashPassword(password)
-	row := db.QueryRow("SELECT id FROM users WHERE login = ? and password = ?", login, hashedPassword)
-	var id int64
-	err = row.Scan(&id)
+	err := bcrypt.CompareHashAndPassword([]byte(hashedPassword), []byte(password))
 	if err != nil {
-		log.Printf("Login/password not found in database: %s/***", login)
-		return false, err
-	} else if id == 0 {
-		return false, errors.New("User ID was zero")
+		return false, false
 	}
-	return true, nil
+	cost, err := bcrypt.Cost([]byte(hashedPassword))
+	return true, err != nil || cost < passwordCost
 }
 
-func LoginTicket(login string) (bool, error) {
+func LoginUser(login, password string) (bool, error) {
 	db, err := connectDB()
 	if err != nil {
 		return false, err
 	}
-	defer db.Close()
 
-	row := db.QueryRow("SELECT id FROM users WHERE login = ?", login)
+	row := db.QueryRow("SELECT id, password, disabled FROM users WHERE login = ?", login)
 	var id int64
-	err = row.Scan(&id)
+	var hashedPassword string
+	var disabled int
+	err = row.Scan(&id, &hashedPassword, &disabled)
 	if err != nil {
-		log.Printf("Ticket not found in database: %s", login)
+		log.Printf("Login/password not found in database: %s/***", login)
 		return false, err
 	} else if id == 0 {
 		return false, errors.New("User ID was zero")
 	}
+
+	valid, needsRehash := checkPassword(hashedPassword, password)
+	if !valid {
+		log.Printf("Login/password not found in database: %s/***", login)
+		return false, errors.New("Invalid user/password")
+	}
+
+	if disabled != 0 {
+		log.Printf("Login for disabled user: %s", login)
+		return false, errors.New("User is disabled")
+	}
+
+	if needsRehash {
+		// Upgrade the hash now that we know the password.
+		newHash, err := hashPassword(password)
+		if err == nil {
+			sqlUpdate := "UPDATE users SET password = ? WHERE id = ?"
+			_, err = db.Exec(sqlUpdate, newHash, id)
+		}
+		if err != nil {
+			log.Printf("Error upgrading password hash for user %s: %s", login, err)
+		} else {
+			log.Printf("Upgraded password hash for user %s", login)
+		}
+	}
 	return true, nil
 }
 
@@ -135,7 +199,6 @@ func GetUserInfo(login string) (User, error) {
 	if err != nil {
 		return User{}, err
 	}
-	defer db.Close()
 
 	row := db.QueryRow("SELECT id, type FROM users WHERE login = ?", login)
 	var userType sql.NullString
@@ -148,3 +211,183 @@ func GetUserInfo(login string) (User, error) {
 	user := User{Id: id, Type: stringValue(userType)}
 	return user, err
 }
+
+func UserGetAll() ([]User, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	rows, err := db.Query("SELECT " + userColumns + " FROM users ORDER BY login")
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return nil, err
+		}
+		users = append(users, user)
+	}
+	return users, rows.Err()
+}
+
+func UserGetById(id int64) (User, error) {
+	db, err := connectDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	row := db.QueryRow("SELECT "+userColumns+" FROM users WHERE id = ?", id)
+	return scanUser(row)
+}
+
+// Returns the (enabled) user with the given e-mail.
+func UserGetByEmail(email string) (User, error) {
+	email = strings.TrimSpace(email)
+	if email == "" {
+		return User{}, sql.ErrNoRows
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return User{}, err
+	}
+
+	sqlSelect := "SELECT " + userColumns + " FROM users WHERE email = ? AND disabled = 0"
+	rows, err := db.Query(sqlSelect, email)
+	if err != nil {
+		return User{}, err
+	}
+	defer rows.Close()
+
+	users := []User{}
+	for rows.Next() {
+		user, err := scanUser(rows)
+		if err != nil {
+			return User{}, err
+		}
+		users = append(users, user)
+	}
+	if err := rows.Err(); err != nil {
+		return User{}, err
+	}
+	if len(users) == 0 {
+		return User{}, sql.ErrNoRows
+	}
+	if len(users) > 1 {
+		return User{}, fmt.Errorf("More than one user has e-mail %s", email)
+	}
+	return users[0], nil
+}
+
+func UserAdd(login, password, userType string) error {
+	if login == "" || password == "" {
+		return errors.New("Login and password cannot be empty")
+	}
+	if !IsValidUserType(userType) {
+		return fmt.Errorf("Invalid user type (%s)", userType)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	row := db.QueryRow("SELECT count(*) FROM users WHERE login = ?", login)
+	var count int
+	if err := row.Scan(&count); err != nil {
+		return err
+	}
+	if count > 0 {
+		return fmt.Errorf("User %s already exists", login)
+	}
+	return createUser(db, login, password, userType)
+}
+
+func UserSetType(id int64, userType string) error {
+	if !IsValidUserType(userType) {
+		return fmt.Errorf("Invalid user type (%s)", userType)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET type = ? WHERE id = ?", userType, id)
+	return err
+}
+
+// Disabled users cannot login and their sessions are deleted.
+func UserSetDisabled(id int64, disabled bool) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET disabled = ? WHERE id = ?", boolToInt(disabled), id)
+	if err != nil || !disabled {
+		return err
+	}
+	_, err = db.Exec("DELETE FROM sessions WHERE userId = ?", id)
+	return err
+}
+
+func UserSetEmail(id int64, email string) error {
+	email = strings.TrimSpace(email)
+	if email != "" && !strings.Contains(email, "@") {
+		return fmt.Errorf("Invalid e-mail address (%s)", email)
+	}
+
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("UPDATE users SET email = ? WHERE id = ?", email, id)
+	return err
+}
+
+func UserDelete(id int64) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	// Everything that belongs to the user goes with it so that nothing
+	// is inherited by a new user that gets the same login (or id).
+	tx, err := db.Begin()
+	if err != nil {
+		return err
+	}
+	sqlDeletes := []string{
+		"DELETE FROM sessions WHERE userId = ?",
+		"DELETE FROM password_resets WHERE userId = ?",
+		"DELETE FROM api_tokens WHERE userId = ?",
+		"DELETE FROM recovery_codes WHERE userId = ?",
+		"DELETE FROM users WHERE id = ?",
+	}
+	for _, sqlDelete := range sqlDeletes {
+		if _, err := tx.Exec(sqlDelete, id); err != nil {
+			tx.Rollback()
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+func scanUser(row rowScanner) (User, error) {
+	var user User
+	var name, userType, email sql.NullString
+	var disabled int
+	err := row.Scan(&user.Id, &user.Login, &name, &userType, &disabled, &email)
+	user.Name = stringValue(name)
+	user.Type = stringValue(userType)
+	user.Email = stringValue(email)
+	user.Disabled = disabled != 0
+	return user, err
+}
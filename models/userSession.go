@@ -2,9 +2,11 @@ package models
 
 import (
 	"crypto/rand"
+	"crypto/sha256"
 	"database/sql"
 	"encoding/base64"
 	"errors"
+	"fmt"
 	"log"
 	"time"
 
@@ -12,10 +14,63 @@ import (
 )
 
 type UserSession struct {
-	SessionId string
-	ExpiresOn time.Time
-	Login     string
-	UserType  string
+	SessionId  string
+	CreatedOn  time.Time
+	ExpiresOn  time.Time
+	LastSeenOn time.Time
+	Login      string
+	UserType   string
+	UserAgent  string
+	Ip         string
+	Extended   bool // true if ExpiresOn was pushed back on this request
+}
+
+// How long sessions last for each type of user. Sessions expire after
+// the indicated number of days without being used (sliding expiry), or
+// after the idle timeout (if any) which is expected to be shorter.
+type SessionPolicy struct {
+	Days      int
+	IdleHours int // zero means no idle timeout
+}
+
+// Sessions are extended at most once a day so that we don't have to
+// send the cookie on every request.
+const sessionExtendEvery = 24 * time.Hour
+
+// Settings are in auth.sessions.<type> (or SESSION_DAYS_<TYPE> and
+// SESSION_IDLE_HOURS_<TYPE>, e.g. SESSION_DAYS_ADMIN=30)
+func SessionPolicyFor(userType string) SessionPolicy {
+	policy := SessionPolicy{Days: 365, IdleHours: 0}
+	if userType == "admin" {
+		policy = SessionPolicy{Days: 30, IdleHours: 72}
+	}
+
+	session := settings.Auth.Sessions[userType]
+	if session.Days != nil {
+		policy.Days = *session.Days
+	}
+	if session.IdleHours != nil {
+		policy.IdleHours = *session.IdleHours
+	}
+	return policy
+}
+
+func (p SessionPolicy) isIdle(lastSeenOn time.Time, now time.Time) bool {
+	if p.IdleHours <= 0 {
+		return false
+	}
+	return now.Sub(lastSeenOn) > time.Duration(p.IdleHours)*time.Hour
+}
+
+func (p SessionPolicy) expiresOn(now time.Time) time.Time {
+	return now.AddDate(0, 0, p.Days)
+}
+
+// Returns a value that identifies a session without giving away its
+// ID (which is what the cookie holds).
+func (s UserSession) Handle() string {
+	hash := sha256.Sum256([]byte(s.SessionId))
+	return fmt.Sprintf("%x", hash[0:16])
 }
 
 func GetUserSession(sessionId string) (UserSession, error) {
@@ -27,97 +82,63 @@ func GetUserSession(sessionId string) (UserSession, error) {
 	if err != nil {
 		return UserSession{}, err
 	}
-	defer db.Close()
 
 	sqlSelect := `
-		SELECT expiresOn, users.login, users.type
+		SELECT expiresOn, lastSeenOn, users.login, users.type
 		FROM sessions
 		 	INNER JOIN users ON sessions.userId = users.id
-		WHERE sessions.id = ?`
+		WHERE sessions.id = ? AND users.disabled = 0`
 	row := db.QueryRow(sqlSelect, sessionId)
-	var expiresOn mysql.NullTime
+	var expiresOn, lastSeenOn mysql.NullTime
 	var login sql.NullString
 	var userType sql.NullString
-	err = row.Scan(&expiresOn, &login, &userType)
+	err = row.Scan(&expiresOn, &lastSeenOn, &login, &userType)
 	if err != nil {
 		log.Printf("Error on scan: %s", err)
 		return UserSession{}, err
 	}
 
-	if expiresOn.Valid && expiresOn.Time.After(time.Now().UTC()) {
-
-		// Mark last time session has been used.
-		now := time.Now().UTC()
-		sqlUpdate := `UPDATE sessions SET lastSeenOn = ? WHERE id = ?`
-		_, err = db.Exec(sqlUpdate, now, sessionId)
-		if err != nil {
-			log.Printf("Could not update session last seen on: %s", err)
-		}
-
-		s := UserSession{
-			SessionId: sessionId,
-			ExpiresOn: expiresOn.Time,
-			Login:     stringValue(login),
-			UserType:  stringValue(userType),
-		}
-		return s, nil
+	now := time.Now().UTC()
+	policy := SessionPolicyFor(stringValue(userType))
+	if !expiresOn.Valid || !expiresOn.Time.After(now) {
+		return UserSession{}, errors.New("UserSession has already expired")
 	}
-
-	return UserSession{}, errors.New("UserSession has already expired")
-}
-
-func NewUserSession(login string) (UserSession, error) {
-	return newSession(login, 365)
-}
-
-func NewTicketSession(login string) (UserSession, error) {
-	return newSession(login, 60)
-}
-
-func DeleteUserSession(sessionId string) {
-	db, err := connectDB()
-	if err != nil {
-		return
+	if lastSeenOn.Valid && policy.isIdle(lastSeenOn.Time, now) {
+		DeleteUserSession(sessionId)
+		return UserSession{}, errors.New("UserSession has been idle for too long")
 	}
-	defer db.Close()
-
-	sqlDelete := `DELETE FROM sessions WHERE id = ?`
-	_, err = db.Exec(sqlDelete, sessionId)
-}
 
-func cleanSessions(db *sql.DB, userId int64, singleSessionUser bool) error {
-	if singleSessionUser {
-		// All sessions for this user (regardless of expiration date)
-		sqlDelete := "DELETE FROM sessions WHERE userId = ?"
-		_, err := db.Exec(sqlDelete, userId)
-		if err != nil {
-			return err
-		}
+	s := UserSession{
+		SessionId:  sessionId,
+		ExpiresOn:  expiresOn.Time,
+		LastSeenOn: now,
+		Login:      stringValue(login),
+		UserType:   stringValue(userType),
 	}
 
-	// All expired sessions (regardless of the user)
-	sqlDelete := "DELETE FROM sessions WHERE expiresOn < utc_timestamp()"
-	_, err := db.Exec(sqlDelete)
-	return err
-}
-
-// source: https://www.socketloop.com/tutorials/golang-how-to-generate-random-string
-func newId() (string, error) {
-	size := 32
-	rb := make([]byte, size)
-	_, err := rand.Read(rb)
+	// Mark last time session has been used, and push back its expiration
+	// date if it's time to do so.
+	sqlUpdate := `UPDATE sessions SET lastSeenOn = ? WHERE id = ?`
+	args := []interface{}{now, sessionId}
+	newExpiresOn := policy.expiresOn(now)
+	if newExpiresOn.Sub(s.ExpiresOn) > sessionExtendEvery {
+		s.ExpiresOn = newExpiresOn
+		s.Extended = true
+		sqlUpdate = `UPDATE sessions SET lastSeenOn = ?, expiresOn = ? WHERE id = ?`
+		args = []interface{}{now, newExpiresOn, sessionId}
+	}
+	_, err = db.Exec(sqlUpdate, args...)
 	if err != nil {
-		return "", err
+		log.Printf("Could not update session last seen on: %s", err)
 	}
-	return base64.URLEncoding.EncodeToString(rb), nil
+	return s, nil
 }
 
-func newSession(login string, days int) (UserSession, error) {
+func NewUserSession(login string, userAgent string, ip string) (UserSession, error) {
 	db, err := connectDB()
 	if err != nil {
 		return UserSession{}, err
 	}
-	defer db.Close()
 
 	sessionId, err := newId()
 	if err != nil {
@@ -129,26 +150,129 @@ func newSession(login string, days int) (UserSession, error) {
 		return UserSession{}, err
 	}
 
-	singleSessionUser := (user.Type == "admin")
-	err = cleanSessions(db, user.Id, singleSessionUser)
+	err = cleanSessions(db)
 	if err != nil {
-		log.Printf("Error cleaning older sessions for user %s, %s", login, err)
+		log.Printf("Error cleaning expired sessions, %s", err)
 	}
 
-	s := UserSession{
-		SessionId: sessionId,
-		Login:     login,
-		ExpiresOn: time.Now().UTC().AddDate(0, 0, days),
-		UserType:  user.Type,
+	if len(userAgent) > 255 {
+		userAgent = userAgent[0:255]
 	}
 
 	now := time.Now().UTC()
+	s := UserSession{
+		SessionId:  sessionId,
+		Login:      login,
+		CreatedOn:  now,
+		LastSeenOn: now,
+		ExpiresOn:  SessionPolicyFor(user.Type).expiresOn(now),
+		UserType:   user.Type,
+		UserAgent:  userAgent,
+		Ip:         ip,
+	}
+
 	sqlInsert := `
-		INSERT INTO sessions(id, userId, expiresOn, lastSeenOn)
-		VALUES(?, ?, ?, ?)`
-	_, err = db.Exec(sqlInsert, s.SessionId, user.Id, s.ExpiresOn, now)
+		INSERT INTO sessions(id, userId, expiresOn, lastSeenOn, createdOn, userAgent, ip)
+		VALUES(?, ?, ?, ?, ?, ?, ?)`
+	_, err = db.Exec(sqlInsert, s.SessionId, user.Id, s.ExpiresOn, now, now, s.UserAgent, s.Ip)
 	if err != nil {
 		log.Printf("Error in SQL INSERT INTO sessions: %s", err)
 	}
 	return s, err
 }
+
+// Returns the active sessions of a user, most recently used first.
+func UserSessionsGetByUser(userId int64) ([]UserSession, error) {
+	db, err := connectDB()
+	if err != nil {
+		return nil, err
+	}
+
+	sqlSelect := `
+		SELECT sessions.id, createdOn, expiresOn, lastSeenOn, userAgent, ip,
+			users.login, users.type
+		FROM sessions
+			INNER JOIN users ON sessions.userId = users.id
+		WHERE sessions.userId = ? AND expiresOn > ?
+		ORDER BY lastSeenOn DESC`
+	now := time.Now().UTC()
+	rows, err := db.Query(sqlSelect, userId, now)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	sessions := []UserSession{}
+	for rows.Next() {
+		var s UserSession
+		var createdOn mysql.NullTime
+		var userAgent, ip, userType sql.NullString
+		err := rows.Scan(&s.SessionId, &createdOn, &s.ExpiresOn, &s.LastSeenOn,
+			&userAgent, &ip, &s.Login, &userType)
+		if err != nil {
+			return nil, err
+		}
+		s.CreatedOn = createdOn.Time
+		s.UserAgent = stringValue(userAgent)
+		s.Ip = stringValue(ip)
+		s.UserType = stringValue(userType)
+		if SessionPolicyFor(s.UserType).isIdle(s.LastSeenOn, now) {
+			continue
+		}
+		sessions = append(sessions, s)
+	}
+	return sessions, rows.Err()
+}
+
+// Deletes the session of a user identified by its handle.
+func DeleteUserSessionByHandle(userId int64, handle string) error {
+	sessions, err := UserSessionsGetByUser(userId)
+	if err != nil {
+		return err
+	}
+	for _, s := range sessions {
+		if s.Handle() == handle {
+			DeleteUserSession(s.SessionId)
+			return nil
+		}
+	}
+	return errors.New("Session not found")
+}
+
+func DeleteUserSessions(userId int64) error {
+	db, err := connectDB()
+	if err != nil {
+		return err
+	}
+
+	_, err = db.Exec("DELETE FROM sessions WHERE userId = ?", userId)
+	return err
+}
+
+func DeleteUserSession(sessionId string) {
+	db, err := connectDB()
+	if err != nil {
+		return
+	}
+
+	sqlDelete := `DELETE FROM sessions WHERE id = ?`
+	_, err = db.Exec(sqlDelete, sessionId)
+}
+
+// Deletes all expired sessions (regardless of the user)
+func cleanSessions(db *sql.DB) error {
+	sqlDelete := "DELETE FROM sessions WHERE expiresOn < utc_timestamp()"
+	_, err := db.Exec(sqlDelete)
+	return err
+}
+
+// source: https://www.socketloop.com/tutorials/golang-how-to-generate-random-string
+func newId() (string, error) {
+	size := 32
+	rb := make([]byte, size)
+	_, err := rand.Read(rb)
+	if err != nil {
+		return "", err
+	}
+	return base64.URLEncoding.EncodeToString(rb), nil
+}
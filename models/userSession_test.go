@@ -0,0 +1,50 @@
+package models
+
+import (
+	"testing"
+	"time"
+
+	"hectorcorrea.com/hk/config"
+)
+
+func TestSessionPolicy(t *testing.T) {
+	admin := SessionPolicyFor("admin")
+	family := SessionPolicyFor("family")
+	if admin.Days != 30 || admin.IdleHours != 72 {
+		t.Errorf("Unexpected admin policy: %v", admin)
+	}
+	if family.Days != 365 || family.IdleHours != 0 {
+		t.Errorf("Unexpected family policy: %v", family)
+	}
+
+	days, idleHours, noIdle := 10, 5, 0
+	settings.Auth.Sessions = map[string]config.Session{
+		"family": {Days: &days, IdleHours: &idleHours},
+		"admin":  {IdleHours: &noIdle},
+	}
+	defer func() { settings.Auth.Sessions = nil }()
+	family = SessionPolicyFor("family")
+	if family.Days != 10 || family.IdleHours != 5 {
+		t.Errorf("Policy not read from the settings: %v", family)
+	}
+	admin = SessionPolicyFor("admin")
+	if admin.Days != 30 || admin.IdleHours != 0 {
+		t.Errorf("Idle timeout should be disabled: %v", admin)
+	}
+}
+
+func TestSessionPolicyIdle(t *testing.T) {
+	now := time.Now()
+	policy := SessionPolicy{Days: 1, IdleHours: 2}
+	if policy.isIdle(now.Add(-time.Hour), now) {
+		t.Errorf("Session should not be idle")
+	}
+	if !policy.isIdle(now.Add(-3*time.Hour), now) {
+		t.Errorf("Session should be idle")
+	}
+
+	noIdle := SessionPolicy{Days: 1}
+	if noIdle.isIdle(now.AddDate(-1, 0, 0), now) {
+		t.Errorf("Sessions should not be idle when there is no idle timeout")
+	}
+}
@@ -0,0 +1,54 @@
+package models
+
+import "testing"
+
+func TestCheckPassword(t *testing.T) {
+	hashed, err := hashPassword("welcome1")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	valid, needsRehash := checkPassword(hashed, "welcome1")
+	if !valid || needsRehash {
+		t.Errorf("Unexpected result for a current hash: %t, %t", valid, needsRehash)
+	}
+
+	valid, _ = checkPassword(hashed, "welcome2")
+	if valid {
+		t.Errorf("Invalid password accepted")
+	}
+
+	other, _ := hashPassword("welcome1")
+	if other == hashed {
+		t.Errorf("Hashes for the same password should use different salts")
+	}
+}
+
+func TestCheckPasswordLegacy(t *testing.T) {
+	settings.Auth.LegacySalt = "something-salty"
+	defer func() { settings.Auth.LegacySalt = "" }()
+
+	legacy := legacyHashPassword("welcome1")
+	valid, needsRehash := checkPassword(legacy, "welcome1")
+	if !valid || !needsRehash {
+		t.Errorf("Unexpected result for a legacy hash: %t, %t", valid, needsRehash)
+	}
+
+	valid, needsRehash = checkPassword(legacy, "welcome2")
+	if valid || needsRehash {
+		t.Errorf("Invalid password accepted for a legacy hash")
+	}
+}
+
+func TestIsValidUserType(t *testing.T) {
+	for _, userType := range []string{"admin", "editor", "family", "friend"} {
+		if !IsValidUserType(userType) {
+			t.Errorf("Valid user type rejected: %s", userType)
+		}
+	}
+	for _, userType := range []string{"", "Admin", "root", "guest", "public"} {
+		if IsValidUserType(userType) {
+			t.Errorf("Invalid user type accepted: %s", userType)
+		}
+	}
+}
@@ -0,0 +1,285 @@
+package oidc
+
+// Minimal OpenID Connect client: authorization code flow with PKCE and
+// validation of RS256 ID tokens against the provider's JWKS.
+//
+// provider, err := oidc.Discover(issuer, clientId, clientSecret, redirectUrl)
+// url := provider.AuthCodeUrl(state, nonce, oidc.Challenge(verifier))
+// ...the provider redirects back with ?code=...&state=...
+// claims, err := provider.Exchange(code, verifier, nonce)
+//
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+type Provider struct {
+	Issuer       string
+	ClientId     string
+	ClientSecret string
+	RedirectUrl  string
+	Scopes       []string
+
+	AuthorizationEndpoint string
+	TokenEndpoint         string
+	JwksUri               string
+
+	Client *http.Client
+
+	mutex       sync.Mutex
+	keys        map[string]jwk // by kid
+	keysFetched time.Time
+}
+
+// The claims of an ID token that we care about.
+type Claims struct {
+	Issuer        string   `json:"iss"`
+	Subject       string   `json:"sub"`
+	Audience      audience `json:"aud"`
+	Expires       int64    `json:"exp"`
+	IssuedAt      int64    `json:"iat"`
+	Nonce         string   `json:"nonce"`
+	Email         string   `json:"email"`
+	EmailVerified bool     `json:"email_verified"`
+	Name          string   `json:"name"`
+}
+
+// How long the keys fetched from the JWKS URI are cached, and how
+// often we are willing to fetch them again when we get a token signed
+// with a key that we don't know about.
+const keysTimeout = time.Hour
+const keysMinRefresh = time.Minute
+
+// Tolerance when checking the expiration and issued at times.
+const clockSkew = 2 * time.Minute
+
+var ErrInvalidToken = errors.New("Invalid ID token")
+
+// Fetches the provider's configuration from its discovery document
+// (ISSUER/.well-known/openid-configuration).
+func Discover(issuer, clientId, clientSecret, redirectUrl string) (*Provider, error) {
+	p := &Provider{
+		Issuer:       strings.TrimRight(issuer, "/"),
+		ClientId:     clientId,
+		ClientSecret: clientSecret,
+		RedirectUrl:  redirectUrl,
+		Scopes:       []string{"openid", "email", "profile"},
+		Client:       &http.Client{Timeout: 10 * time.Second},
+	}
+
+	var config struct {
+		Issuer                string `json:"issuer"`
+		AuthorizationEndpoint string `json:"authorization_endpoint"`
+		TokenEndpoint         string `json:"token_endpoint"`
+		JwksUri               string `json:"jwks_uri"`
+	}
+	if err := p.getJson(p.Issuer+"/.well-known/openid-configuration", &config); err != nil {
+		return nil, err
+	}
+	if strings.TrimRight(config.Issuer, "/") != p.Issuer {
+		return nil, fmt.Errorf("Issuer in discovery document (%s) does not match %s", config.Issuer, p.Issuer)
+	}
+	if config.AuthorizationEndpoint == "" || config.TokenEndpoint == "" || config.JwksUri == "" {
+		return nil, errors.New("Discovery document is missing endpoints")
+	}
+	p.AuthorizationEndpoint = config.AuthorizationEndpoint
+	p.TokenEndpoint = config.TokenEndpoint
+	p.JwksUri = config.JwksUri
+	return p, nil
+}
+
+// Random value for the state, nonce, and PKCE verifier.
+func RandomString() (string, error) {
+	rb := make([]byte, 32)
+	if _, err := rand.Read(rb); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(rb), nil
+}
+
+// PKCE code challenge (S256) for a verifier.
+func Challenge(verifier string) string {
+	hash := sha256.Sum256([]byte(verifier))
+	return base64.RawURLEncoding.EncodeToString(hash[:])
+}
+
+// URL to send the user to so that they login with the provider.
+func (p *Provider) AuthCodeUrl(state, nonce, challenge string) string {
+	values := url.Values{}
+	values.Set("response_type", "code")
+	values.Set("client_id", p.ClientId)
+	values.Set("redirect_uri", p.RedirectUrl)
+	values.Set("scope", strings.Join(p.Scopes, " "))
+	values.Set("state", state)
+	values.Set("nonce", nonce)
+	values.Set("code_challenge", challenge)
+	values.Set("code_challenge_method", "S256")
+
+	separator := "?"
+	if strings.Contains(p.AuthorizationEndpoint, "?") {
+		separator = "&"
+	}
+	return p.AuthorizationEndpoint + separator + values.Encode()
+}
+
+// Exchanges the authorization code for the tokens and returns the
+// claims of the (validated) ID token.
+func (p *Provider) Exchange(code, verifier, nonce string) (Claims, error) {
+	values := url.Values{}
+	values.Set("grant_type", "authorization_code")
+	values.Set("code", code)
+	values.Set("redirect_uri", p.RedirectUrl)
+	values.Set("client_id", p.ClientId)
+	values.Set("code_verifier", verifier)
+	if p.ClientSecret != "" {
+		values.Set("client_secret", p.ClientSecret)
+	}
+
+	resp, err := p.Client.PostForm(p.TokenEndpoint, values)
+	if err != nil {
+		return Claims{}, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return Claims{}, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return Claims{}, fmt.Errorf("Token endpoint returned %d: %s", resp.StatusCode, body)
+	}
+
+	var tokens struct {
+		IdToken string `json:"id_token"`
+	}
+	if err := json.Unmarshal(body, &tokens); err != nil {
+		return Claims{}, err
+	}
+	if tokens.IdToken == "" {
+		return Claims{}, errors.New("Token endpoint did not return an ID token")
+	}
+	return p.Verify(tokens.IdToken, nonce, time.Now())
+}
+
+// Validates the signature and the claims of an ID token.
+func (p *Provider) Verify(idToken, nonce string, now time.Time) (Claims, error) {
+	header, claims, signed, signature, err := parseJwt(idToken)
+	if err != nil {
+		return Claims{}, err
+	}
+	if header.Alg != "RS256" {
+		return Claims{}, fmt.Errorf("Unsupported ID token algorithm (%s)", header.Alg)
+	}
+
+	key, err := p.key(header.Kid)
+	if err != nil {
+		return Claims{}, err
+	}
+	if err := verifyRS256(key, signed, signature); err != nil {
+		return Claims{}, ErrInvalidToken
+	}
+
+	if strings.TrimRight(claims.Issuer, "/") != p.Issuer {
+		return Claims{}, fmt.Errorf("ID token issuer (%s) does not match", claims.Issuer)
+	}
+	if !claims.Audience.contains(p.ClientId) {
+		return Claims{}, errors.New("ID token was not issued for this client")
+	}
+	if now.Add(-clockSkew).Unix() > claims.Expires {
+		return Claims{}, errors.New("ID token has expired")
+	}
+	if claims.IssuedAt > now.Add(clockSkew).Unix() {
+		return Claims{}, errors.New("ID token was issued in the future")
+	}
+	if nonce == "" || claims.Nonce != nonce {
+		return Claims{}, errors.New("ID token nonce does not match")
+	}
+	return claims, nil
+}
+
+// Returns the key with the given ID, refreshing the keys from the
+// JWKS URI when they are stale or the key is not known (the provider
+// might have rotated them).
+func (p *Provider) key(kid string) (jwk, error) {
+	p.mutex.Lock()
+	defer p.mutex.Unlock()
+
+	now := time.Now()
+	key, ok := p.keys[kid]
+	if ok && now.Sub(p.keysFetched) < keysTimeout {
+		return key, nil
+	}
+	if !ok && now.Sub(p.keysFetched) < keysMinRefresh {
+		return jwk{}, fmt.Errorf("Unknown ID token key (%s)", kid)
+	}
+
+	var set struct {
+		Keys []jwk `json:"keys"`
+	}
+	if err := p.getJson(p.JwksUri, &set); err != nil {
+		return jwk{}, err
+	}
+	p.keys = map[string]jwk{}
+	for _, key := range set.Keys {
+		if key.Kty == "RSA" && (key.Use == "" || key.Use == "sig") {
+			p.keys[key.Kid] = key
+		}
+	}
+	p.keysFetched = now
+
+	key, ok = p.keys[kid]
+	if !ok {
+		return jwk{}, fmt.Errorf("Unknown ID token key (%s)", kid)
+	}
+	return key, nil
+}
+
+func (p *Provider) getJson(url string, target interface{}) error {
+	resp, err := p.Client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned %d", url, resp.StatusCode)
+	}
+	return json.NewDecoder(io.LimitReader(resp.Body, 1<<20)).Decode(target)
+}
+
+// The "aud" claim can be a string or an array of strings.
+type audience []string
+
+func (a *audience) UnmarshalJSON(data []byte) error {
+	var single string
+	if err := json.Unmarshal(data, &single); err == nil {
+		*a = audience{single}
+		return nil
+	}
+	var many []string
+	if err := json.Unmarshal(data, &many); err != nil {
+		return err
+	}
+	*a = audience(many)
+	return nil
+}
+
+func (a audience) contains(value string) bool {
+	for _, v := range a {
+		if v == value {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,136 @@
+package oidc
+
+import (
+	"net/http"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"hectorcorrea.com/hk/oidc/oidctest"
+)
+
+const testRedirect = "http://localhost:9001/auth/oidc/callback"
+
+// Runs the whole flow against the stand-in provider: discovery, the
+// redirect to the authorization endpoint, and the code exchange.
+func TestCodeFlowWithPkce(t *testing.T) {
+	server := oidctest.NewServer("client1", "someone@somewhere.com")
+	defer server.Close()
+
+	provider, err := Discover(server.URL, "client1", "secret", testRedirect)
+	if err != nil {
+		t.Fatalf("Discovery failed: %s", err)
+	}
+
+	state, _ := RandomString()
+	nonce, _ := RandomString()
+	verifier, _ := RandomString()
+	authUrl := provider.AuthCodeUrl(state, nonce, Challenge(verifier))
+
+	// Don't follow the redirect back to the (non existing) client.
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	resp, err := client.Get(authUrl)
+	if err != nil {
+		t.Fatalf("Authorization request failed: %s", err)
+	}
+	resp.Body.Close()
+
+	location, err := url.Parse(resp.Header.Get("Location"))
+	if err != nil || !strings.HasPrefix(location.String(), testRedirect) {
+		t.Fatalf("Unexpected redirect: %s", resp.Header.Get("Location"))
+	}
+	if location.Query().Get("state") != state {
+		t.Errorf("State was not returned")
+	}
+	code := location.Query().Get("code")
+
+	// A wrong verifier must be rejected.
+	if _, err := provider.Exchange(code, "wrong-verifier", nonce); err == nil {
+		t.Errorf("Exchange with the wrong verifier should have failed")
+	}
+
+	// Codes are single use (the failed attempt above used it).
+	resp, _ = client.Get(authUrl)
+	resp.Body.Close()
+	location, _ = url.Parse(resp.Header.Get("Location"))
+	code = location.Query().Get("code")
+
+	claims, err := provider.Exchange(code, verifier, nonce)
+	if err != nil {
+		t.Fatalf("Exchange failed: %s", err)
+	}
+	if claims.Email != "someone@somewhere.com" || !claims.EmailVerified || claims.Subject != "subject-1" {
+		t.Errorf("Unexpected claims: %#v", claims)
+	}
+}
+
+func TestVerify(t *testing.T) {
+	server := oidctest.NewServer("client1", "someone@somewhere.com")
+	defer server.Close()
+
+	provider, err := Discover(server.URL, "client1", "", testRedirect)
+	if err != nil {
+		t.Fatalf("Discovery failed: %s", err)
+	}
+
+	now := time.Now()
+	valid := func() map[string]interface{} {
+		return map[string]interface{}{
+			"iss":   server.URL,
+			"sub":   "subject-1",
+			"aud":   []string{"other", "client1"},
+			"exp":   now.Add(time.Minute).Unix(),
+			"iat":   now.Unix(),
+			"nonce": "nonce1",
+		}
+	}
+
+	if _, err := provider.Verify(server.Sign(valid()), "nonce1", now); err != nil {
+		t.Errorf("Valid token was rejected: %s", err)
+	}
+
+	tests := []struct {
+		name  string
+		key   string
+		value interface{}
+	}{
+		{"wrong issuer", "iss", "https://evil.com"},
+		{"wrong audience", "aud", "client2"},
+		{"expired", "exp", now.Add(-time.Hour).Unix()},
+		{"issued in the future", "iat", now.Add(time.Hour).Unix()},
+		{"wrong nonce", "nonce", "nonce2"},
+	}
+	for _, test := range tests {
+		claims := valid()
+		claims[test.key] = test.value
+		if _, err := provider.Verify(server.Sign(claims), "nonce1", now); err == nil {
+			t.Errorf("Token with %s was accepted", test.name)
+		}
+	}
+
+	// Tampered payload
+	token := server.Sign(valid())
+	parts := strings.Split(token, ".")
+	other := strings.Split(server.Sign(map[string]interface{}{"iss": server.URL, "sub": "admin"}), ".")
+	tampered := parts[0] + "." + other[1] + "." + parts[2]
+	if _, err := provider.Verify(tampered, "nonce1", now); err != ErrInvalidToken {
+		t.Errorf("Tampered token was accepted: %v", err)
+	}
+
+	// Unsigned token
+	unsigned := "eyJhbGciOiJub25lIn0." + parts[1] + "."
+	if _, err := provider.Verify(unsigned, "nonce1", now); err == nil {
+		t.Errorf("Unsigned token was accepted")
+	}
+}
+
+func TestChallenge(t *testing.T) {
+	// Example from RFC 7636, appendix B.
+	verifier := "dBjftJeZ4CVP-mB92K27uhbUJU1p1r_wW1gFWFOEjXk"
+	if Challenge(verifier) != "E9Melhoa2OwvFrEMTJguCHaoeK1t8URWbuGJSstw-cM" {
+		t.Errorf("Unexpected challenge: %s", Challenge(verifier))
+	}
+}
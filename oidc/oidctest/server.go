@@ -0,0 +1,168 @@
+package oidctest
+
+// Stand-in OpenID Connect provider for tests. It implements discovery,
+// the JWKS URI, an authorization endpoint that immediately redirects
+// back with a code (as if the user had logged in), and a token
+// endpoint that validates the PKCE verifier and returns an RS256
+// signed ID token.
+//
+// server := oidctest.NewServer("client1", "someone@somewhere.com")
+// defer server.Close()
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"sync"
+	"time"
+)
+
+type Server struct {
+	*httptest.Server
+	Key           *rsa.PrivateKey
+	KeyId         string
+	ClientId      string
+	Email         string
+	EmailVerified bool
+
+	mutex sync.Mutex
+	codes map[string]authRequest
+}
+
+type authRequest struct {
+	redirectUri string
+	challenge   string
+	nonce       string
+}
+
+func NewServer(clientId string, email string) *Server {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		panic(err)
+	}
+
+	s := &Server{
+		Key:           key,
+		KeyId:         "test-key",
+		ClientId:      clientId,
+		Email:         email,
+		EmailVerified: true,
+		codes:         map[string]authRequest{},
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", s.discovery)
+	mux.HandleFunc("/jwks", s.jwks)
+	mux.HandleFunc("/authorize", s.authorize)
+	mux.HandleFunc("/token", s.token)
+	s.Server = httptest.NewServer(mux)
+	return s
+}
+
+func (s *Server) discovery(resp http.ResponseWriter, req *http.Request) {
+	writeJson(resp, map[string]string{
+		"issuer":                 s.URL,
+		"authorization_endpoint": s.URL + "/authorize",
+		"token_endpoint":         s.URL + "/token",
+		"jwks_uri":               s.URL + "/jwks",
+	})
+}
+
+func (s *Server) jwks(resp http.ResponseWriter, req *http.Request) {
+	e := big.NewInt(int64(s.Key.PublicKey.E)).Bytes()
+	key := map[string]string{
+		"kty": "RSA",
+		"kid": s.KeyId,
+		"use": "sig",
+		"alg": "RS256",
+		"n":   base64.RawURLEncoding.EncodeToString(s.Key.PublicKey.N.Bytes()),
+		"e":   base64.RawURLEncoding.EncodeToString(e),
+	}
+	writeJson(resp, map[string]interface{}{"keys": []interface{}{key}})
+}
+
+// Redirects back to the client right away with a new code.
+func (s *Server) authorize(resp http.ResponseWriter, req *http.Request) {
+	query := req.URL.Query()
+	if query.Get("client_id") != s.ClientId || query.Get("code_challenge_method") != "S256" {
+		http.Error(resp, "invalid_request", http.StatusBadRequest)
+		return
+	}
+
+	code := fmt.Sprintf("code-%d", time.Now().UnixNano())
+	s.mutex.Lock()
+	s.codes[code] = authRequest{
+		redirectUri: query.Get("redirect_uri"),
+		challenge:   query.Get("code_challenge"),
+		nonce:       query.Get("nonce"),
+	}
+	s.mutex.Unlock()
+
+	values := url.Values{}
+	values.Set("code", code)
+	values.Set("state", query.Get("state"))
+	http.Redirect(resp, req, query.Get("redirect_uri")+"?"+values.Encode(), http.StatusFound)
+}
+
+func (s *Server) token(resp http.ResponseWriter, req *http.Request) {
+	code := req.FormValue("code")
+	s.mutex.Lock()
+	auth, ok := s.codes[code]
+	delete(s.codes, code)
+	s.mutex.Unlock()
+
+	hash := sha256.Sum256([]byte(req.FormValue("code_verifier")))
+	challenge := base64.RawURLEncoding.EncodeToString(hash[:])
+	if !ok || req.FormValue("grant_type") != "authorization_code" ||
+		req.FormValue("client_id") != s.ClientId ||
+		req.FormValue("redirect_uri") != auth.redirectUri ||
+		challenge != auth.challenge {
+		resp.WriteHeader(http.StatusBadRequest)
+		writeJson(resp, map[string]string{"error": "invalid_grant"})
+		return
+	}
+
+	now := time.Now()
+	claims := map[string]interface{}{
+		"iss":            s.URL,
+		"sub":            "subject-1",
+		"aud":            s.ClientId,
+		"exp":            now.Add(5 * time.Minute).Unix(),
+		"iat":            now.Unix(),
+		"nonce":          auth.nonce,
+		"email":          s.Email,
+		"email_verified": s.EmailVerified,
+		"name":           "Test User",
+	}
+	writeJson(resp, map[string]string{
+		"access_token": "access-" + code,
+		"token_type":   "Bearer",
+		"id_token":     s.Sign(claims),
+	})
+}
+
+// Returns a JWT with the claims signed with the server's key.
+func (s *Server) Sign(claims map[string]interface{}) string {
+	header, _ := json.Marshal(map[string]string{"alg": "RS256", "typ": "JWT", "kid": s.KeyId})
+	payload, _ := json.Marshal(claims)
+	signed := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(payload)
+	hash := sha256.Sum256([]byte(signed))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, s.Key, crypto.SHA256, hash[:])
+	if err != nil {
+		panic(err)
+	}
+	return signed + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func writeJson(resp http.ResponseWriter, value interface{}) {
+	resp.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(resp).Encode(value)
+}
@@ -0,0 +1,293 @@
+/*
+ * A minimal "slippy map" to show the geotagged blogs and photos.
+ *
+ * Tiles are fetched from the tile server indicated in the settings
+ * table ({z}/{x}/{y} URL template, like OpenStreetMap) so that a local
+ * tile server can be used when there is no Internet access.
+ *
+ *    new HkMap(element, {
+ *      tileUrl: "https://tile.openstreetmap.org/{z}/{x}/{y}.png",
+ *      attribution: "...",
+ *      points: [{title: "", url: "", thumbnail: "", lat: 0, lng: 0}]
+ *    });
+ *
+ * Points that are close to each other at the current zoom level are
+ * shown as a single marker with the number of points in it. Clicking
+ * on it zooms in.
+ */
+(function(window, document) {
+  "use strict";
+
+  var TILE_SIZE = 256;
+  var MIN_ZOOM = 1;
+  var MAX_ZOOM = 18;
+  var MAX_FIT_ZOOM = 14;
+  var CLUSTER_SIZE = 40; // pixels
+
+  // Web Mercator projection to pixels at the given zoom level
+  function project(lat, lng, zoom) {
+    var scale = TILE_SIZE * Math.pow(2, zoom);
+    var sin = Math.sin(lat * Math.PI / 180);
+    sin = Math.min(Math.max(sin, -0.9999), 0.9999);
+    return {
+      x: (lng + 180) / 360 * scale,
+      y: (0.5 - Math.log((1 + sin) / (1 - sin)) / (4 * Math.PI)) * scale
+    };
+  }
+
+  function unproject(x, y, zoom) {
+    var scale = TILE_SIZE * Math.pow(2, zoom);
+    var n = Math.PI - 2 * Math.PI * y / scale;
+    return {
+      lat: 180 / Math.PI * Math.atan(0.5 * (Math.exp(n) - Math.exp(-n))),
+      lng: x / scale * 360 - 180
+    };
+  }
+
+  function HkMap(element, options) {
+    this.element = element;
+    this.options = options || {};
+    this.points = this.options.points || [];
+    this.center = {lat: 0, lng: 0};
+    this.zoom = 2;
+    this.drag = null;
+
+    this.element.className += " hk-map";
+    this.tiles = this.addDiv("hk-map-tiles");
+    this.markers = this.addDiv("hk-map-markers");
+    this.addControls();
+    this.addDiv("hk-map-attribution").textContent = this.options.attribution || "";
+
+    this.fit();
+    this.bindEvents();
+    this.render();
+  }
+
+  HkMap.prototype.addDiv = function(className) {
+    var div = document.createElement("div");
+    div.className = className;
+    this.element.appendChild(div);
+    return div;
+  };
+
+  HkMap.prototype.addControls = function() {
+    var self = this;
+    var controls = this.addDiv("hk-map-controls");
+    var zoomIn = document.createElement("button");
+    var zoomOut = document.createElement("button");
+    zoomIn.textContent = "+";
+    zoomOut.textContent = "-";
+    zoomIn.onclick = function() { self.setZoom(self.zoom + 1); };
+    zoomOut.onclick = function() { self.setZoom(self.zoom - 1); };
+    controls.appendChild(zoomIn);
+    controls.appendChild(zoomOut);
+  };
+
+  // Picks the center and the largest zoom level that shows all the points.
+  HkMap.prototype.fit = function() {
+    if (this.points.length === 0) {
+      return;
+    }
+
+    var minLat = 90, maxLat = -90, minLng = 180, maxLng = -180;
+    this.points.forEach(function(point) {
+      minLat = Math.min(minLat, point.lat);
+      maxLat = Math.max(maxLat, point.lat);
+      minLng = Math.min(minLng, point.lng);
+      maxLng = Math.max(maxLng, point.lng);
+    });
+    this.center = {lat: (minLat + maxLat) / 2, lng: (minLng + maxLng) / 2};
+
+    var width = this.element.clientWidth - CLUSTER_SIZE * 2;
+    var height = this.element.clientHeight - CLUSTER_SIZE * 2;
+    for (var zoom = MAX_FIT_ZOOM; zoom > MIN_ZOOM; zoom--) {
+      var topLeft = project(maxLat, minLng, zoom);
+      var bottomRight = project(minLat, maxLng, zoom);
+      if (bottomRight.x - topLeft.x <= width && bottomRight.y - topLeft.y <= height) {
+        break;
+      }
+    }
+    this.zoom = zoom;
+  };
+
+  HkMap.prototype.setZoom = function(zoom, around) {
+    zoom = Math.min(Math.max(zoom, MIN_ZOOM), MAX_ZOOM);
+    if (around) {
+      this.center = around;
+    }
+    this.zoom = zoom;
+    this.render();
+  };
+
+  // Returns the pixel (at the current zoom level) of the top left corner.
+  HkMap.prototype.origin = function() {
+    var center = project(this.center.lat, this.center.lng, this.zoom);
+    return {
+      x: center.x - this.element.clientWidth / 2,
+      y: center.y - this.element.clientHeight / 2
+    };
+  };
+
+  HkMap.prototype.render = function() {
+    this.renderTiles();
+    this.renderMarkers();
+  };
+
+  HkMap.prototype.renderTiles = function() {
+    var origin = this.origin();
+    var count = Math.pow(2, this.zoom);
+    var firstX = Math.floor(origin.x / TILE_SIZE);
+    var firstY = Math.floor(origin.y / TILE_SIZE);
+    var lastX = Math.floor((origin.x + this.element.clientWidth) / TILE_SIZE);
+    var lastY = Math.floor((origin.y + this.element.clientHeight) / TILE_SIZE);
+
+    var fragment = document.createDocumentFragment();
+    for (var tileX = firstX; tileX <= lastX; tileX++) {
+      for (var tileY = firstY; tileY <= lastY; tileY++) {
+        if (tileY < 0 || tileY >= count) {
+          continue;
+        }
+        var x = ((tileX % count) + count) % count; // wrap around the world
+        var img = document.createElement("img");
+        img.src = this.options.tileUrl
+          .replace("{s}", "a")
+          .replace("{z}", this.zoom)
+          .replace("{x}", x)
+          .replace("{y}", tileY);
+        img.style.left = Math.round(tileX * TILE_SIZE - origin.x) + "px";
+        img.style.top = Math.round(tileY * TILE_SIZE - origin.y) + "px";
+        img.alt = "";
+        fragment.appendChild(img);
+      }
+    }
+    this.tiles.innerHTML = "";
+    this.tiles.appendChild(fragment);
+  };
+
+  // Groups the points that fall in the same cell of a grid.
+  HkMap.prototype.clusters = function() {
+    var zoom = this.zoom;
+    var cells = {};
+    var clusters = [];
+    this.points.forEach(function(point) {
+      var pixel = project(point.lat, point.lng, zoom);
+      var key = Math.floor(pixel.x / CLUSTER_SIZE) + ":" + Math.floor(pixel.y / CLUSTER_SIZE);
+      var cluster = cells[key];
+      if (!cluster) {
+        cluster = {x: 0, y: 0, points: []};
+        cells[key] = cluster;
+        clusters.push(cluster);
+      }
+      cluster.points.push(point);
+      cluster.x += pixel.x;
+      cluster.y += pixel.y;
+    });
+    clusters.forEach(function(cluster) {
+      cluster.x = cluster.x / cluster.points.length;
+      cluster.y = cluster.y / cluster.points.length;
+    });
+    return clusters;
+  };
+
+  HkMap.prototype.renderMarkers = function() {
+    var self = this;
+    var origin = this.origin();
+    this.markers.innerHTML = "";
+    this.clusters().forEach(function(cluster) {
+      var marker;
+      if (cluster.points.length === 1) {
+        var point = cluster.points[0];
+        marker = document.createElement("a");
+        marker.className = "hk-map-marker";
+        marker.href = point.url;
+        marker.title = point.title || "";
+        if (point.thumbnail) {
+          var img = document.createElement("img");
+          img.src = point.thumbnail;
+          img.alt = point.title || "";
+          marker.appendChild(img);
+        }
+      } else {
+        marker = document.createElement("div");
+        marker.className = "hk-map-marker hk-map-cluster";
+        marker.textContent = cluster.points.length;
+        marker.title = cluster.points.map(function(p) { return p.title; }).join("\n");
+        marker.onclick = function() {
+          self.setZoom(self.zoom + 2, unproject(cluster.x, cluster.y, self.zoom));
+        };
+      }
+      marker.style.left = Math.round(cluster.x - origin.x) + "px";
+      marker.style.top = Math.round(cluster.y - origin.y) + "px";
+      self.markers.appendChild(marker);
+    });
+  };
+
+  HkMap.prototype.bindEvents = function() {
+    var self = this;
+
+    var start = function(x, y) {
+      var center = project(self.center.lat, self.center.lng, self.zoom);
+      self.drag = {x: x, y: y, center: center, moved: false};
+    };
+    var move = function(x, y) {
+      if (!self.drag) {
+        return;
+      }
+      var dx = x - self.drag.x;
+      var dy = y - self.drag.y;
+      if (Math.abs(dx) + Math.abs(dy) > 3) {
+        self.drag.moved = true;
+      }
+      self.center = unproject(self.drag.center.x - dx, self.drag.center.y - dy, self.zoom);
+      self.render();
+    };
+    var end = function() {
+      var moved = self.drag && self.drag.moved;
+      window.setTimeout(function() { self.drag = null; }, 0);
+      return moved;
+    };
+
+    this.element.addEventListener("mousedown", function(e) {
+      if (e.target.tagName === "BUTTON") {
+        return;
+      }
+      start(e.clientX, e.clientY);
+      e.preventDefault();
+    });
+    window.addEventListener("mousemove", function(e) { move(e.clientX, e.clientY); });
+    window.addEventListener("mouseup", function() { end(); });
+
+    this.element.addEventListener("touchstart", function(e) {
+      start(e.touches[0].clientX, e.touches[0].clientY);
+    });
+    this.element.addEventListener("touchmove", function(e) {
+      move(e.touches[0].clientX, e.touches[0].clientY);
+      e.preventDefault();
+    });
+    this.element.addEventListener("touchend", function() { end(); });
+
+    // Don't follow a link if the user was dragging the map.
+    this.element.addEventListener("click", function(e) {
+      if (self.drag && self.drag.moved) {
+        e.preventDefault();
+        e.stopPropagation();
+      }
+    }, true);
+
+    this.element.addEventListener("dblclick", function(e) {
+      var rect = self.element.getBoundingClientRect();
+      var origin = self.origin();
+      var around = unproject(origin.x + e.clientX - rect.left, origin.y + e.clientY - rect.top, self.zoom);
+      self.setZoom(self.zoom + 1, around);
+    });
+
+    this.element.addEventListener("wheel", function(e) {
+      self.setZoom(self.zoom + (e.deltaY < 0 ? 1 : -1));
+      e.preventDefault();
+    });
+
+    window.addEventListener("resize", function() { self.render(); });
+  };
+
+  window.HkMap = HkMap;
+})(window, document);
@@ -5,11 +5,12 @@ import (
 	"os"
 	"strings"
 
+	"hectorcorrea.com/hk/config"
 	"hectorcorrea.com/hk/models"
 )
 
-// Adds a new guest user.
-func AddUser(userPassword string) {
+// Adds a new user with the family role.
+func AddUser(c config.Config, userPassword string) {
 	log.SetOutput(os.Stdout) // so we can redirect it
 
 	tokens := strings.Split(userPassword, "/")
@@ -17,15 +18,15 @@ func AddUser(userPassword string) {
 		log.Fatal("String must be in the form user/password")
 	}
 
-	if err := models.InitDB(); err != nil {
-		log.Fatal("Failed to initialize database: %s", err)
+	if err := models.InitDB(c); err != nil {
+		log.Fatalf("Failed to initialize database: %s", err)
 	}
 	log.Printf("Database: %s", models.DbConnStringSafe())
 
 	err := models.AddGuestUser(tokens[0], tokens[1])
 	if err != nil {
-		log.Fatal("Error: %s", err)
+		log.Fatalf("Error: %s", err)
 	} else {
-		log.Printf("Guest user %s added", tokens[0])
+		log.Printf("User %s added", tokens[0])
 	}
 }
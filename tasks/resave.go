@@ -4,19 +4,20 @@ import (
 	"log"
 	"os"
 
+	"hectorcorrea.com/hk/config"
 	"hectorcorrea.com/hk/models"
 )
 
 // Re-saves all blogs. Used to populate the information as we
 // update the site (e.g. add photos to DB or handling of legacy HTML
 // already on the topics).
-func ResaveAll() {
+func ResaveAll(c config.Config) {
 	log.SetOutput(os.Stdout) // so we can redirect it
-	if err := models.InitDB(); err != nil {
+	if err := models.InitDB(c); err != nil {
 		log.Fatal("Failed to initialize database: ", err)
 	}
 	log.Printf("Database: %s", models.DbConnStringSafe())
-	blogs, _ := models.BlogGetAll()
+	blogs, _ := models.BlogGetAll(models.RoleAdmin)
 	for _, b := range blogs {
 		log.Printf("re-saving %d - %s", b.Id, b.Title)
 		blog, _ := models.BlogGetById(b.Id)
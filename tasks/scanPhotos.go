@@ -1,49 +1,463 @@
 package tasks
 
 import (
+	"crypto/sha256"
 	"fmt"
+	"io"
 	"log"
 	"os"
 	"path/filepath"
+	"runtime"
+	"strings"
+	"sync"
+	"time"
 
+	"hectorcorrea.com/hk/config"
 	"hectorcorrea.com/hk/models"
 )
 
+type ScanOptions struct {
+	DryRun         bool // report what would be done but don't touch the DB
+	RewriteContent bool // update the blogs that reference moved photos
+	Workers        int  // number of files hashed in parallel
+	Force          bool // re-process files even if they have not changed
+}
+
+type scanReport struct {
+	added      []string
+	duplicates []string
+	moved      []string
+	missing    []string
+	errors     []string
+	unchanged  int
+	updated    int
+}
+
+// A file found on disk (and its hash and GPS coordinates).
+type scanFile struct {
+	path   string
+	info   os.FileInfo
+	hash   string
+	hasGPS bool
+	lat    float64
+	lng    float64
+	err    error
+}
+
+type photoScanner struct {
+	folder  string
+	root    string // folder served as /photos/
+	options ScanOptions
+	report  scanReport
+	known   map[string]models.Photo    // under the folder as loaded from the DB, read-only
+	byPath  map[string]*models.Photo   // under the folder
+	byHash  map[string][]*models.Photo // all the photos, to detect the ones moved into the folder
+	seen    map[string]bool
+	batch   []models.Photo
+	moves   []photoMove // of the photos in batch
+	scanned int
+}
+
+// The blogs are only updated for a move once the new path of the photo
+// has been saved so that they never reference a path that the photos
+// table does not have.
+type photoMove struct {
+	path   string
+	oldUrl string
+	newUrl string
+}
+
+const scanBatchSize = 100
+const scanProgressEvery = 5 * time.Second
+
 // Scan files on a folder (and its subfolders) and adds them
 // to the photos table if they are not there already.
-func ScanPhotos(folder string) {
+//
+// Photos are identified by the hash of their content so that a photo
+// that was moved to a different folder (or renamed) is updated rather
+// than added again, and a copy of a photo already in the database is
+// reported as a duplicate rather than added.
+//
+// Files whose size and modification time match what we have in the
+// database are not hashed again, which makes re-scans (including
+// re-running a scan that was interrupted) only touch what changed.
+// Photos under the folder that are no longer on disk are flagged
+// with on_disk = 0.
+func ScanPhotos(c config.Config, folder string, options ScanOptions) {
 	log.Printf("Scanning for photos. Folder: %s", folder)
+	if options.DryRun {
+		log.Printf("Dry run, the database will not be updated")
+	}
+	if options.Workers <= 0 {
+		options.Workers = runtime.NumCPU()
+	}
 
-	if err := models.InitDB(); err != nil {
+	if err := models.InitDB(c); err != nil {
 		log.Fatal("Failed to initialize database: ", err)
 	}
 
 	log.Printf("Database: %s", models.DbConnStringSafe())
 
-	// https://stackoverflow.com/a/6612243/446681
-	err := filepath.Walk(folder, func(path string, f os.FileInfo, err error) error {
-		isFile := !f.IsDir()
-		if isFile {
-			exist, err := models.PhotoExists(path)
+	root := models.PhotoFolder()
+	if root == "" {
+		log.Printf("photos.folder is not set, URLs will be relative to %s", folder)
+		root = folder
+	}
+
+	scanner, err := newPhotoScanner(folder, root, options)
+	if err != nil {
+		log.Fatal("Failed to load photos from the database: ", err)
+	}
+
+	if err := scanner.Run(); err != nil {
+		log.Fatal(err)
+	}
+
+	scanner.report.Print(options.DryRun)
+}
+
+func newPhotoScanner(folder string, root string, options ScanOptions) (*photoScanner, error) {
+	folder = filepath.Clean(folder)
+	photos, err := models.PhotoGetAll()
+	if err != nil {
+		return nil, err
+	}
+
+	scanner := photoScanner{
+		folder:  folder,
+		root:    filepath.Clean(root),
+		options: options,
+		known:   map[string]models.Photo{},
+		byPath:  map[string]*models.Photo{},
+		byHash:  map[string][]*models.Photo{},
+		seen:    map[string]bool{},
+	}
+	for i := range photos {
+		photo := &photos[i]
+		if isInFolder(folder, photo.Path) {
+			scanner.known[photo.Path] = *photo
+			scanner.byPath[photo.Path] = photo
+		}
+		if photo.Hash != "" {
+			scanner.byHash[photo.Hash] = append(scanner.byHash[photo.Hash], photo)
+		}
+	}
+	log.Printf("Photos already in the database: %d (%d for this folder)", len(photos), len(scanner.known))
+	return &scanner, nil
+}
+
+func isInFolder(folder, path string) bool {
+	return strings.HasPrefix(path, folder+string(filepath.Separator))
+}
+
+// Walks the folder and hashes the new (or changed) files with a pool
+// of workers. All the database updates are done in this goroutine.
+func (s *photoScanner) Run() error {
+	pending := make(chan scanFile, s.options.Workers*2)
+	hashed := make(chan scanFile, s.options.Workers*2)
+
+	var workers sync.WaitGroup
+	for i := 0; i < s.options.Workers; i++ {
+		workers.Add(1)
+		go func() {
+			defer workers.Done()
+			for file := range pending {
+				file.hash, file.err = fileHash(file.path)
+				if file.err == nil && isJpeg(file.path) {
+					var err error
+					file.lat, file.lng, file.hasGPS, err = models.PhotoGPS(file.path)
+					if err != nil {
+						log.Printf("Could not read GPS data from %s: %s", file.path, err)
+					}
+				}
+				hashed <- file
+			}
+		}()
+	}
+
+	var walkErr error
+	go func() {
+		// https://stackoverflow.com/a/6612243/446681
+		walkErr = filepath.Walk(s.folder, func(path string, f os.FileInfo, err error) error {
 			if err != nil {
-				fmt.Printf("Error looking for photo: %s\n", path)
-			} else if exist {
-				// fmt.Printf("Photo already in DB: %s\n", path)
+				hashed <- scanFile{path: path, err: err}
+				return nil
+			}
+			if f.IsDir() {
+				return nil
+			}
+			if photo, found := s.known[path]; found && !s.options.Force && isUnchanged(photo, f) {
+				hashed <- scanFile{path: path, info: f, hash: photo.Hash}
 			} else {
-				id, err := models.PhotoAdd(path, true)
-				if err != nil {
-					fmt.Printf("Error adding photo : %s\n", path)
-				} else {
-					fmt.Printf("Photo added: %s (%d)\n", path, id)
-				}
+				pending <- scanFile{path: path, info: f}
 			}
-		} else {
-			fmt.Printf("Skipped folder %s\n", path)
+			return nil
+		})
+		close(pending)
+		workers.Wait()
+		close(hashed)
+	}()
+
+	lastProgress := time.Now()
+	for file := range hashed {
+		s.process(file)
+		if len(s.batch) >= scanBatchSize {
+			s.flush()
 		}
-		return nil
-	})
+		if time.Since(lastProgress) > scanProgressEvery {
+			s.progress()
+			lastProgress = time.Now()
+		}
+	}
+	s.flush()
+
+	if walkErr != nil {
+		return walkErr
+	}
 
+	// Only reconcile when we walked the whole folder.
+	s.reconcile()
+	return nil
+}
+
+func (s *photoScanner) process(file scanFile) {
+	if file.err != nil {
+		s.report.addError(file.path, file.err)
+		return
+	}
+	s.seen[file.path] = true
+	s.scanned += 1
+
+	photo, found := s.byPath[file.path]
+	if found {
+		if file.hash == photo.Hash && isUnchanged(*photo, file.info) &&
+			photo.Url == photoUrl(s.root, photo.Path) && !s.options.Force {
+			s.report.unchanged += 1
+			return
+		}
+		// Photo already in DB but the file changed (or we didn't have
+		// its hash yet, or its URL was calculated from a different
+		// folder).
+		s.untrackHash(photo)
+		photo.Hash = file.hash
+		s.setFileInfo(photo, file)
+		s.track(photo)
+		s.save(*photo)
+		s.report.updated += 1
+		return
+	}
+
+	for _, match := range s.byHash[file.hash] {
+		if s.seen[match.Path] || fileExists(match.Path) {
+			continue
+		}
+		// Same content as a photo that is no longer where we have it,
+		// the photo was moved.
+		s.move(match, file)
+		return
+	}
+
+	if matches := s.byHash[file.hash]; len(matches) > 0 {
+		// Same content as a photo that is still on disk.
+		s.report.duplicates = append(s.report.duplicates, fmt.Sprintf("%s (same as %s)", file.path, matches[0].Path))
+		return
+	}
+
+	photo = &models.Photo{Path: file.path, Hash: file.hash}
+	s.setFileInfo(photo, file)
+	s.track(photo)
+	s.save(*photo)
+	s.report.added = append(s.report.added, file.path)
+}
+
+// Files that have not been modified since the last scan are
+// not hashed again.
+func isUnchanged(photo models.Photo, info os.FileInfo) bool {
+	return photo.Hash != "" && photo.OnDisk &&
+		photo.Size == info.Size() &&
+		photo.ModifiedOn.Equal(modifiedOn(info))
+}
+
+func (s *photoScanner) setFileInfo(photo *models.Photo, file scanFile) {
+	photo.Url = photoUrl(s.root, photo.Path)
+	photo.OnDisk = true
+	photo.Size = file.info.Size()
+	photo.ModifiedOn = modifiedOn(file.info)
+	photo.HasGPS = file.hasGPS
+	photo.Latitude = file.lat
+	photo.Longitude = file.lng
+}
+
+func (s *photoScanner) move(photo *models.Photo, file scanFile) {
+	oldPath := photo.Path
+	s.report.moved = append(s.report.moved, fmt.Sprintf("%s -> %s", oldPath, file.path))
+
+	delete(s.byPath, oldPath)
+	photo.Path = file.path
+	s.setFileInfo(photo, file)
+	s.byPath[photo.Path] = photo
+	if s.options.DryRun {
+		return
+	}
+	s.save(*photo)
+
+	oldUrl := photoUrl(s.root, oldPath)
+	newUrl := photoUrl(s.root, photo.Path)
+	if oldUrl == "" || newUrl == "" {
+		// Path is outside of the folder served as /photos/, we cannot
+		// tell what URL the blogs would be using for it.
+		return
+	}
+	s.moves = append(s.moves, photoMove{path: photo.Path, oldUrl: oldUrl, newUrl: newUrl})
+}
+
+// Points the blogs that reference the old URL of a moved photo to
+// the new one.
+func (s *photoScanner) rewriteBlogs(move photoMove) {
+	if err := models.BlogPhotosMove(move.oldUrl, move.newUrl); err != nil {
+		s.report.addError(move.path, err)
+		return
+	}
+
+	if s.options.RewriteContent {
+		count, err := models.BlogContentReplace(move.oldUrl, move.newUrl)
+		if err != nil {
+			s.report.addError(move.path, err)
+			return
+		}
+		log.Printf("Updated %d blog rows from %s to %s", count, move.oldUrl, move.newUrl)
+	}
+}
+
+// Flags the photos that we have under the folder but were not found
+// on disk.
+func (s *photoScanner) reconcile() {
+	ids := []int64{}
+	for path, photo := range s.byPath {
+		if s.seen[path] || !photo.OnDisk {
+			continue
+		}
+		ids = append(ids, photo.Id)
+		s.report.missing = append(s.report.missing, path)
+	}
+
+	if s.options.DryRun {
+		return
+	}
+
+	if err := models.PhotoSetOnDisk(ids, false); err != nil {
+		s.report.addError(s.folder, err)
+	}
+}
+
+func (s *photoScanner) track(photo *models.Photo) {
+	s.byPath[photo.Path] = photo
+	if photo.Hash != "" {
+		s.byHash[photo.Hash] = append(s.byHash[photo.Hash], photo)
+	}
+}
+
+func (s *photoScanner) untrackHash(photo *models.Photo) {
+	matches := []*models.Photo{}
+	for _, match := range s.byHash[photo.Hash] {
+		if match != photo {
+			matches = append(matches, match)
+		}
+	}
+	s.byHash[photo.Hash] = matches
+}
+
+func (s *photoScanner) save(photo models.Photo) {
+	if !s.options.DryRun {
+		s.batch = append(s.batch, photo)
+	}
+}
+
+func (s *photoScanner) flush() {
+	if len(s.batch) == 0 {
+		return
+	}
+	if err := models.PhotoSaveBatch(s.batch); err != nil {
+		for _, photo := range s.batch {
+			s.report.addError(photo.Path, err)
+		}
+	} else {
+		for _, move := range s.moves {
+			s.rewriteBlogs(move)
+		}
+	}
+	s.batch = []models.Photo{}
+	s.moves = []photoMove{}
+}
+
+func (s *photoScanner) progress() {
+	log.Printf("Scanned %d files (added: %d, updated: %d, moved: %d, unchanged: %d, errors: %d)",
+		s.scanned, len(s.report.added), s.report.updated, len(s.report.moved),
+		s.report.unchanged, len(s.report.errors))
+}
+
+// Returns the URL used in the blogs for a photo on disk, root is the
+// folder served as /photos/ (which can be a parent of the folder being
+// scanned).
+func photoUrl(root, path string) string {
+	rel, err := filepath.Rel(root, path)
+	if err != nil || strings.HasPrefix(rel, "..") {
+		return ""
+	}
+	return "/photos/" + filepath.ToSlash(rel)
+}
+
+// The database stores modification times with a precision of seconds.
+func modifiedOn(info os.FileInfo) time.Time {
+	return info.ModTime().UTC().Truncate(time.Second)
+}
+
+func fileHash(path string) (string, error) {
+	file, err := os.Open(path)
 	if err != nil {
-		log.Fatal(err)
+		return "", err
+	}
+	defer file.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, file); err != nil {
+		return "", err
+	}
+	return fmt.Sprintf("%x", hash.Sum(nil)), nil
+}
+
+func isJpeg(path string) bool {
+	ext := strings.ToLower(filepath.Ext(path))
+	return ext == ".jpg" || ext == ".jpeg"
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func (r *scanReport) addError(path string, err error) {
+	r.errors = append(r.errors, fmt.Sprintf("%s: %s", path, err))
+}
+
+func (r scanReport) Print(dryRun bool) {
+	if dryRun {
+		fmt.Printf("Scan report (dry run)\n")
+	} else {
+		fmt.Printf("Scan report\n")
+	}
+	printSection("Added", r.added)
+	printSection("Moved", r.moved)
+	printSection("Duplicates (not added)", r.duplicates)
+	printSection("No longer on disk", r.missing)
+	printSection("Errors", r.errors)
+	fmt.Printf("Updated: %d\n", r.updated)
+	fmt.Printf("Unchanged: %d\n", r.unchanged)
+}
+
+func printSection(title string, lines []string) {
+	fmt.Printf("%s: %d\n", title, len(lines))
+	for _, line := range lines {
+		fmt.Printf("  %s\n", line)
 	}
 }
@@ -0,0 +1,27 @@
+package tasks
+
+import "testing"
+
+func TestPhotoUrl(t *testing.T) {
+	tests := [][]string{
+		{"/data/photos", "/data/photos/2008/paris.jpg", "/photos/2008/paris.jpg"},
+		{"/data/photos/", "/data/photos/paris_thumb.jpg", "/photos/paris_thumb.jpg"},
+		{"/data/photos", "/data/other/paris.jpg", ""},
+		{"/data/photos", "/data/photos2/paris.jpg", ""},
+	}
+	for _, test := range tests {
+		url := photoUrl(test[0], test[1])
+		if url != test[2] {
+			t.Errorf("Unexpected URL (%s) for (%s) in (%s)", url, test[1], test[0])
+		}
+	}
+}
+
+func TestIsInFolder(t *testing.T) {
+	if !isInFolder("/data/photos", "/data/photos/2008/paris.jpg") {
+		t.Errorf("Photo in a subfolder not detected")
+	}
+	if isInFolder("/data/photos", "/data/photos2/paris.jpg") || isInFolder("/data/photos", "/data/other/paris.jpg") {
+		t.Errorf("Photo outside of the folder detected as inside")
+	}
+}
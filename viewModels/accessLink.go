@@ -0,0 +1,83 @@
+package viewModels
+
+import (
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
+type AccessLink struct {
+	Id          string
+	Scope       string
+	TargetId    int64
+	Description string
+	CreatedBy   string
+	CreatedOn   string
+	ExpiresOn   string
+	MaxUses     int
+	Uses        int
+	Status      string
+	IsActive    bool
+	Url         string
+}
+
+type AccessLinkList struct {
+	Links   []AccessLink
+	Message string
+	Session
+}
+
+type AccessLinkView struct {
+	Link AccessLink
+	Uses []models.AccessLinkUse
+	Session
+}
+
+const linkDateFormat = "2006-01-02 15:04"
+
+// baseUrl is the scheme and host (e.g. https://somewhere.com) that
+// the links are given out with.
+func FromAccessLink(link models.AccessLink, baseUrl string) AccessLink {
+	now := time.Now().UTC()
+	vm := AccessLink{
+		Id:          link.Id,
+		Scope:       link.Scope,
+		TargetId:    link.TargetId,
+		Description: link.Description,
+		CreatedBy:   link.CreatedBy,
+		CreatedOn:   link.CreatedOn.Format(linkDateFormat),
+		ExpiresOn:   link.ExpiresOn.Format(linkDateFormat),
+		MaxUses:     link.MaxUses,
+		Uses:        link.Uses,
+		IsActive:    link.IsActive(now),
+		Url:         baseUrl + link.Path(),
+	}
+
+	switch {
+	case link.IsRevoked():
+		vm.Status = "revoked"
+	case !now.Before(link.ExpiresOn):
+		vm.Status = "expired"
+	case link.MaxUses > 0 && link.Uses >= link.MaxUses:
+		vm.Status = "used up"
+	default:
+		vm.Status = "active"
+	}
+	return vm
+}
+
+func FromAccessLinks(links []models.AccessLink, baseUrl string, message string, session Session) AccessLinkList {
+	vm := AccessLinkList{Message: message, Session: session}
+	for _, link := range links {
+		vm.Links = append(vm.Links, FromAccessLink(link, baseUrl))
+	}
+	return vm
+}
+
+func NewAccessLinkView(link models.AccessLink, uses []models.AccessLinkUse, baseUrl string, session Session) AccessLinkView {
+	return AccessLinkView{
+		Link:    FromAccessLink(link, baseUrl),
+		Uses:    uses,
+		Session: session,
+	}
+}
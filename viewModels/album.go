@@ -0,0 +1,78 @@
+package viewModels
+
+import (
+	"net/url"
+	"strings"
+
+	"hectorcorrea.com/hk/models"
+)
+
+type AlbumPhoto struct {
+	Thumbnail string
+	Url       string // full size version of the photo
+}
+
+type Album struct {
+	Id         int64
+	Name       string
+	Url        string
+	Cover      string
+	ShareAlias string
+	CreatedOn  string
+	UpdatedOn  string
+	PhotosText string // for editing, one photo per line
+	Photos     []AlbumPhoto
+	Session
+}
+
+type AlbumList struct {
+	Albums []Album
+	Session
+}
+
+func FromAlbum(album models.Album, session Session, raw bool) Album {
+	vm := Album{
+		Id:         album.Id,
+		Name:       album.Name,
+		Url:        album.URL(),
+		ShareAlias: album.ShareAlias,
+		CreatedOn:  album.CreatedOn,
+		UpdatedOn:  album.UpdatedOn,
+		Session:    session,
+	}
+
+	if raw {
+		// For editing we show the data as-is (no masking)
+		vm.Cover = album.Cover
+		vm.PhotosText = strings.Join(album.Photos, "\n")
+		return vm
+	}
+
+	vm.Cover = models.MaskPhotoPaths(album.CoverPhoto())
+	for _, photo := range album.Photos {
+		thumbnail := models.MaskPhotoPaths(photo)
+		full := strings.Replace(thumbnail, "_thumb.jpg", ".jpg", 1)
+		vm.Photos = append(vm.Photos, AlbumPhoto{Thumbnail: thumbnail, Url: full})
+	}
+	return vm
+}
+
+// Same as FromAlbum but the URLs to the photos include the alias of the
+// album so that anonymous users are allowed to see them.
+func FromSharedAlbum(album models.Album, session Session) Album {
+	vm := FromAlbum(album, session, false)
+	query := "?album=" + url.QueryEscape(album.ShareAlias)
+	for i, photo := range vm.Photos {
+		vm.Photos[i].Thumbnail = photo.Thumbnail + query
+		vm.Photos[i].Url = photo.Url + query
+	}
+	return vm
+}
+
+func FromAlbums(albums []models.Album, session Session) AlbumList {
+	list := AlbumList{Session: session}
+	for _, album := range albums {
+		list.Albums = append(list.Albums, FromAlbum(album, session, false))
+	}
+	return list
+}
@@ -0,0 +1,60 @@
+package viewModels
+
+import (
+	"strings"
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
+type ApiToken struct {
+	Id         int64
+	Name       string
+	Scopes     string
+	CreatedOn  string
+	ExpiresOn  string
+	LastUsedOn string
+	Status     string
+	IsActive   bool
+}
+
+type ApiTokenList struct {
+	Tokens   []ApiToken
+	Scopes   []string
+	NewToken string // value of the token just created, only shown once
+	Message  string
+	Session
+}
+
+func FromApiToken(token models.ApiToken) ApiToken {
+	now := time.Now().UTC()
+	vm := ApiToken{
+		Id:        token.Id,
+		Name:      token.Name,
+		Scopes:    strings.Join(token.Scopes, ", "),
+		CreatedOn: token.CreatedOn.Format(linkDateFormat),
+		ExpiresOn: token.ExpiresOn.Format(linkDateFormat),
+		IsActive:  token.IsActive(now),
+	}
+	if !token.LastUsedOn.IsZero() {
+		vm.LastUsedOn = token.LastUsedOn.Format(linkDateFormat)
+	}
+
+	switch {
+	case !token.RevokedOn.IsZero():
+		vm.Status = "revoked"
+	case !vm.IsActive:
+		vm.Status = "expired"
+	default:
+		vm.Status = "active"
+	}
+	return vm
+}
+
+func FromApiTokens(tokens []models.ApiToken, newToken string, message string, session Session) ApiTokenList {
+	vm := ApiTokenList{Scopes: models.ApiScopes, NewToken: newToken, Message: message, Session: session}
+	for _, token := range tokens {
+		vm.Tokens = append(vm.Tokens, FromApiToken(token))
+	}
+	return vm
+}
@@ -0,0 +1,33 @@
+package viewModels
+
+import (
+	"net/url"
+
+	"hectorcorrea.com/hk/models"
+)
+
+type AuditList struct {
+	Events      []models.AuditEvent
+	Actions     []string
+	Actor       string
+	Action      string
+	TargetId    string
+	From        string
+	To          string
+	ExportQuery string // query string to export the same events
+	Session
+}
+
+func NewAuditList(events []models.AuditEvent, query url.Values, session Session) AuditList {
+	return AuditList{
+		Events:      events,
+		Actions:     models.AuditActions,
+		Actor:       query.Get("actor"),
+		Action:      query.Get("action"),
+		TargetId:    query.Get("target"),
+		From:        query.Get("from"),
+		To:          query.Get("to"),
+		ExportQuery: query.Encode(),
+		Session:     session,
+	}
+}
@@ -3,6 +3,7 @@ package viewModels
 import (
 	"fmt"
 	"html/template"
+	"net/url"
 	"regexp"
 	"strings"
 	"time"
@@ -22,6 +23,8 @@ type Blog struct {
 	Thumbnail       string
 	BlogDate        string
 	ShareAlias      string
+	Visibility      string
+	Visibilities    []string // for editing
 	Year            int
 	IsNewYear       bool
 	IsDraft         bool
@@ -29,6 +32,8 @@ type Blog struct {
 	SectionsNextSeq int
 	Html            template.HTML
 	Message         string
+	MapPoints       []MapPoint
+	MapTiles        MapTiles
 	Session
 }
 
@@ -71,6 +76,8 @@ func FromBlog(blog models.Blog, session Session, raw bool) Blog {
 	}
 
 	vm.ShareAlias = blog.ShareAlias
+	vm.Visibility = blog.Visibility
+	vm.Visibilities = models.Roles
 	vm.CreatedOn = blog.CreatedOn
 	vm.PostedOn = blog.PostedOn
 	vm.UpdatedOn = blog.UpdatedOn
@@ -82,6 +89,17 @@ func FromBlog(blog models.Blog, session Session, raw bool) Blog {
 	return vm
 }
 
+// Same as FromBlog but the URLs to the photos include the alias of the
+// blog so that anonymous users are allowed to see them.
+func FromSharedBlog(blog models.Blog, session Session) Blog {
+	vm := FromBlog(blog, session, false)
+	alias := url.QueryEscape(blog.ShareAlias)
+	rePhoto := regexp.MustCompile("\"/photos/([^\"?]*)\"")
+	html := rePhoto.ReplaceAllString(string(vm.Html), "\"/photos/$1?alias="+alias+"\"")
+	vm.Html = template.HTML(html)
+	return vm
+}
+
 func FromBlogs(blogs []models.Blog, session Session, showMore bool) BlogList {
 	matrix := []BlogRow{}
 	r := -1
@@ -0,0 +1,75 @@
+package viewModels
+
+import (
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
+type Invite struct {
+	Id         int64
+	Role       string
+	Note       string
+	Email      string
+	CreatedBy  string
+	CreatedOn  string
+	ExpiresOn  string
+	AcceptedOn string
+	AcceptedBy string
+	Status     string
+	IsPending  bool
+}
+
+type InviteList struct {
+	Invites []Invite
+	Roles   []string
+	Message string
+	NewUrl  string // link of the invite just created, only shown once
+	Session
+}
+
+type InviteAccept struct {
+	Token   string
+	Role    string
+	Login   string
+	Message string
+	Session
+}
+
+func FromInvite(invite models.Invite) Invite {
+	now := time.Now().UTC()
+	vm := Invite{
+		Id:         invite.Id,
+		Role:       invite.Role,
+		Note:       invite.Note,
+		Email:      invite.Email,
+		CreatedBy:  invite.CreatedBy,
+		CreatedOn:  invite.CreatedOn.Format(linkDateFormat),
+		ExpiresOn:  invite.ExpiresOn.Format(linkDateFormat),
+		AcceptedBy: invite.AcceptedBy,
+		Status:     invite.Status(now),
+		IsPending:  invite.IsPending(now),
+	}
+	if !invite.AcceptedOn.IsZero() {
+		vm.AcceptedOn = invite.AcceptedOn.Format(linkDateFormat)
+	}
+	return vm
+}
+
+func FromInvites(invites []models.Invite, newUrl string, message string, session Session) InviteList {
+	vm := InviteList{Roles: models.UserTypes, NewUrl: newUrl, Message: message, Session: session}
+	for _, invite := range invites {
+		vm.Invites = append(vm.Invites, FromInvite(invite))
+	}
+	return vm
+}
+
+func NewInviteAccept(token string, invite models.Invite, login string, message string, session Session) InviteAccept {
+	return InviteAccept{
+		Token:   token,
+		Role:    invite.Role,
+		Login:   login,
+		Message: message,
+		Session: session,
+	}
+}
@@ -1,8 +1,17 @@
 package viewModels
 
+import (
+	"fmt"
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
 type Login struct {
 	Message   string
 	TargetUrl string
+	LockedOut bool
+	OidcName  string // name of the external provider, if enabled
 	Session
 }
 
@@ -15,3 +24,24 @@ func NewLogin(message string, url string, session Session) Login {
 	}
 	return login
 }
+
+// Login page for a client that has to wait before trying again.
+func NewLoginLockout(wait time.Duration, locked bool, url string, session Session) Login {
+	wait = wait.Round(time.Second)
+	message := fmt.Sprintf("Too many failed attempts, please wait %s before trying again.", wait)
+	if locked {
+		message = fmt.Sprintf("Too many failed attempts, login has been locked for %s.", wait)
+	}
+	login := NewLogin(message, url, session)
+	login.LockedOut = true
+	return login
+}
+
+type LoginAttempts struct {
+	Attempts []models.LoginAttempt
+	Session
+}
+
+func NewLoginAttempts(attempts []models.LoginAttempt, session Session) LoginAttempts {
+	return LoginAttempts{Attempts: attempts, Session: session}
+}
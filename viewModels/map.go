@@ -0,0 +1,54 @@
+package viewModels
+
+import (
+	"hectorcorrea.com/hk/models"
+)
+
+// The lowercase names are the ones used by public/js/hk-map.js
+type MapPoint struct {
+	Title     string  `json:"title"`
+	Url       string  `json:"url"`
+	Thumbnail string  `json:"thumbnail"`
+	Latitude  float64 `json:"lat"`
+	Longitude float64 `json:"lng"`
+}
+
+type MapTiles struct {
+	Url         string `json:"tileUrl"`
+	Attribution string `json:"attribution"`
+}
+
+type Map struct {
+	Title  string
+	Points []MapPoint
+	Tiles  MapTiles
+	Session
+}
+
+func NewMap(title string, points []models.MapPoint, tiles models.MapTiles, session Session) Map {
+	return Map{
+		Title:   title,
+		Points:  FromMapPoints(points),
+		Tiles:   FromMapTiles(tiles),
+		Session: session,
+	}
+}
+
+func FromMapPoints(points []models.MapPoint) []MapPoint {
+	vmPoints := []MapPoint{}
+	for _, point := range points {
+		vmPoint := MapPoint{
+			Title:     point.Title,
+			Url:       models.MaskPhotoPaths(point.Url),
+			Thumbnail: models.MaskPhotoPaths(point.Thumbnail),
+			Latitude:  point.Latitude,
+			Longitude: point.Longitude,
+		}
+		vmPoints = append(vmPoints, vmPoint)
+	}
+	return vmPoints
+}
+
+func FromMapTiles(tiles models.MapTiles) MapTiles {
+	return MapTiles{Url: tiles.Url, Attribution: tiles.Attribution}
+}
@@ -0,0 +1,12 @@
+package viewModels
+
+type PasswordReset struct {
+	Token   string
+	Message string
+	Done    bool
+	Session
+}
+
+func NewPasswordReset(token string, message string, session Session) PasswordReset {
+	return PasswordReset{Token: token, Message: message, Session: session}
+}
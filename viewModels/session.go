@@ -1,21 +1,31 @@
 package viewModels
 
+import "hectorcorrea.com/hk/models"
+
 // We make everything public here because it's a view model
 // (unlike web.session in which everything is private)
 type Session struct {
-	Id        string
-	LoginName string
-	IsAuth    bool
-	IsAdmin   bool
-	IsGuest   bool
+	Id           string
+	LoginName    string
+	Role         string
+	IsAuth       bool
+	IsAdmin      bool
+	CanEdit      bool   // editors and admins can create and edit blogs
+	CsrfToken    string // to include in every form that is POSTed
+	PublicMode   bool   // anonymous users can see the public posts
+	SiteName     string
+	ContactEmail string // shown in the footer, optional
+	BaseUrl      string // for links that are shared outside the site
 }
 
-func NewSession(id, loginName string, isAdmin bool, isGuest bool) Session {
+func NewSession(id, loginName string, role string) Session {
+	isAuth := loginName != ""
 	return Session{
 		Id:        id,
 		LoginName: loginName,
-		IsAdmin:   isAdmin,
-		IsGuest:   isGuest,
-		IsAuth:    isGuest || isAdmin,
+		Role:      role,
+		IsAuth:    isAuth,
+		IsAdmin:   isAuth && role == models.RoleAdmin,
+		CanEdit:   isAuth && models.RoleCanEdit(role),
 	}
 }
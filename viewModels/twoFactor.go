@@ -0,0 +1,21 @@
+package viewModels
+
+type TwoFactor struct {
+	Login         string
+	Secret        string // for users that cannot scan the QR code
+	Enabled       bool
+	Required      bool
+	RecoveryCodes []string // only shown once, right after enrolling
+	TargetUrl     string
+	Message       string
+	Session
+}
+
+func NewTwoFactor(login string, url string, message string, session Session) TwoFactor {
+	return TwoFactor{
+		Login:     login,
+		TargetUrl: url,
+		Message:   message,
+		Session:   session,
+	}
+}
@@ -0,0 +1,101 @@
+package viewModels
+
+import (
+	"hectorcorrea.com/hk/models"
+)
+
+type User struct {
+	Id       int64
+	Login    string
+	Name     string
+	Type     string
+	Disabled bool
+	Email    string
+	IsSelf   bool // the user currently logged in
+}
+
+type UserSession struct {
+	Handle     string
+	CreatedOn  string
+	ExpiresOn  string
+	LastSeenOn string
+	UserAgent  string
+	Ip         string
+	IsCurrent  bool
+}
+
+type UserSessions struct {
+	Sessions []UserSession
+	Session
+}
+
+type UserList struct {
+	Users     []User
+	UserTypes []string
+	Message   string
+	Session
+}
+
+type UserView struct {
+	User      User
+	Sessions  []UserSession
+	UserTypes []string
+	Message   string
+	Session
+}
+
+const userDateFormat = "2006-01-02 15:04"
+
+func FromUser(user models.User, session Session) User {
+	return User{
+		Id:       user.Id,
+		Login:    user.Login,
+		Name:     user.Name,
+		Type:     user.Type,
+		Disabled: user.Disabled,
+		Email:    user.Email,
+		IsSelf:   user.Login == session.LoginName,
+	}
+}
+
+func FromUsers(users []models.User, message string, session Session) UserList {
+	vm := UserList{UserTypes: models.UserTypes, Message: message, Session: session}
+	for _, user := range users {
+		vm.Users = append(vm.Users, FromUser(user, session))
+	}
+	return vm
+}
+
+func NewUserView(user models.User, sessions []models.UserSession, message string, session Session) UserView {
+	vm := UserView{
+		User:      FromUser(user, session),
+		UserTypes: models.UserTypes,
+		Message:   message,
+		Session:   session,
+	}
+	vm.Sessions = fromUserSessions(sessions, session)
+	return vm
+}
+
+func NewUserSessions(sessions []models.UserSession, session Session) UserSessions {
+	return UserSessions{Sessions: fromUserSessions(sessions, session), Session: session}
+}
+
+func fromUserSessions(sessions []models.UserSession, session Session) []UserSession {
+	list := []UserSession{}
+	for _, s := range sessions {
+		vm := UserSession{
+			Handle:     s.Handle(),
+			ExpiresOn:  s.ExpiresOn.Format(userDateFormat),
+			LastSeenOn: s.LastSeenOn.Format(userDateFormat),
+			UserAgent:  s.UserAgent,
+			Ip:         s.Ip,
+			IsCurrent:  s.SessionId == session.Id,
+		}
+		if !s.CreatedOn.IsZero() {
+			vm.CreatedOn = s.CreatedOn.Format(userDateFormat)
+		}
+		list = append(list, vm)
+	}
+	return list
+}
@@ -0,0 +1,113 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+var albumRouter Router
+
+func init() {
+	albumRouter.Use(logRequest, csrf)
+	albumRouter.linkAccess = session.linkAllowsAlbum
+	albumRouter.Add("GET", "/albums/shared/:alias", albumViewShared, PermPublic)
+	albumRouter.Add("GET", "/albums/", albumViewAll, PermGuest)
+	albumRouter.Add("GET", "/albums/:id", albumViewOne, PermGuest)
+	albumRouter.Add("GET", "/albums/:id/edit", albumEdit, PermEditor)
+	albumRouter.Add("POST", "/albums/:id/save", albumSave, PermEditor)
+	albumRouter.Add("POST", "/albums/new", albumNew, PermEditor)
+}
+
+func albumViewAll(s session, values map[string]string) {
+	log.Printf("Loading albums...")
+	if albums, err := models.AlbumGetAll(); err != nil {
+		renderError(s, "Error fetching albums", err)
+	} else {
+		vm := viewModels.FromAlbums(albums, s.toViewModel())
+		renderTemplate(s, "views/albumList.html", vm)
+	}
+}
+
+func albumViewOne(s session, values map[string]string) {
+	id := idFromString(values["id"])
+	if id == 0 {
+		renderError(s, "No album ID was received", nil)
+		return
+	}
+
+	log.Printf("Loading album %d", id)
+	album, err := models.AlbumGetById(id)
+	if err != nil {
+		renderError(s, "Fetching album by ID", err)
+		return
+	}
+
+	vm := viewModels.FromAlbum(album, s.toViewModel(), false)
+	renderTemplate(s, "views/albumView.html", vm)
+}
+
+func albumViewShared(s session, values map[string]string) {
+	alias := values["alias"]
+	if alias == "" {
+		renderError(s, "No alias was received", nil)
+		return
+	}
+
+	log.Printf("Loading album alias %s", alias)
+	album, err := models.AlbumGetByAlias(alias)
+	if err != nil {
+		renderError(s, "Fetching album by alias", err)
+		return
+	}
+
+	vm := viewModels.FromSharedAlbum(album, s.toViewModel())
+	renderTemplate(s, "views/albumView.html", vm)
+}
+
+func albumEdit(s session, values map[string]string) {
+	id := idFromString(values["id"])
+	if id == 0 {
+		renderError(s, "No album ID was received", nil)
+		return
+	}
+
+	album, err := models.AlbumGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Loading album ID: %d", id), err)
+		return
+	}
+
+	vm := viewModels.FromAlbum(album, s.toViewModel(), true)
+	renderTemplate(s, "views/albumEdit.html", vm)
+}
+
+func albumSave(s session, values map[string]string) {
+	id := idFromString(values["id"])
+	album := models.Album{
+		Id:         id,
+		Name:       s.req.FormValue("name"),
+		Cover:      s.req.FormValue("cover"),
+		ShareAlias: s.req.FormValue("shareAlias"),
+	}
+	album.SetPhotosFromText(s.req.FormValue("photos"))
+
+	if err := album.Save(); err != nil {
+		renderError(s, fmt.Sprintf("Saving album ID: %d", id), err)
+	} else {
+		http.Redirect(s.resp, s.req, album.URL(), 303)
+	}
+}
+
+func albumNew(s session, values map[string]string) {
+	newID, err := models.AlbumSaveNew()
+	if err != nil {
+		renderError(s, "Error creating new album", err)
+		return
+	}
+	log.Printf("Redirect to (edit for new album) %d", newID)
+	http.Redirect(s.resp, s.req, fmt.Sprintf("/albums/%d/edit", newID), 303)
+}
@@ -0,0 +1,132 @@
+package web
+
+import (
+	"encoding/csv"
+	"fmt"
+	"io"
+	"log"
+	"strconv"
+	"strings"
+	"time"
+
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+var auditRouter Router
+
+func init() {
+	// The audit log is only available to admins.
+	auditRouter.Use(logRequest, noBearer)
+	auditRouter.Add("GET", "/audit", auditViewAll, PermAdmin)
+	auditRouter.Add("GET", "/audit/export", auditExport, PermAdmin)
+}
+
+// Records an audit event for the current user. Errors are logged but
+// otherwise ignored, failing to audit should not fail the request.
+func (s session) audit(action string, targetId string, oldValue string, newValue string) {
+	s.auditAs(s.loginName, action, targetId, oldValue, newValue)
+}
+
+// Same as audit() but for events in which the actor is not (yet) the
+// user logged in, e.g. login attempts.
+func (s session) auditAs(actor string, action string, targetId string, oldValue string, newValue string) {
+	event := models.AuditEvent{
+		Actor:     actor,
+		Action:    action,
+		TargetId:  targetId,
+		Ip:        remoteIp(s.req),
+		UserAgent: s.req.UserAgent(),
+		OldValue:  oldValue,
+		NewValue:  newValue,
+	}
+	if err := models.AuditAdd(event); err != nil {
+		log.Printf("ERROR recording audit event %s (%s) for %s: %s", action, targetId, actor, err)
+	}
+}
+
+const auditDateFormat = "2006-01-02"
+
+// Builds the filter from the query string. Dates are in the
+// yyyy-mm-dd format and the "to" date is inclusive.
+func auditFilterFromQuery(s session) models.AuditFilter {
+	query := s.req.URL.Query()
+	filter := models.AuditFilter{
+		Actor:    strings.TrimSpace(query.Get("actor")),
+		Action:   strings.TrimSpace(query.Get("action")),
+		TargetId: strings.TrimSpace(query.Get("target")),
+	}
+	if from, err := time.Parse(auditDateFormat, query.Get("from")); err == nil {
+		filter.From = from
+	}
+	if to, err := time.Parse(auditDateFormat, query.Get("to")); err == nil {
+		filter.To = to.AddDate(0, 0, 1)
+	}
+	filter.Limit, _ = strconv.Atoi(query.Get("limit"))
+	return filter
+}
+
+func auditViewAll(s session, values map[string]string) {
+	filter := auditFilterFromQuery(s)
+	if filter.Limit == 0 {
+		filter.Limit = 200
+	}
+	events, err := models.AuditGetAll(filter)
+	if err != nil {
+		renderError(s, "Error fetching audit events", err)
+		return
+	}
+	vm := viewModels.NewAuditList(events, s.req.URL.Query(), s.toViewModel())
+	renderTemplate(s, "views/audit.html", vm)
+}
+
+func auditExport(s session, values map[string]string) {
+	filter := auditFilterFromQuery(s)
+	events, err := models.AuditGetAll(filter)
+	if err != nil {
+		renderError(s, "Error fetching audit events", err)
+		return
+	}
+
+	fileName := fmt.Sprintf("audit-%s.csv", time.Now().UTC().Format("20060102-150405"))
+	s.resp.Header().Set("Content-Type", "text/csv; charset=utf-8")
+	s.resp.Header().Set("Content-Disposition", "attachment; filename="+fileName)
+	if err := writeAuditCsv(s.resp, events); err != nil {
+		log.Printf("Error exporting audit events: %s", err)
+	}
+}
+
+func writeAuditCsv(w io.Writer, events []models.AuditEvent) error {
+	writer := csv.NewWriter(w)
+	header := []string{"date_utc", "actor", "action", "target_id", "ip", "user_agent", "old_value", "new_value"}
+	if err := writer.Write(header); err != nil {
+		return err
+	}
+	for _, event := range events {
+		record := []string{
+			event.CreatedOn.UTC().Format(time.RFC3339),
+			csvCell(event.Actor),
+			csvCell(event.Action),
+			csvCell(event.TargetId),
+			csvCell(event.Ip),
+			csvCell(event.UserAgent),
+			csvCell(event.OldValue),
+			csvCell(event.NewValue),
+		}
+		if err := writer.Write(record); err != nil {
+			return err
+		}
+	}
+	writer.Flush()
+	return writer.Error()
+}
+
+// Some values come from the client (e.g. the login of a failed login or
+// the User-Agent) and spreadsheets run the ones that look like formulas,
+// a leading quote makes them plain text.
+func csvCell(value string) string {
+	if value != "" && strings.ContainsAny(value[0:1], "=+-@\t\r") {
+		return "'" + value
+	}
+	return value
+}
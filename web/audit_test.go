@@ -0,0 +1,64 @@
+package web
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
+func TestWriteAuditCsv(t *testing.T) {
+	events := []models.AuditEvent{
+		{
+			CreatedOn: time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC),
+			Actor:     "user1",
+			Action:    models.AuditBlogSave,
+			TargetId:  "12",
+			Ip:        "127.0.0.1",
+			UserAgent: "test",
+			OldValue:  `title="one, two"`,
+			NewValue:  `title="three"`,
+		},
+	}
+
+	var buffer bytes.Buffer
+	if err := writeAuditCsv(&buffer, events); err != nil {
+		t.Fatalf("Error writing CSV: %s", err)
+	}
+
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	if len(lines) != 2 {
+		t.Fatalf("Unexpected number of lines: %d", len(lines))
+	}
+	expected := `2020-05-01T10:30:00Z,user1,blog.save,12,127.0.0.1,test,"title=""one, two""","title=""three"""`
+	if lines[1] != expected {
+		t.Errorf("Unexpected CSV line: %s", lines[1])
+	}
+}
+
+func TestWriteAuditCsvFormulas(t *testing.T) {
+	events := []models.AuditEvent{
+		{
+			CreatedOn: time.Date(2020, 5, 1, 10, 30, 0, 0, time.UTC),
+			Actor:     "=HYPERLINK(\"http://evil.com\")",
+			Action:    models.AuditLoginFailed,
+			TargetId:  "-1",
+			Ip:        "127.0.0.1",
+			UserAgent: "@SUM(1)",
+			OldValue:  "\tx",
+			NewValue:  "a=b",
+		},
+	}
+
+	var buffer bytes.Buffer
+	if err := writeAuditCsv(&buffer, events); err != nil {
+		t.Fatalf("Error writing CSV: %s", err)
+	}
+	lines := strings.Split(strings.TrimSpace(buffer.String()), "\n")
+	expected := "2020-05-01T10:30:00Z,\"'=HYPERLINK(\"\"http://evil.com\"\")\",login.failed,'-1,127.0.0.1,'@SUM(1),'\tx,a=b"
+	if lines[1] != expected {
+		t.Errorf("Unexpected CSV line: %s", lines[1])
+	}
+}
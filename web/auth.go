@@ -3,8 +3,10 @@ package web
 import (
 	"fmt"
 	"log"
+	"math"
 	"math/rand"
 	"net/http"
+	neturl "net/url"
 	"strings"
 	"time"
 
@@ -16,68 +18,114 @@ var authRouter Router
 
 func init() {
 	// This should be initialized only once, not on every call.
-	authRouter.Add("GET", "/auth/login", handleLogin)
-	authRouter.Add("POST", "/auth/login", handleLoginPost)
-	authRouter.Add("GET", "/auth/logout", handleLogout)
-	authRouter.Add("GET", "/auth/changepassword", handleChangePass)
-	authRouter.Add("POST", "/auth/changepassword", handleChangePassPost)
-}
-
-func authPages(resp http.ResponseWriter, req *http.Request) {
-	session := newSession(resp, req)
-	found, route := authRouter.FindRoute(req.Method, req.URL.Path)
-	if found {
-		route.handler(session, nil)
-	} else {
-		renderNotFound(session)
-	}
+	authRouter.Use(logRequest, noBearer, csrf)
+	authRouter.Add("GET", "/auth/login", handleLogin, PermPublic)
+	authRouter.Add("POST", "/auth/login", handleLoginPost, PermPublic)
+	authRouter.Add("GET", "/auth/logout", handleLogout, PermPublic)
+	authRouter.Add("GET", "/auth/changepassword", handleChangePass, PermGuest)
+	authRouter.Add("POST", "/auth/changepassword", handleChangePassPost, PermGuest)
+	authRouter.Add("GET", "/auth/attempts", handleLoginAttempts, PermAdmin)
+	authRouter.Add("GET", "/auth/sessions", handleSessions, PermGuest)
+	authRouter.Add("POST", "/auth/sessions/revoke", handleSessionRevoke, PermGuest)
+	authRouter.Add("POST", "/auth/sessions/revokeall", handleSessionRevokeAll, PermGuest)
+	authRouter.Add("GET", "/auth/2fa", handleTwoFactor, PermPublic)
+	authRouter.Add("POST", "/auth/2fa", handleTwoFactorPost, PermPublic)
+	authRouter.Add("GET", "/auth/2fa/setup", handleTwoFactorSetup, PermPublic)
+	authRouter.Add("POST", "/auth/2fa/setup", handleTwoFactorSetupPost, PermPublic)
+	authRouter.Add("GET", "/auth/2fa/qr", handleTwoFactorQr, PermPublic)
+	authRouter.Add("POST", "/auth/2fa/disable", handleTwoFactorDisable, PermGuest)
 }
 
 func handleLogin(s session, values map[string]string) {
 	url := s.req.URL.Query().Get("url")
 	vmSession := s.toViewModel()
 	vm := viewModels.NewLogin("", url, vmSession)
-	renderTemplate(s, "views/login.html", vm)
+	renderLogin(s, vm)
 }
 
 func handleLoginPost(s session, values map[string]string) {
 	login := strings.TrimSpace(s.req.FormValue("user"))
 	password := strings.TrimSpace(s.req.FormValue("password"))
 	url := s.req.FormValue("url")
-	err := s.login(login, password)
+	ip := remoteIp(s.req)
+
+	wait, locked := throttle.Attempt(login, ip, time.Now())
+	if wait > 0 {
+		log.Printf("Login THROTTLED for user: %s (IP: %s, locked: %t, wait: %s)", login, ip, locked, wait)
+		s.auditAs(login, models.AuditLoginThrottled, login, "", "")
+		vm := viewModels.NewLoginLockout(wait, locked, url, s.toViewModel())
+		s.resp.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(wait.Seconds())))
+		s.resp.WriteHeader(http.StatusTooManyRequests)
+		renderLogin(s, vm)
+		return
+	}
+
+	err := s.checkPassword(login, password)
 	if err != nil {
-		log.Printf("Login FAILED for user: %s", login)
+		log.Printf("Login FAILED for user: %s (IP: %s)", login, ip)
+		s.auditAs(login, models.AuditLoginFailed, login, "", "")
 		vmSession := s.toViewModel()
 		vm := viewModels.NewLogin("Sorry, not sorry", url, vmSession)
-		renderTemplate(s, "views/login.html", vm)
-	} else {
-		if url == "" {
-			url = "/"
-		}
-		log.Printf("Login OK for user: %s (URL: %s)", login, url)
-		http.Redirect(s.resp, s.req, url, 302)
+		renderLogin(s, vm)
+		return
 	}
+
+	startLogin(s, login, url)
+}
+
+// Starts the session for a user whose password has been validated, or
+// sends them to the two-factor step first if they need it.
+func startLogin(s session, login string, url string) {
+	step, err := twoFactorStep(login)
+	if err != nil {
+		renderError(s, "Error checking two-factor authentication", err)
+		return
+	}
+	if step != "" {
+		// Password was OK but we need a code too.
+		log.Printf("Login password OK for user: %s, two-factor step: %s", login, step)
+		setPendingTwoFactor(s, login)
+		http.Redirect(s.resp, s.req, step+"?url="+neturl.QueryEscape(url), 302)
+		return
+	}
+
+	completeLogin(s, login, url)
+}
+
+// The failed attempts are only cleared once the user is logged in (i.e.
+// after the second factor if they need one) so that knowing the password
+// does not reset the throttling of the two-factor codes.
+func completeLogin(s session, login string, url string) {
+	if err := s.startSession(login); err != nil {
+		renderError(s, "Error starting session", err)
+		return
+	}
+	throttle.Succeeded(login)
+	if url == "" {
+		url = "/"
+	}
+	log.Printf("Login OK for user: %s (URL: %s)", login, url)
+	s.audit(models.AuditLoginOk, login, "", "")
+	http.Redirect(s.resp, s.req, url, 302)
 }
 
 func handleLogout(s session, values map[string]string) {
+	if s.isAuth() {
+		s.audit(models.AuditLogout, s.loginName, "", "")
+	}
 	s.logout()
 	homeUrl := fmt.Sprintf("/?cb?=%s", cacheBuster())
 	http.Redirect(s.resp, s.req, homeUrl, 302)
 }
 
 func handleChangePass(s session, values map[string]string) {
-	if !s.isAuth() {
-		renderNotAuthorized(s)
-		return
-	}
-
 	vmSession := s.toViewModel()
 	vm := viewModels.NewChangePassword("", vmSession)
 	renderTemplate(s, "views/changePassword.html", vm)
 }
 
 func handleChangePassPost(s session, values map[string]string) {
-	if !s.isAuth() || (s.loginName != s.req.FormValue("user")) {
+	if s.loginName != s.req.FormValue("user") {
 		renderNotAuthorized(s)
 		return
 	}
@@ -88,7 +136,7 @@ func handleChangePassPost(s session, values map[string]string) {
 	repeatPassword := s.req.FormValue("repeatPassword")
 	message := ""
 
-	err := s.login(login, password)
+	err := s.checkPassword(login, password)
 	if err != nil {
 		message += "Invalid password."
 	}
@@ -109,11 +157,70 @@ func handleChangePassPost(s session, values map[string]string) {
 		if err != nil {
 			renderError(s, "Could not change passowrd", err)
 		} else {
+			s.audit(models.AuditPasswordChange, login, "", "")
 			http.Redirect(s.resp, s.req, "/", 302)
 		}
 	}
 }
 
+func handleLoginAttempts(s session, values map[string]string) {
+	attempts, err := throttle.store.Recent(100)
+	if err != nil {
+		renderError(s, "Error fetching login attempts", err)
+		return
+	}
+	vm := viewModels.NewLoginAttempts(attempts, s.toViewModel())
+	renderTemplate(s, "views/loginAttempts.html", vm)
+}
+
+func handleSessions(s session, values map[string]string) {
+	userId, err := models.GetUserId(s.loginName)
+	if err != nil {
+		renderError(s, "Error fetching user", err)
+		return
+	}
+
+	sessions, err := models.UserSessionsGetByUser(userId)
+	if err != nil {
+		renderError(s, "Error fetching sessions", err)
+		return
+	}
+	vm := viewModels.NewUserSessions(sessions, s.toViewModel())
+	renderTemplate(s, "views/sessions.html", vm)
+}
+
+func handleSessionRevoke(s session, values map[string]string) {
+	userId, err := models.GetUserId(s.loginName)
+	if err != nil {
+		renderError(s, "Error fetching user", err)
+		return
+	}
+
+	err = models.DeleteUserSessionByHandle(userId, s.req.FormValue("session"))
+	if err != nil {
+		renderError(s, "Error revoking session", err)
+		return
+	}
+	http.Redirect(s.resp, s.req, "/auth/sessions", 303)
+}
+
+// Signs out the user everywhere, including the current session.
+func handleSessionRevokeAll(s session, values map[string]string) {
+	userId, err := models.GetUserId(s.loginName)
+	if err != nil {
+		renderError(s, "Error fetching user", err)
+		return
+	}
+
+	if err := models.DeleteUserSessions(userId); err != nil {
+		renderError(s, "Error revoking sessions", err)
+		return
+	}
+	log.Printf("User %s signed out everywhere", s.loginName)
+	s.logout()
+	http.Redirect(s.resp, s.req, "/auth/login", 303)
+}
+
 func cacheBuster() string {
 	seed := time.Now().UnixNano()
 	r := rand.New(rand.NewSource(seed))
@@ -14,37 +14,34 @@ import (
 var blogRouter Router
 
 func init() {
-	blogRouter.Add("GET", "/shared/:alias", blogViewOneShared)
-	blogRouter.Add("GET", "/blogs/:title_id", blogViewOneLegacy)
-	blogRouter.Add("GET", "/:year/:title/:id", blogViewOne)
-	blogRouter.Add("GET", "/archive/:year", blogViewYear)
-	blogRouter.Add("GET", "/archive", blogViewAll)
-	blogRouter.Add("GET", "/about", aboutPage)
-	blogRouter.Add("GET", "/", blogViewRecent)
-	blogRouter.Add("GET", "/:year/:title/:id/edit", blogEditNewEditor)
-	blogRouter.Add("GET", "/:year/:title/:id/editOld", blogEditOldEditor)
-	blogRouter.Add("POST", "/:year/:title/:id/save", blogSave)
-	blogRouter.Add("POST", "/new", blogNew)
+	// Individual blogs are checked against their visibility in the
+	// handlers, and the listings only include the blogs that the user
+	// can see. Outside of public mode anonymous users can only get to
+	// a blog by its share alias or with an access link.
+	blogRouter.Use(logRequest, csrf)
+	blogRouter.linkAccess = session.linkAllowsBlog
+	blogRouter.Add("GET", "/shared/:alias", blogViewOneShared, PermPublic)
+	blogRouter.Add("GET", "/blogs/:title_id", blogViewOneLegacy, PermPublicMode)
+	blogRouter.Add("GET", "/:year/:title/:id", blogViewOne, PermPublicMode)
+	blogRouter.Add("GET", "/archive/:year", blogViewYear, PermPublicMode)
+	blogRouter.Add("GET", "/archive", blogViewAll, PermPublicMode)
+	blogRouter.Add("GET", "/about", aboutPage, PermPublicMode)
+	blogRouter.Add("GET", "/map", blogMap, PermGuest)
+	blogRouter.Add("GET", "/", blogViewRecent, PermPublicMode)
+	blogRouter.Add("GET", "/:year/:title/:id/edit", blogEditNewEditor, PermEditor)
+	blogRouter.Add("GET", "/:year/:title/:id/editOld", blogEditOldEditor, PermEditor)
+	blogRouter.Add("POST", "/:year/:title/:id/save", blogSave, PermEditor)
+	blogRouter.Add("POST", "/:year/:title/:id/delete", blogDelete, PermEditor)
+	blogRouter.Add("POST", "/new", blogNew, PermEditor)
 }
 
-func blogPages(resp http.ResponseWriter, req *http.Request) {
-	// blogRouter.PrintRoutes()
-	session := newSession(resp, req)
-	found, route := blogRouter.FindRoute(req.Method, req.URL.Path)
-	if found {
-		if route.path == "/shared/:alias" {
-			// anonymous users are OK
-		} else {
-			if !session.isAuth() {
-				renderNotAuthorized(session)
-				return
-			}
-		}
-		values := route.UrlValues(req.URL.Path)
-		route.handler(session, values)
-	} else {
-		renderNotFound(session)
+// Returns true if the user can see the blog given their role, or
+// because they have an access link to it.
+func (s session) canViewBlog(blog models.Blog) bool {
+	if blog.IsVisibleTo(s.role()) {
+		return true
 	}
+	return s.hasLink() && s.link.Scope == models.AccessLinkPost && s.link.TargetId == blog.Id
 }
 
 func blogViewOne(s session, values map[string]string) {
@@ -65,6 +62,12 @@ func blogViewOne(s session, values map[string]string) {
 		return
 	}
 
+	if !s.canViewBlog(blog) {
+		log.Printf("Blog %d (%s) not visible to %s", id, blog.Visibility, s.role())
+		renderNotAuthorized(s)
+		return
+	}
+
 	year := values["year"]
 	slug := values["title"]
 	if (year != strconv.Itoa(blog.Year)) || (slug != blog.Slug) {
@@ -76,6 +79,12 @@ func blogViewOne(s session, values map[string]string) {
 
 	log.Print("blogViewOne")
 	vm := viewModels.FromBlog(blog, s.toViewModel(), false)
+	points, err := models.MapBlogPhotos(blog.Id)
+	if err != nil {
+		log.Printf("Error fetching map for blog %d: %s", blog.Id, err)
+	}
+	vm.MapPoints = viewModels.FromMapPoints(points)
+	vm.MapTiles = viewModels.FromMapTiles(models.MapTileSettings())
 	renderTemplate(s, "views/blogView.html", vm)
 }
 
@@ -97,7 +106,7 @@ func blogViewOneShared(s session, values map[string]string) {
 	}
 
 	log.Printf("blogViewOneShared %s", alias)
-	vm := viewModels.FromBlog(blog, s.toViewModel(), false)
+	vm := viewModels.FromSharedBlog(blog, s.toViewModel())
 	renderTemplate(s, "views/blogView.html", vm)
 }
 
@@ -112,7 +121,7 @@ func blogViewOneLegacy(s session, values map[string]string) {
 	}
 
 	blog, err := models.BlogGetById(id)
-	if err != nil {
+	if err != nil || !s.canViewBlog(blog) {
 		log.Printf("Legacy post %d not found. Redirected to home page.", id)
 		http.Redirect(s.resp, s.req, "/", http.StatusMovedPermanently)
 		return
@@ -126,7 +135,7 @@ func blogViewOneLegacy(s session, values map[string]string) {
 func blogViewRecent(s session, values map[string]string) {
 	// showDrafts := s.isAuth()
 	log.Printf("Loading recent...")
-	if blogs, err := models.BlogGetRecent(); err != nil {
+	if blogs, err := models.BlogGetRecent(s.role()); err != nil {
 		renderError(s, "Error fetching recent", err)
 	} else {
 		vm := viewModels.FromBlogs(blogs, s.toViewModel(), true)
@@ -139,6 +148,16 @@ func aboutPage(s session, values map[string]string) {
 	renderTemplate(s, "views/about.html", nil)
 }
 
+func blogMap(s session, values map[string]string) {
+	log.Printf("Loading map...")
+	if points, err := models.MapBlogs(s.role()); err != nil {
+		renderError(s, "Error fetching map", err)
+	} else {
+		vm := viewModels.NewMap("Map", points, models.MapTileSettings(), s.toViewModel())
+		renderTemplate(s, "views/map.html", vm)
+	}
+}
+
 func blogViewYear(s session, values map[string]string) {
 	year, err := strconv.Atoi(values["year"])
 	log.Printf("Loading all for year %d...", year)
@@ -147,7 +166,7 @@ func blogViewYear(s session, values map[string]string) {
 		return
 	}
 
-	if blogs, err := models.BlogGetYear(year); err != nil {
+	if blogs, err := models.BlogGetYear(year, s.role()); err != nil {
 		renderError(s, "Error fetching all for year", err)
 	} else {
 		vm := viewModels.FromBlogs(blogs, s.toViewModel(), false)
@@ -158,7 +177,7 @@ func blogViewYear(s session, values map[string]string) {
 
 func blogViewAll(s session, values map[string]string) {
 	log.Printf("Loading all...")
-	if blogs, err := models.BlogGetAll(); err != nil {
+	if blogs, err := models.BlogGetAll(s.role()); err != nil {
 		renderError(s, "Error fetching all", err)
 	} else {
 		vm := viewModels.FromBlogs(blogs, s.toViewModel(), false)
@@ -168,42 +187,70 @@ func blogViewAll(s session, values map[string]string) {
 }
 
 func blogSave(s session, values map[string]string) {
-	if !s.isAuth() {
-		renderNotAuthorized(s)
+	id := idFromString(values["id"])
+	oldBlog, err := models.BlogGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Loading blog ID: %d", id), err)
 		return
 	}
 
-	id := idFromString(values["id"])
-	blog := blogFromForm(id, s)
+	blog := blogFromForm(oldBlog, s)
 	if err := blog.Save(); err != nil {
 		renderError(s, fmt.Sprintf("Saving blog ID: %d", id), err)
 	} else {
+		s.auditBlogSave(oldBlog, blog)
 		url := fmt.Sprintf("/%d/%s/%d", blog.Year, blog.Slug, id)
 		log.Printf("Redirect to %s", url)
 		http.Redirect(s.resp, s.req, url, 301)
 	}
 }
 
-func blogNew(s session, values map[string]string) {
-	if !s.isAuth() {
-		renderNotAuthorized(s)
+func blogDelete(s session, values map[string]string) {
+	id := idFromString(values["id"])
+	blog, err := models.BlogGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Loading blog ID: %d", id), err)
+		return
+	}
+
+	if err := models.BlogDelete(id); err != nil {
+		renderError(s, fmt.Sprintf("Deleting blog ID: %d", id), err)
 		return
 	}
+	s.audit(models.AuditBlogDelete, fmt.Sprintf("%d", id), blogAuditSummary(blog), "")
+	log.Printf("Deleted blog %d", id)
+	http.Redirect(s.resp, s.req, "/", http.StatusSeeOther)
+}
+
+// Records the save, plus whether the blog was published or unpublished.
+func (s session) auditBlogSave(oldBlog models.Blog, blog models.Blog) {
+	targetId := fmt.Sprintf("%d", blog.Id)
+	s.audit(models.AuditBlogSave, targetId, blogAuditSummary(oldBlog), blogAuditSummary(blog))
+	if !oldBlog.Published && blog.Published {
+		s.audit(models.AuditBlogPublish, targetId, "", "")
+	} else if oldBlog.Published && !blog.Published {
+		s.audit(models.AuditBlogUnpublish, targetId, "", "")
+	}
+}
+
+// Short description of a blog to record in the audit log.
+func blogAuditSummary(blog models.Blog) string {
+	return fmt.Sprintf("title=%q visibility=%s published=%t", blog.Title, blog.Visibility, blog.Published)
+}
+
+func blogNew(s session, values map[string]string) {
 	newID, err := models.SaveNew()
 	if err != nil {
 		renderError(s, fmt.Sprintf("Error creating new blog"), err)
 		return
 	}
 	log.Printf("Redirect to (edit for new) %d", newID)
+	s.audit(models.AuditBlogCreate, fmt.Sprintf("%d", newID), "", "")
 	values["id"] = fmt.Sprintf("%d", newID)
 	blogEditNewEditor(s, values)
 }
 
 func blogEditOldEditor(s session, values map[string]string) {
-	if !s.isAuth() {
-		renderNotAuthorized(s)
-		return
-	}
 	id := idFromString(values["id"])
 	if id == 0 {
 		renderError(s, "No blog ID was received", nil)
@@ -222,10 +269,6 @@ func blogEditOldEditor(s session, values map[string]string) {
 }
 
 func blogEditNewEditor(s session, values map[string]string) {
-	if !s.isAuth() {
-		renderNotAuthorized(s)
-		return
-	}
 	id := idFromString(values["id"])
 	if id == 0 {
 		renderError(s, "No blog ID was received", nil)
@@ -260,8 +303,11 @@ func idFromLegacyUrl(url string) int64 {
 	return idFromString(idString)
 }
 
-func blogFromForm(id int64, s session) models.Blog {
+// The values that are not in the form (e.g. the published flag for
+// API clients) are kept from oldBlog.
+func blogFromForm(oldBlog models.Blog, s session) models.Blog {
 	var blog models.Blog
+	id := oldBlog.Id
 	blog.Id = id
 
 	err := s.req.ParseForm()
@@ -275,6 +321,18 @@ func blogFromForm(id int64, s session) models.Blog {
 	blog.Thumbnail = s.req.FormValue("thumbnail")
 	blog.BlogDate = s.req.FormValue("blogdate")
 	blog.ShareAlias = s.req.FormValue("shareAlias")
+	blog.Visibility = s.req.FormValue("visibility")
+	blog.Published = oldBlog.Published
+	if values, found := s.req.Form["published"]; found {
+		// The forms send an empty value along with the checkbox so
+		// that an unchecked box still tells us to unpublish.
+		blog.Published = false
+		for _, value := range values {
+			if value != "" {
+				blog.Published = true
+			}
+		}
+	}
 
 	for k, v := range s.req.Form {
 		if strings.HasPrefix(k, "section_id_") {
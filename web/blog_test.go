@@ -0,0 +1,121 @@
+package web
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"hectorcorrea.com/hk/models"
+)
+
+func TestBlogRouteAllowed(t *testing.T) {
+	anonymous := session{}
+	family := session{loginName: "user2", userType: "family"}
+	editor := session{loginName: "user3", userType: "editor"}
+	siteLink := session{link: models.AccessLink{Id: "1", Scope: models.AccessLinkSite}}
+	postLink := session{link: models.AccessLink{Id: "2", Scope: models.AccessLinkPost, TargetId: 123}}
+
+	tests := []struct {
+		s       session
+		method  string
+		url     string
+		allowed bool
+	}{
+		{anonymous, "GET", "/2019/some-post/123", false},
+		{anonymous, "GET", "/blogs/some-post-123", false},
+		{anonymous, "GET", "/shared/abc", true},
+		{anonymous, "GET", "/archive", false},
+		{anonymous, "POST", "/new", false},
+		{family, "GET", "/archive", true},
+		{family, "GET", "/2019/some-post/123/edit", false},
+		{family, "POST", "/2019/some-post/123/save", false},
+		{family, "POST", "/2019/some-post/123/delete", false},
+		{editor, "GET", "/2019/some-post/123/edit", true},
+		{editor, "POST", "/2019/some-post/123/save", true},
+		{editor, "POST", "/2019/some-post/123/delete", true},
+		{siteLink, "GET", "/archive", true},
+		{siteLink, "GET", "/map", true},
+		{siteLink, "GET", "/2019/some-post/123/edit", false},
+		{postLink, "GET", "/2019/some-post/123", true},
+		{postLink, "GET", "/2019/other-post/456", false},
+		{postLink, "GET", "/archive", false},
+	}
+
+	for _, test := range tests {
+		found, allowed := routeAllowed(&blogRouter, test.s, test.method, test.url)
+		if !found {
+			t.Errorf("Route not found: %s %s", test.method, test.url)
+			continue
+		}
+		if allowed != test.allowed {
+			t.Errorf("Unexpected result for %s on %s %s", test.s.userType, test.method, test.url)
+		}
+	}
+}
+
+func TestBlogRouteAllowedPublicMode(t *testing.T) {
+	publicMode = true
+	defer func() { publicMode = false }()
+
+	anonymous := session{}
+	tests := []struct {
+		method  string
+		url     string
+		allowed bool
+	}{
+		{"GET", "/", true},
+		{"GET", "/archive", true},
+		{"GET", "/archive/2019", true},
+		{"GET", "/rss", true},
+		{"GET", "/sitemap.xml", true},
+		{"GET", "/2019/some-post/123", true},
+		{"GET", "/2019/some-post/123/edit", false},
+		{"POST", "/new", false},
+	}
+
+	for _, test := range tests {
+		found, allowed := routeAllowed(&blogRouter, anonymous, test.method, test.url)
+		if !found {
+			t.Errorf("Route not found: %s %s", test.method, test.url)
+			continue
+		}
+		if allowed != test.allowed {
+			t.Errorf("Unexpected result for %s %s in public mode", test.method, test.url)
+		}
+	}
+}
+
+func TestRobotsText(t *testing.T) {
+	private := robotsText(false, "http://localhost")
+	if !strings.Contains(private, "Disallow: /\n") {
+		t.Errorf("Site should not be indexed outside public mode: %s", private)
+	}
+
+	public := robotsText(true, "http://localhost")
+	if strings.Contains(public, "Disallow: /\n") {
+		t.Errorf("Site should be indexed in public mode: %s", public)
+	}
+	if !strings.Contains(public, "Sitemap: http://localhost/sitemap.xml") {
+		t.Errorf("Sitemap not found in robots.txt: %s", public)
+	}
+}
+
+func TestBlogFromFormPublished(t *testing.T) {
+	published := models.Blog{Id: 12, Published: true}
+	tests := []struct {
+		form      string
+		published bool
+	}{
+		{"title=a", true},             // field not sent, e.g. by an API client
+		{"title=a&published=", false}, // unchecked box
+		{"title=a&published=&published=on", true},
+	}
+	for _, test := range tests {
+		req := httptest.NewRequest("POST", "/2019/a/12/save", strings.NewReader(test.form))
+		req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		blog := blogFromForm(published, session{req: req})
+		if blog.Id != 12 || blog.Published != test.published {
+			t.Errorf("Unexpected published (%t) for %s", blog.Published, test.form)
+		}
+	}
+}
@@ -0,0 +1,95 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"log"
+	"net/http"
+)
+
+// CSRF tokens are an HMAC of the session ID (or of a random ID stored
+// in the csrfId cookie for anonymous users) so we don't need to store
+// them anywhere. If CSRF_SECRET is not set a random secret is used,
+// which means that forms rendered before a restart will be rejected.
+var csrfSecret []byte
+
+const csrfFieldName = "csrf"
+const csrfHeaderName = "X-CSRF-Token"
+
+func initCsrf(secret string) {
+	if secret != "" {
+		csrfSecret = []byte(secret)
+		return
+	}
+
+	log.Printf("CSRF secret not set, using a random one")
+	csrfSecret = make([]byte, 32)
+	if _, err := rand.Read(csrfSecret); err != nil {
+		log.Fatal("Failed to create CSRF secret: ", err)
+	}
+}
+
+// Returns the value the CSRF token is calculated from, creating
+// the csrfId cookie for anonymous users if needed.
+func csrfKey(resp http.ResponseWriter, req *http.Request, sessionId string) string {
+	if sessionId != "" {
+		return sessionId
+	}
+
+	cookie, err := req.Cookie("csrfId")
+	if err == nil && cookie.Value != "" {
+		return cookie.Value
+	}
+
+	value, err := randomId()
+	if err != nil {
+		log.Printf("Error creating CSRF ID: %s", err)
+		return ""
+	}
+	cookie = &http.Cookie{Name: "csrfId", Value: value, Path: "/"}
+	setCookie(resp, req, cookie)
+	return value
+}
+
+func csrfToken(key string) string {
+	if key == "" {
+		return ""
+	}
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte(key))
+	return base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Validates the token received in a form (or header) for POST requests.
+// Renders an error and returns false if the token is not valid.
+func checkCsrf(s session) bool {
+	// Requests with an API token do not use cookies so they are not
+	// subject to CSRF.
+	if s.req.Method != "POST" || s.bearer {
+		return true
+	}
+
+	token := s.req.FormValue(csrfFieldName)
+	if token == "" {
+		token = s.req.Header.Get(csrfHeaderName)
+	}
+
+	expected := s.csrfToken()
+	if expected != "" && hmac.Equal([]byte(token), []byte(expected)) {
+		return true
+	}
+
+	log.Printf("Invalid CSRF token: %s %s (%s)", s.req.Method, s.req.URL.Path, s.loginName)
+	renderForbidden(s, "Invalid or expired form, please reload the page and try again.")
+	return false
+}
+
+func randomId() (string, error) {
+	rb := make([]byte, 32)
+	if _, err := rand.Read(rb); err != nil {
+		return "", err
+	}
+	return base64.RawURLEncoding.EncodeToString(rb), nil
+}
@@ -0,0 +1,40 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+)
+
+func TestCsrf(t *testing.T) {
+	csrfSecret = []byte("secret")
+	s1 := session{sessionId: "session1", csrfKey: "session1"}
+	s2 := session{sessionId: "session2", csrfKey: "session2"}
+	if s1.csrfToken() == "" || s1.csrfToken() == s2.csrfToken() {
+		t.Errorf("Tokens should be different for different sessions")
+	}
+
+	form := url.Values{}
+	form.Set("csrf", s1.csrfToken())
+	req := httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	s1.req = req
+	s1.resp = httptest.NewRecorder()
+	if !checkCsrf(s1) {
+		t.Errorf("Valid token rejected")
+	}
+
+	req = httptest.NewRequest("POST", "/new", strings.NewReader(form.Encode()))
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp := httptest.NewRecorder()
+	s2.req = req
+	s2.resp = resp
+	if checkCsrf(s2) {
+		t.Errorf("Token from another session accepted")
+	}
+	if resp.Code != http.StatusForbidden {
+		t.Errorf("Unexpected status code for invalid token: %d", resp.Code)
+	}
+}
@@ -0,0 +1,93 @@
+package web
+
+import (
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
+const feedSize = 20
+
+func init() {
+	blogRouter.Add("GET", "/rss", blogFeed, PermPublicMode)
+	blogRouter.Add("GET", "/sitemap.xml", blogSitemap, PermPublicMode)
+}
+
+// The feed and the sitemap only include the blogs that anonymous
+// users can see (i.e. public and published) regardless of who is
+// asking for them.
+func blogFeed(s session, values map[string]string) {
+	blogs, err := models.BlogGetAll(models.RolePublic)
+	if err != nil {
+		renderError(s, "Error fetching blogs for the feed", err)
+		return
+	}
+
+	base := baseUrl(s.req)
+	rss := models.NewRss(site.Name, site.Name, base+"/rss")
+	rss.Channel.Link = base
+	rss.Channel.LastBuildDate = time.Now().UTC().Format(time.RFC1123Z)
+	for i, blog := range blogs {
+		if i == feedSize {
+			break
+		}
+		rss.Add(blog.Title, blog.Summary, blog.URL(base), blog.PostedOnRFC1123Z())
+	}
+
+	xml, err := rss.ToXml()
+	if err != nil {
+		renderError(s, "Error creating the feed", err)
+		return
+	}
+	cacheResponse(s.resp)
+	s.resp.Header().Set("Content-Type", "application/rss+xml; charset=utf-8")
+	fmt.Fprint(s.resp, xml)
+}
+
+func blogSitemap(s session, values map[string]string) {
+	blogs, err := models.BlogGetAll(models.RolePublic)
+	if err != nil {
+		renderError(s, "Error fetching blogs for the sitemap", err)
+		return
+	}
+
+	base := baseUrl(s.req)
+	sitemap := models.NewSitemap()
+	sitemap.Add(base+"/", "")
+	sitemap.Add(base+"/archive", "")
+	for _, blog := range blogs {
+		sitemap.Add(blog.URL(base), "")
+	}
+
+	xml, err := sitemap.ToXml()
+	if err != nil {
+		renderError(s, "Error creating the sitemap", err)
+		return
+	}
+	cacheResponse(s.resp)
+	s.resp.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	fmt.Fprint(s.resp, xml)
+}
+
+// Search engines are only allowed when the site is in public mode,
+// and even then only to the pages that anonymous users can see.
+func robotsTxt(resp http.ResponseWriter, req *http.Request) {
+	resp.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprint(resp, robotsText(publicMode, baseUrl(req)))
+}
+
+func robotsText(public bool, base string) string {
+	lines := []string{"User-agent: *"}
+	if !public {
+		lines = append(lines, "Disallow: /")
+	} else {
+		for _, path := range []string{"/auth/", "/users", "/links", "/invites", "/audit", "/shared/", "/albums/", "/map"} {
+			lines = append(lines, "Disallow: "+path)
+		}
+		lines = append(lines, "Sitemap: "+base+"/sitemap.xml")
+	}
+	return strings.Join(lines, "\n") + "\n"
+}
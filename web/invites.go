@@ -0,0 +1,115 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	neturl "net/url"
+	"strconv"
+	"strings"
+
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+var inviteRouter Router
+
+func init() {
+	// Invites are managed by admins only.
+	inviteRouter.Use(logRequest, noBearer, csrf)
+	inviteRouter.Add("GET", "/invites", inviteViewAll, PermAdmin)
+	inviteRouter.Add("POST", "/invites/new", inviteNew, PermAdmin)
+	inviteRouter.Add("POST", "/invites/:id/revoke", inviteRevoke, PermAdmin)
+
+	// Accepting an invite is done by anonymous users.
+	authRouter.Add("GET", "/auth/invite", handleInvite, PermPublic)
+	authRouter.Add("POST", "/auth/invite", handleInvitePost, PermPublic)
+}
+
+func inviteViewAll(s session, values map[string]string) {
+	renderInvites(s, "", "")
+}
+
+func renderInvites(s session, newUrl string, message string) {
+	invites, err := models.InviteGetAll()
+	if err != nil {
+		renderError(s, "Error fetching invites", err)
+		return
+	}
+	vm := viewModels.FromInvites(invites, newUrl, message, s.toViewModel())
+	renderTemplate(s, "views/invites.html", vm)
+}
+
+func inviteNew(s session, values map[string]string) {
+	role := s.req.FormValue("role")
+	note := strings.TrimSpace(s.req.FormValue("note"))
+	email := strings.TrimSpace(s.req.FormValue("email"))
+	days, _ := strconv.Atoi(s.req.FormValue("days"))
+
+	invite, token, err := models.InviteNew(role, note, email, s.loginName, days)
+	if err != nil {
+		log.Printf("Error creating invite: %s", err)
+		renderInvites(s, "", err.Error())
+		return
+	}
+	log.Printf("Invite %d created by %s (%s)", invite.Id, s.loginName, invite.Role)
+	s.audit(models.AuditInviteCreate, fmt.Sprintf("%d", invite.Id), "", fmt.Sprintf("role=%s note=%q", invite.Role, invite.Note))
+	newUrl := baseUrl(s.req) + "/auth/invite?token=" + neturl.QueryEscape(token)
+	renderInvites(s, newUrl, "")
+}
+
+func inviteRevoke(s session, values map[string]string) {
+	id := idFromString(values["id"])
+	if err := models.InviteRevoke(id); err != nil {
+		renderError(s, fmt.Sprintf("Revoking invite %d", id), err)
+		return
+	}
+	log.Printf("Invite %d revoked by %s", id, s.loginName)
+	s.audit(models.AuditInviteRevoke, fmt.Sprintf("%d", id), "", "")
+	http.Redirect(s.resp, s.req, "/invites", 303)
+}
+
+func handleInvite(s session, values map[string]string) {
+	token := s.req.URL.Query().Get("token")
+	invite, err := models.InviteFromToken(token)
+	if err != nil {
+		vm := viewModels.NewInviteAccept("", models.Invite{}, "", models.ErrInviteInvalid.Error(), s.toViewModel())
+		renderTemplate(s, "views/inviteAccept.html", vm)
+		return
+	}
+	vm := viewModels.NewInviteAccept(token, invite, "", "", s.toViewModel())
+	renderTemplate(s, "views/inviteAccept.html", vm)
+}
+
+func handleInvitePost(s session, values map[string]string) {
+	token := s.req.FormValue("token")
+	login := strings.TrimSpace(s.req.FormValue("login"))
+	password := s.req.FormValue("password")
+
+	message := ""
+	if password != s.req.FormValue("repeatPassword") {
+		message = "Password and Repeat Password must match."
+	}
+
+	var invite models.Invite
+	var err error
+	if message == "" {
+		invite, err = models.InviteAccept(token, login, password)
+		if err != nil {
+			message = err.Error()
+		}
+	}
+
+	if message != "" {
+		if invite, err = models.InviteFromToken(token); err != nil {
+			token = ""
+		}
+		vm := viewModels.NewInviteAccept(token, invite, login, message, s.toViewModel())
+		renderTemplate(s, "views/inviteAccept.html", vm)
+		return
+	}
+
+	log.Printf("Invite %d accepted by %s (%s)", invite.Id, login, invite.Role)
+	s.auditAs(login, models.AuditInviteAccept, fmt.Sprintf("%d", invite.Id), "", "login="+login+" role="+invite.Role)
+	startLogin(s, login, "/")
+}
@@ -0,0 +1,152 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+var linkRouter Router
+
+func init() {
+	// Access links are managed by admins only.
+	linkRouter.Use(logRequest, noBearer, csrf)
+	linkRouter.Add("GET", "/links", linkViewAll, PermAdmin)
+	linkRouter.Add("POST", "/links/new", linkNew, PermAdmin)
+	linkRouter.Add("GET", "/links/:id", linkViewOne, PermAdmin)
+	linkRouter.Add("POST", "/links/:id/revoke", linkRevoke, PermAdmin)
+}
+
+func linkViewAll(s session, values map[string]string) {
+	renderLinks(s, "")
+}
+
+func renderLinks(s session, message string) {
+	links, err := models.AccessLinkGetAll()
+	if err != nil {
+		renderError(s, "Error fetching access links", err)
+		return
+	}
+	vm := viewModels.FromAccessLinks(links, baseUrl(s.req), message, s.toViewModel())
+	renderTemplate(s, "views/links.html", vm)
+}
+
+func linkViewOne(s session, values map[string]string) {
+	id := values["id"]
+	link, err := models.AccessLinkGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Fetching access link %s", id), err)
+		return
+	}
+
+	uses, err := models.AccessLinkUses(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Fetching uses of access link %s", id), err)
+		return
+	}
+	vm := viewModels.NewAccessLinkView(link, uses, baseUrl(s.req), s.toViewModel())
+	renderTemplate(s, "views/linkView.html", vm)
+}
+
+func linkNew(s session, values map[string]string) {
+	scope := s.req.FormValue("scope")
+	targetId := idFromString(s.req.FormValue("targetId"))
+	description := strings.TrimSpace(s.req.FormValue("description"))
+	days, _ := strconv.Atoi(s.req.FormValue("days"))
+	maxUses, _ := strconv.Atoi(s.req.FormValue("maxUses"))
+	if maxUses < 0 {
+		maxUses = 0
+	}
+
+	link, err := models.AccessLinkNew(scope, targetId, description, s.loginName, days, maxUses)
+	if err != nil {
+		log.Printf("Error creating access link: %s", err)
+		renderLinks(s, err.Error())
+		return
+	}
+	log.Printf("Access link %s created by %s (%s %d)", link.Id, s.loginName, link.Scope, link.TargetId)
+	s.audit(models.AuditLinkCreate, link.Id, "", fmt.Sprintf("%s %d", link.Scope, link.TargetId))
+	http.Redirect(s.resp, s.req, "/links/"+link.Id, 303)
+}
+
+func linkRevoke(s session, values map[string]string) {
+	id := values["id"]
+	if err := models.AccessLinkRevoke(id); err != nil {
+		renderError(s, fmt.Sprintf("Revoking access link %s", id), err)
+		return
+	}
+	log.Printf("Access link %s revoked by %s", id, s.loginName)
+	s.audit(models.AuditLinkRevoke, id, "", "")
+	http.Redirect(s.resp, s.req, "/links", 303)
+}
+
+// Returns true if the access link of the session gives access to a
+// blog route. Links only give read-only access: the whole site, or a
+// single post.
+func (s session) linkAllowsBlog(values map[string]string) bool {
+	route := s.route
+	if !s.hasLink() || route.method != "GET" {
+		return false
+	}
+
+	switch s.link.Scope {
+	case models.AccessLinkSite:
+		return !strings.HasSuffix(route.path, "/edit") && !strings.HasSuffix(route.path, "/editOld")
+	case models.AccessLinkPost:
+		return route.path == "/:year/:title/:id" && idFromString(values["id"]) == s.link.TargetId
+	}
+	return false
+}
+
+// Same as linkAllowsBlog but for album routes.
+func (s session) linkAllowsAlbum(values map[string]string) bool {
+	route := s.route
+	if !s.hasLink() || route.method != "GET" {
+		return false
+	}
+
+	switch s.link.Scope {
+	case models.AccessLinkSite:
+		return route.path == "/albums/" || route.path == "/albums/:id"
+	case models.AccessLinkAlbum:
+		return route.path == "/albums/:id" && idFromString(values["id"]) == s.link.TargetId
+	}
+	return false
+}
+
+// Returns true if the access link of the session gives access to a
+// photo, i.e. the photo is in the post or album that was shared. Links
+// to the whole site are handled by the role of the session.
+func (s session) linkAllowsPhoto(url string) bool {
+	if !s.hasLink() {
+		return false
+	}
+
+	switch s.link.Scope {
+	case models.AccessLinkPost:
+		blog, err := models.BlogGetById(s.link.TargetId)
+		return err == nil && blog.HasPhoto(url)
+	case models.AccessLinkAlbum:
+		album, err := models.AlbumGetById(s.link.TargetId)
+		return err == nil && album.HasPhoto(url)
+	}
+	return false
+}
+
+// The configured base URL of the site, or the scheme and host of the
+// request when none is configured.
+func baseUrl(req *http.Request) string {
+	if site.BaseUrl != "" {
+		return site.BaseUrl
+	}
+	scheme := "http"
+	if isHttps(req) {
+		scheme = "https"
+	}
+	return scheme + "://" + req.Host
+}
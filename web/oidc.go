@@ -0,0 +1,184 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"log"
+	"net/http"
+	"strings"
+	"time"
+
+	"hectorcorrea.com/hk/config"
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/oidc"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+// Login with an external OpenID Connect provider. It is enabled when
+// OIDC_ISSUER is set. Users are matched by their (verified) e-mail
+// against the e-mail in the users table, login with a local password
+// keeps working for everybody.
+var oidcProvider *oidc.Provider
+var oidcName string
+
+const oidcCookie = "oidc"
+const oidcTimeout = 10 * time.Minute
+
+// What we need to remember between sending the user to the provider
+// and the provider sending them back.
+type oidcState struct {
+	State    string `json:"s"`
+	Nonce    string `json:"n"`
+	Verifier string `json:"v"`
+	Url      string `json:"u"`
+	Expires  int64  `json:"e"`
+}
+
+func init() {
+	authRouter.Add("GET", "/auth/oidc/login", handleOidcLogin, PermPublic)
+	authRouter.Add("GET", "/auth/oidc/callback", handleOidcCallback, PermPublic)
+}
+
+func initOidc(c config.Oidc, serverUrl string) {
+	if c.Issuer == "" {
+		return
+	}
+
+	redirectUrl := c.RedirectUrl
+	if redirectUrl == "" {
+		redirectUrl = serverUrl + "/auth/oidc/callback"
+	}
+	provider, err := oidc.Discover(c.Issuer, c.ClientId, c.ClientSecret, redirectUrl)
+	if err != nil {
+		log.Printf("ERROR: OIDC login disabled, could not reach %s: %s", c.Issuer, err)
+		return
+	}
+	oidcProvider = provider
+	oidcName = c.Name
+	log.Printf("OIDC login enabled with %s (redirect: %s)", c.Issuer, redirectUrl)
+}
+
+func oidcStateValue(state oidcState) string {
+	data, _ := json.Marshal(state)
+	encoded := base64.RawURLEncoding.EncodeToString(data)
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte("oidc." + encoded))
+	return encoded + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the state in the cookie if it has not been tampered with
+// and has not expired.
+func parseOidcState(value string, now time.Time) (oidcState, bool) {
+	tokens := strings.Split(value, ".")
+	if len(tokens) != 2 {
+		return oidcState{}, false
+	}
+	data, err := base64.RawURLEncoding.DecodeString(tokens[0])
+	if err != nil {
+		return oidcState{}, false
+	}
+	var state oidcState
+	if err := json.Unmarshal(data, &state); err != nil || now.Unix() > state.Expires {
+		return oidcState{}, false
+	}
+	if !hmac.Equal([]byte(value), []byte(oidcStateValue(state))) {
+		return oidcState{}, false
+	}
+	return state, true
+}
+
+func setOidcCookie(s session, value string, expires time.Time) {
+	cookie := &http.Cookie{Name: oidcCookie, Path: "/auth/oidc/"}
+	cookie.Value = value
+	cookie.Expires = expires
+	setCookie(s.resp, s.req, cookie)
+}
+
+// Sends the user to the provider.
+func handleOidcLogin(s session, values map[string]string) {
+	if oidcProvider == nil {
+		renderNotFound(s)
+		return
+	}
+
+	state := oidcState{Url: s.req.URL.Query().Get("url")}
+	var err error
+	if state.State, err = oidc.RandomString(); err == nil {
+		if state.Nonce, err = oidc.RandomString(); err == nil {
+			state.Verifier, err = oidc.RandomString()
+		}
+	}
+	if err != nil {
+		renderError(s, "Error starting login", err)
+		return
+	}
+
+	expires := time.Now().Add(oidcTimeout)
+	state.Expires = expires.Unix()
+	setOidcCookie(s, oidcStateValue(state), expires)
+	url := oidcProvider.AuthCodeUrl(state.State, state.Nonce, oidc.Challenge(state.Verifier))
+	http.Redirect(s.resp, s.req, url, 302)
+}
+
+// The provider sends the user back here with a code.
+func handleOidcCallback(s session, values map[string]string) {
+	if oidcProvider == nil {
+		renderNotFound(s)
+		return
+	}
+
+	query := s.req.URL.Query()
+	cookie, err := s.req.Cookie(oidcCookie)
+	if err != nil {
+		renderOidcFailed(s, "", "missing state cookie")
+		return
+	}
+	setOidcCookie(s, "", time.Unix(0, 0))
+
+	state, ok := parseOidcState(cookie.Value, time.Now())
+	if !ok || query.Get("state") == "" || !hmac.Equal([]byte(query.Get("state")), []byte(state.State)) {
+		renderOidcFailed(s, "", "invalid or expired state")
+		return
+	}
+	if query.Get("error") != "" {
+		renderOidcFailed(s, "", "provider returned "+query.Get("error"))
+		return
+	}
+
+	claims, err := oidcProvider.Exchange(query.Get("code"), state.Verifier, state.Nonce)
+	if err != nil {
+		renderOidcFailed(s, "", err.Error())
+		return
+	}
+	if claims.Email == "" || !claims.EmailVerified {
+		renderOidcFailed(s, claims.Email, "e-mail not verified")
+		return
+	}
+
+	user, err := models.UserGetByEmail(claims.Email)
+	if err != nil {
+		renderOidcFailed(s, claims.Email, "no user with that e-mail")
+		return
+	}
+
+	log.Printf("OIDC login OK for %s as user: %s", claims.Email, user.Login)
+	startLogin(s, user.Login, state.Url)
+}
+
+func renderOidcFailed(s session, email string, reason string) {
+	log.Printf("OIDC login FAILED for %s (IP: %s): %s", email, remoteIp(s.req), reason)
+	s.auditAs(email, models.AuditLoginFailed, email, "", "oidc: "+reason)
+	vm := viewModels.NewLogin("Could not login with "+oidcName, "", s.toViewModel())
+	renderLogin(s, vm)
+}
+
+// Renders the login page, including the option to login with the
+// OIDC provider when enabled.
+func renderLogin(s session, vm viewModels.Login) {
+	if oidcProvider != nil {
+		vm.OidcName = oidcName
+	}
+	renderTemplate(s, "views/login.html", vm)
+}
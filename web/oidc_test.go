@@ -0,0 +1,92 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"hectorcorrea.com/hk/oidc"
+	"hectorcorrea.com/hk/oidc/oidctest"
+)
+
+func TestOidcState(t *testing.T) {
+	csrfSecret = []byte("secret")
+	now := time.Now()
+	state := oidcState{State: "s1", Nonce: "n1", Verifier: "v1", Url: "/", Expires: now.Add(time.Minute).Unix()}
+	value := oidcStateValue(state)
+
+	parsed, ok := parseOidcState(value, now)
+	if !ok || parsed != state {
+		t.Errorf("Valid state rejected: %s", value)
+	}
+
+	if _, ok := parseOidcState(value, now.Add(2*time.Minute)); ok {
+		t.Errorf("Expired state accepted")
+	}
+
+	other := oidcStateValue(oidcState{State: "s2", Expires: state.Expires})
+	tampered := strings.Split(other, ".")[0] + "." + strings.Split(value, ".")[1]
+	if _, ok := parseOidcState(tampered, now); ok {
+		t.Errorf("Tampered state accepted: %s", tampered)
+	}
+}
+
+// The login redirects to the stand-in provider, which redirects back
+// to the callback with the state that we saved in the cookie and a
+// code that can be exchanged with the verifier in the cookie.
+func TestOidcLoginRedirect(t *testing.T) {
+	csrfSecret = []byte("secret")
+	server := oidctest.NewServer("client1", "someone@somewhere.com")
+	defer server.Close()
+
+	provider, err := oidc.Discover(server.URL, "client1", "", "http://localhost/auth/oidc/callback")
+	if err != nil {
+		t.Fatalf("Discovery failed: %s", err)
+	}
+	oidcProvider = provider
+	defer func() { oidcProvider = nil }()
+
+	req := httptest.NewRequest("GET", "/auth/oidc/login?url=/archive", nil)
+	resp := httptest.NewRecorder()
+	handleOidcLogin(session{resp: resp, req: req}, nil)
+
+	location := resp.Header().Get("Location")
+	if resp.Code != 302 || !strings.HasPrefix(location, server.URL+"/authorize?") {
+		t.Fatalf("Unexpected redirect (%d): %s", resp.Code, location)
+	}
+
+	var cookie *http.Cookie
+	for _, c := range resp.Result().Cookies() {
+		if c.Name == oidcCookie {
+			cookie = c
+		}
+	}
+	if cookie == nil {
+		t.Fatalf("State cookie not set")
+	}
+	state, ok := parseOidcState(cookie.Value, time.Now())
+	if !ok || state.Url != "/archive" {
+		t.Fatalf("Invalid state cookie: %s", cookie.Value)
+	}
+
+	client := &http.Client{CheckRedirect: func(req *http.Request, via []*http.Request) error {
+		return http.ErrUseLastResponse
+	}}
+	providerResp, err := client.Get(location)
+	if err != nil {
+		t.Fatalf("Authorization request failed: %s", err)
+	}
+	providerResp.Body.Close()
+
+	callback, _ := url.Parse(providerResp.Header.Get("Location"))
+	if callback.Query().Get("state") != state.State {
+		t.Errorf("Provider did not return our state")
+	}
+	claims, err := provider.Exchange(callback.Query().Get("code"), state.Verifier, state.Nonce)
+	if err != nil || claims.Email != "someone@somewhere.com" {
+		t.Errorf("Exchange failed: %v %#v", err, claims)
+	}
+}
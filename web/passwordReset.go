@@ -0,0 +1,125 @@
+package web
+
+import (
+	"database/sql"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	neturl "net/url"
+	"strings"
+	"time"
+
+	"hectorcorrea.com/hk/mailer"
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+var mail mailer.Mailer
+
+func init() {
+	authRouter.Add("GET", "/auth/forgot", handleForgotPassword, PermPublic)
+	authRouter.Add("POST", "/auth/forgot", handleForgotPasswordPost, PermPublic)
+	authRouter.Add("GET", "/auth/reset", handleResetPassword, PermPublic)
+	authRouter.Add("POST", "/auth/reset", handleResetPasswordPost, PermPublic)
+}
+
+// Same message whether the user exists or not so that this page
+// cannot be used to find out valid logins.
+const resetRequestedMessage = "If there is an account with an e-mail address for it you will receive an e-mail with a link to reset your password."
+
+func handleForgotPassword(s session, values map[string]string) {
+	vm := viewModels.NewPasswordReset("", "", s.toViewModel())
+	renderTemplate(s, "views/forgotPassword.html", vm)
+}
+
+func handleForgotPasswordPost(s session, values map[string]string) {
+	loginOrEmail := strings.TrimSpace(s.req.FormValue("user"))
+	ip := remoteIp(s.req)
+
+	// The link in the e-mail cannot use the host of the request since
+	// anybody can set it to their own (and get the token when the user
+	// clicks on the link).
+	if site.BaseUrl == "" {
+		log.Printf("ERROR: Password reset requested for %s but site.base_url is not set", loginOrEmail)
+		vm := viewModels.NewPasswordReset("", "Password resets are not available, please contact the administrator of the site.", s.toViewModel())
+		renderTemplate(s, "views/forgotPassword.html", vm)
+		return
+	}
+
+	// Reset requests are throttled like failed logins (but with their
+	// own counters) so that they cannot be used to flood a user's inbox.
+	if wait, _ := resetThrottle.Attempt(loginOrEmail, ip, time.Now()); wait > 0 {
+		log.Printf("Password reset THROTTLED for: %s (IP: %s, wait: %s)", loginOrEmail, ip, wait)
+		s.resp.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(wait.Seconds())))
+		s.resp.WriteHeader(http.StatusTooManyRequests)
+		message := fmt.Sprintf("Too many requests, try again in %s.", wait.Round(time.Second))
+		vm := viewModels.NewPasswordReset("", message, s.toViewModel())
+		renderTemplate(s, "views/forgotPassword.html", vm)
+		return
+	}
+
+	user, token, err := models.PasswordResetNew(loginOrEmail, ip)
+	if err == nil {
+		s.auditAs(user.Login, models.AuditPasswordResetRequest, user.Login, "", "")
+		err = sendPasswordReset(s, user, token)
+	}
+	if err != nil && err != sql.ErrNoRows {
+		log.Printf("Error sending password reset for %s: %s", loginOrEmail, err)
+	}
+
+	vm := viewModels.NewPasswordReset("", resetRequestedMessage, s.toViewModel())
+	vm.Done = true
+	renderTemplate(s, "views/forgotPassword.html", vm)
+}
+
+func sendPasswordReset(s session, user models.User, token string) error {
+	link := site.BaseUrl + "/auth/reset?token=" + neturl.QueryEscape(token)
+	body := fmt.Sprintf("Hello %s,\n\n"+
+		"Somebody (hopefully you) asked to reset your password at %s. Use this link to choose a new one:\n\n"+
+		"%s\n\n"+
+		"The link can be used only once and expires in %d minutes. "+
+		"If you did not ask for it you can ignore this e-mail.\n",
+		user.Name, site.Name, link, models.PasswordResetMinutes())
+	msg := mailer.Message{To: user.Email, Subject: "Reset your password for " + site.Name, Body: body}
+	if err := mail.Send(msg); err != nil {
+		return err
+	}
+	log.Printf("Password reset e-mailed to user %s", user.Login)
+	return nil
+}
+
+func handleResetPassword(s session, values map[string]string) {
+	token := s.req.URL.Query().Get("token")
+	message := ""
+	if _, err := models.PasswordResetUser(token); err != nil {
+		message = models.ErrResetInvalid.Error()
+		token = ""
+	}
+	vm := viewModels.NewPasswordReset(token, message, s.toViewModel())
+	renderTemplate(s, "views/resetPassword.html", vm)
+}
+
+func handleResetPasswordPost(s session, values map[string]string) {
+	token := s.req.FormValue("token")
+	password := s.req.FormValue("newPassword")
+	if password == "" || password != s.req.FormValue("repeatPassword") {
+		vm := viewModels.NewPasswordReset(token, "Password cannot be empty and must match Repeat Password.", s.toViewModel())
+		renderTemplate(s, "views/resetPassword.html", vm)
+		return
+	}
+
+	user, err := models.PasswordResetUse(token, password)
+	if err != nil {
+		log.Printf("Password reset FAILED (IP: %s): %s", remoteIp(s.req), err)
+		vm := viewModels.NewPasswordReset("", models.ErrResetInvalid.Error(), s.toViewModel())
+		renderTemplate(s, "views/resetPassword.html", vm)
+		return
+	}
+
+	log.Printf("Password reset for user %s", user.Login)
+	s.auditAs(user.Login, models.AuditPasswordReset, user.Login, "", "")
+	vm := viewModels.NewPasswordReset("", "Your password has been changed, you can now login with it.", s.toViewModel())
+	vm.Done = true
+	renderTemplate(s, "views/resetPassword.html", vm)
+}
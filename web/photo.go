@@ -0,0 +1,98 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+
+	"hectorcorrea.com/hk/models"
+)
+
+var photoRouter Router
+
+// Serves the photos under /photos/ from the folder indicated in
+// photos.folder. The route is public because each photo has its own
+// policy, checked by requirePhotoAccess.
+func init() {
+	photoRouter.Add("GET", "/photos/*path", photoView, PermPublic, requirePhotoAccess)
+}
+
+// Editors can see all the photos, other users can see the photos of the
+// blogs that their role gives them access to, of a blog (or album) that
+// has been shared with them (the alias is passed in the query string),
+// or that their access link gives them access to.
+func requirePhotoAccess(next RouteHandler) RouteHandler {
+	return func(s session, values map[string]string) {
+		if !s.canViewPhoto(s.req) {
+			log.Printf("Not authorized photo: %s", s.req.URL.Path)
+			http.Error(s.resp, "Not authorized", http.StatusUnauthorized)
+			return
+		}
+		next(s, values)
+	}
+}
+
+func photoView(s session, values map[string]string) {
+	resp, req := s.resp, s.req
+	if models.PhotoFolder() == "" {
+		http.NotFound(resp, req)
+		return
+	}
+
+	filename := photoFilePath(models.PhotoFolder(), req.URL.Path)
+	file, err := os.Open(filename)
+	if err != nil {
+		http.NotFound(resp, req)
+		return
+	}
+	defer file.Close()
+
+	info, err := file.Stat()
+	if err != nil || info.IsDir() {
+		http.NotFound(resp, req)
+		return
+	}
+
+	// http.ServeContent takes care of Range requests and of the
+	// If-Modified-Since/If-None-Match conditional requests.
+	etag := fmt.Sprintf("\"%x-%x\"", info.ModTime().Unix(), info.Size())
+	resp.Header().Set("ETag", etag)
+	resp.Header().Set("Cache-Control", "private, max-age=86400")
+	http.ServeContent(resp, req, info.Name(), info.ModTime(), file)
+}
+
+func (s session) canViewPhoto(req *http.Request) bool {
+	if s.canEdit() || isSharedPhoto(req) || s.linkAllowsPhoto(req.URL.Path) {
+		return true
+	}
+
+	visible, err := models.PhotoIsVisible(req.URL.Path, s.role())
+	if err != nil {
+		log.Printf("Error checking photo visibility %s: %s", req.URL.Path, err)
+	}
+	return visible
+}
+
+func isSharedPhoto(req *http.Request) bool {
+	if alias := req.URL.Query().Get("alias"); alias != "" {
+		blog, err := models.BlogGetByAlias(alias)
+		return err == nil && blog.HasPhoto(req.URL.Path)
+	}
+
+	if alias := req.URL.Query().Get("album"); alias != "" {
+		album, err := models.AlbumGetByAlias(alias)
+		return err == nil && album.HasPhoto(req.URL.Path)
+	}
+	return false
+}
+
+// Returns the path on disk for a /photos/ URL. The URL is cleaned so
+// that it cannot point outside of the folder.
+func photoFilePath(folder, url string) string {
+	rel := path.Clean("/" + strings.TrimPrefix(url, "/photos/"))
+	return filepath.Join(folder, filepath.FromSlash(rel))
+}
@@ -0,0 +1,18 @@
+package web
+
+import "testing"
+
+func TestPhotoFilePath(t *testing.T) {
+	tests := [][]string{
+		{"/photos/2008/paris.jpg", "/data/photos/2008/paris.jpg"},
+		{"/photos/paris_thumb.jpg", "/data/photos/paris_thumb.jpg"},
+		{"/photos/../secret.txt", "/data/photos/secret.txt"},
+		{"/photos/2008/../../../etc/passwd", "/data/photos/etc/passwd"},
+	}
+	for _, test := range tests {
+		path := photoFilePath("/data/photos", test[0])
+		if path != test[1] {
+			t.Errorf("Unexpected path (%s) for (%s)", path, test[0])
+		}
+	}
+}
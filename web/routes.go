@@ -3,31 +3,153 @@ package web
 import (
 	"fmt"
 	"log"
-	"regexp"
+	"net/http"
+	"reflect"
+	"runtime"
+	"sort"
 	"strings"
+	"time"
 )
 
 type RouteHandler func(session, map[string]string)
 
+// Wraps a handler with behavior that applies to several routes (e.g.
+// authorization or CSRF checks). A middleware that rejects the request
+// renders the response itself and does not call next.
+type Middleware func(next RouteHandler) RouteHandler
+
+// Who can get to a route. The permission is checked by the router
+// before the handler (and the middleware of the route) runs. Routes
+// without a permission are rejected.
+type Permission int
+
+const (
+	PermUnset      Permission = iota
+	PermPublic                // anyone, including anonymous users
+	PermPublicMode            // anyone in public mode, guests otherwise
+	PermGuest                 // logged in users (or an access link, see Router.linkAccess)
+	PermEditor                // editors and admins
+	PermAdmin                 // admins only
+)
+
+func (p Permission) String() string {
+	switch p {
+	case PermPublic:
+		return "public"
+	case PermPublicMode:
+		return "publicMode"
+	case PermGuest:
+		return "guest"
+	case PermEditor:
+		return "editor"
+	case PermAdmin:
+		return "admin"
+	}
+	return "unset"
+}
+
 type Route struct {
-	method  string // GET or POST
-	path    string // /blog/:title/:id
-	handler RouteHandler
-	re      *regexp.Regexp // /blog/(\w+)/(\w+)
-	tokens  []string       // [:title, :id]
+	method     string   // GET or POST
+	path       string   // /blog/:title/:id
+	segments   []string // [blog, :title, :id]
+	handler    RouteHandler
+	perm       Permission
+	middleware []Middleware
 }
 
+// Routes are matched in the order in which they were added. The router
+// middleware (see Use) runs first, then the permission of the route is
+// checked, and then the middleware of the route runs.
 type Router struct {
-	Routes []Route
+	routes     []Route
+	middleware []Middleware
+
+	// Anonymous users with an access link are considered guests on
+	// the routes for which this returns true (optional).
+	linkAccess func(session, map[string]string) bool
+}
+
+// Adds middleware that applies to all the routes in the router.
+func (r *Router) Use(middleware ...Middleware) {
+	r.middleware = append(r.middleware, middleware...)
 }
 
-func (r *Router) Add(method, path string, handler RouteHandler) {
+func (r *Router) Add(method, path string, handler RouteHandler, perm Permission, middleware ...Middleware) {
 	route := NewRoute(method, path, handler)
-	r.Routes = append(r.Routes, route)
+	route.perm = perm
+	route.middleware = middleware
+	r.routes = append(r.routes, route)
 }
 
-func (r Router) FindRoute(method, url string) (bool, Route) {
-	for _, route := range r.Routes {
+func (r *Router) ServeHTTP(resp http.ResponseWriter, req *http.Request) {
+	s := newSession(resp, req)
+	found, route := r.FindRoute(req.Method, req.URL.Path)
+	if !found {
+		if allow := r.allowedMethods(req.URL.Path); len(allow) > 0 {
+			renderMethodNotAllowed(s, allow)
+			return
+		}
+		renderNotFound(s)
+		return
+	}
+	s.route = route
+	r.chain(route)(s, route.UrlValues(req.URL.Path))
+}
+
+// The handler of the route wrapped in the router middleware, the
+// permission check, and the route middleware.
+func (r *Router) chain(route Route) RouteHandler {
+	handler := route.handler
+	for i := len(route.middleware) - 1; i >= 0; i-- {
+		handler = route.middleware[i](handler)
+	}
+	handler = r.checkPermission(route, handler)
+	for i := len(r.middleware) - 1; i >= 0; i-- {
+		handler = r.middleware[i](handler)
+	}
+	return handler
+}
+
+func (r *Router) checkPermission(route Route, next RouteHandler) RouteHandler {
+	return func(s session, values map[string]string) {
+		if !r.allowed(s, route.perm, values) {
+			renderNotAuthorized(s)
+			return
+		}
+		next(s, values)
+	}
+}
+
+func (r *Router) allowed(s session, perm Permission, values map[string]string) bool {
+	switch perm {
+	case PermPublic:
+		return true
+	case PermPublicMode:
+		return publicMode || r.isGuest(s, values)
+	case PermGuest:
+		return r.isGuest(s, values)
+	case PermEditor:
+		return s.canEdit()
+	case PermAdmin:
+		return s.isAdmin()
+	}
+	log.Printf("Route without a permission: %s", s.route)
+	return false
+}
+
+func (r *Router) isGuest(s session, values map[string]string) bool {
+	if s.isAuth() {
+		return true
+	}
+	return r.linkAccess != nil && r.linkAccess(s, values)
+}
+
+// HEAD requests are handled by the GET routes.
+func (r *Router) FindRoute(method, url string) (bool, Route) {
+	if method == "HEAD" {
+		method = "GET"
+	}
+	for _, route := range r.routes {
 		if route.IsMatch(method, url) {
 			return true, route
 		}
@@ -35,60 +157,180 @@ func (r Router) FindRoute(method, url string) (bool, Route) {
 	return false, Route{}
 }
 
-func (r *Router) PrintRoutes() {
-	log.Print("ROUTES { ")
-	for _, route := range r.Routes {
-		log.Print(route.DebugString())
+// Methods of the routes that match the URL (for the Allow header).
+func (r *Router) allowedMethods(url string) []string {
+	methods := []string{}
+	seen := map[string]bool{}
+	for _, route := range r.routes {
+		if seen[route.method] || !route.matchPath(url) {
+			continue
+		}
+		seen[route.method] = true
+		methods = append(methods, route.method)
+		if route.method == "GET" {
+			methods = append(methods, "HEAD")
+		}
 	}
-	log.Print("} ")
+	sort.Strings(methods)
+	return methods
+}
+
+// One line per route with its method, path, permission, handler, and
+// middleware.
+// Useful for debugging (see server.log_routes).
+func (r *Router) RouteTable() string {
+	var sb strings.Builder
+	for _, route := range r.routes {
+		names := []string{}
+		for _, m := range r.middleware {
+			names = append(names, funcName(m))
+		}
+		for _, m := range route.middleware {
+			names = append(names, funcName(m))
+		}
+		fmt.Fprintf(&sb, "%-4s %-32s %-10s %-28s %s\n", route.method, route.path,
+			route.perm, funcName(route.handler), strings.Join(names, ", "))
+	}
+	return sb.String()
 }
 
 // Path should be in the form /xxx/:title/:id
 // Values preceded by a colon (e.g. :id) are considered
-// named tokens.
+// named tokens. The last segment can be a wildcard (e.g. /xxx/*path)
+// that matches the rest of the URL.
 func NewRoute(method, path string, handler RouteHandler) Route {
-	route := Route{method: method, path: path, handler: handler}
-	if !strings.Contains(path, "/:") {
-		// Route without tokens
-		route.re = regexp.MustCompile("^" + path + "/??$")
-		return route
-	}
-
-	// Store the tokens indicated in the path (e.g. :title, :id)
-	// and a regEx to match them
-	tokenRe := regexp.MustCompile("/:([\\w\\-\\._]+)")
-	pattern := path
-	for _, token := range tokenRe.FindAllString(path, -1) {
-		route.tokens = append(route.tokens, token)
-		pattern = strings.Replace(pattern, token, "/([\\w\\-\\._]+)", 1)
-	}
-	route.re = regexp.MustCompile("^" + pattern + "/??$")
-	return route
+	return Route{method: method, path: path, segments: splitPath(path), handler: handler}
 }
 
 func (r Route) IsMatch(method, url string) bool {
-	return r.method == method && r.re.MatchString(url)
+	return r.method == method && r.matchPath(url)
+}
+
+func (r Route) matchPath(url string) bool {
+	if !strings.HasPrefix(url, "/") {
+		return false
+	}
+	segments := splitPath(url)
+	if !r.matchLength(segments) {
+		return false
+	}
+	for i, segment := range r.segments {
+		if isWildcard(segment) {
+			return true
+		} else if isToken(segment) {
+			if !isTokenValue(segments[i]) {
+				return false
+			}
+		} else if segment != segments[i] {
+			return false
+		}
+	}
+	return true
 }
 
 func (r Route) UrlValues(url string) map[string]string {
 	values := make(map[string]string)
-	matches := r.re.FindStringSubmatch(url)
-	if len(matches) == len(r.tokens)+1 {
-		for i, token := range r.tokens {
-			key := token[2:] // "/:title" becomes "title"
-			values[key] = matches[i+1]
+	segments := splitPath(url)
+	if !r.matchLength(segments) {
+		log.Printf("got NO values: %s %d %d\r\n", url, len(segments), len(r.segments))
+		return values
+	}
+	for i, segment := range r.segments {
+		if isWildcard(segment) {
+			values[segment[1:]] = strings.Join(segments[i:], "/")
+		} else if isToken(segment) {
+			values[segment[1:]] = segments[i] // ":title" becomes "title"
 		}
-		// log.Printf("set values %s", values)
-	} else {
-		log.Printf("got NO values: %s %d %d\r\n", url, len(matches), len(r.tokens))
 	}
 	return values
 }
 
+// A wildcard matches one or more segments.
+func (r Route) matchLength(segments []string) bool {
+	n := len(r.segments)
+	if n > 0 && isWildcard(r.segments[n-1]) {
+		return len(segments) >= n
+	}
+	return len(segments) == n
+}
+
 func (r Route) String() string {
 	return fmt.Sprintf("%s %s", r.method, r.path)
 }
 
-func (r Route) DebugString() string {
-	return fmt.Sprintf("%s %s %s", r.method, r.path, r.tokens)
+// Splits the path in its segments, a trailing slash is ignored
+// (i.e. /blog/ and /blog are the same).
+func splitPath(path string) []string {
+	path = strings.TrimPrefix(path, "/")
+	path = strings.TrimSuffix(path, "/")
+	if path == "" {
+		return []string{}
+	}
+	return strings.Split(path, "/")
+}
+
+func isToken(segment string) bool {
+	return strings.HasPrefix(segment, ":")
+}
+
+func isWildcard(segment string) bool {
+	return strings.HasPrefix(segment, "*")
+}
+
+// Values for the tokens can include letters, digits, underscores,
+// dashes, and dots.
+func isTokenValue(value string) bool {
+	if value == "" {
+		return false
+	}
+	for _, c := range value {
+		valid := (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z') || (c >= '0' && c <= '9') ||
+			c == '_' || c == '-' || c == '.'
+		if !valid {
+			return false
+		}
+	}
+	return true
+}
+
+// Name of a function without the package, e.g. "requireAdmin".
+func funcName(f interface{}) string {
+	name := runtime.FuncForPC(reflect.ValueOf(f).Pointer()).Name()
+	return name[strings.LastIndex(name, ".")+1:]
+}
+
+func renderMethodNotAllowed(s session, allow []string) {
+	log.Printf("Method not allowed: %s %s (%s)", s.req.Method, s.req.URL.Path, s.loginName)
+	s.resp.Header().Set("Allow", strings.Join(allow, ", "))
+	http.Error(s.resp, "Method not allowed", http.StatusMethodNotAllowed)
+}
+
+// Logs each request with the user and how long it took.
+func logRequest(next RouteHandler) RouteHandler {
+	return func(s session, values map[string]string) {
+		start := time.Now()
+		next(s, values)
+		log.Printf("%s %s (%s) %s", s.req.Method, s.req.URL.Path, s.loginName, time.Since(start))
+	}
+}
+
+// API tokens cannot be used to manage the account (e.g. to create more
+// tokens or change the password).
+func noBearer(next RouteHandler) RouteHandler {
+	return func(s session, values map[string]string) {
+		if s.bearer {
+			renderNotAuthorized(s)
+			return
+		}
+		next(s, values)
+	}
+}
+
+func csrf(next RouteHandler) RouteHandler {
+	return func(s session, values map[string]string) {
+		if !checkCsrf(s) {
+			return
+		}
+		next(s, values)
+	}
 }
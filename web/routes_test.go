@@ -1,21 +1,26 @@
 package web
 
-import "testing"
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
 
 func dummyHandler(s session, values map[string]string) {
 }
 
 func TestRoutes(t *testing.T) {
 	var router Router
-	router.Add("GET", "/auth/login", dummyHandler)
-	router.Add("GET", "/auth/logout", dummyHandler)
-	router.Add("GET", "/auth/changepassword", dummyHandler)
-	router.Add("POST", "/auth/login", dummyHandler)
-	router.Add("POST", "/auth/changepassword", dummyHandler)
-	router.Add("GET", "/blog/:title/:id", dummyHandler)
-	router.Add("GET", "/blog", dummyHandler)
-	router.Add("POST", "/blog/:title/:id/edit", dummyHandler)
-	router.Add("POST", "/blog/new", dummyHandler)
+	router.Add("GET", "/auth/login", dummyHandler, PermPublic)
+	router.Add("GET", "/auth/logout", dummyHandler, PermPublic)
+	router.Add("GET", "/auth/changepassword", dummyHandler, PermPublic)
+	router.Add("POST", "/auth/login", dummyHandler, PermPublic)
+	router.Add("POST", "/auth/changepassword", dummyHandler, PermPublic)
+	router.Add("GET", "/blog/:title/:id", dummyHandler, PermPublic)
+	router.Add("GET", "/blog", dummyHandler, PermPublic)
+	router.Add("POST", "/blog/:title/:id/edit", dummyHandler, PermPublic)
+	router.Add("POST", "/blog/new", dummyHandler, PermPublic)
 
 	// GET valid URLs
 	tests := []string{
@@ -62,3 +67,181 @@ func TestRoutes(t *testing.T) {
 		}
 	}
 }
+
+func TestRouteValues(t *testing.T) {
+	var router Router
+	router.Add("GET", "/blog/:title/:id", dummyHandler, PermPublic)
+	found, route := router.FindRoute("GET", "/blog/some-title.xyz/123/")
+	if !found {
+		t.Fatalf("Route not found")
+	}
+	values := route.UrlValues("/blog/some-title.xyz/123/")
+	if values["title"] != "some-title.xyz" || values["id"] != "123" {
+		t.Errorf("Unexpected values: %v", values)
+	}
+
+	if found, _ := router.FindRoute("HEAD", "/blog/title/123"); !found {
+		t.Errorf("HEAD should be handled by the GET route")
+	}
+}
+
+func TestRouterMethodNotAllowed(t *testing.T) {
+	var router Router
+	router.Add("GET", "/things/:id", dummyHandler, PermPublic)
+	router.Add("POST", "/things/:id", dummyHandler, PermPublic)
+	router.Add("POST", "/things/:id/delete", dummyHandler, PermPublic)
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("PUT", "/things/1", nil))
+	if resp.Code != http.StatusMethodNotAllowed {
+		t.Errorf("Unexpected status code: %d", resp.Code)
+	}
+	if allow := resp.Header().Get("Allow"); allow != "GET, HEAD, POST" {
+		t.Errorf("Unexpected Allow header: %s", allow)
+	}
+
+	resp = httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/things/1/delete", nil))
+	if resp.Header().Get("Allow") != "POST" {
+		t.Errorf("Unexpected Allow header: %s", resp.Header().Get("Allow"))
+	}
+}
+
+func TestRouterMiddleware(t *testing.T) {
+	calls := []string{}
+	track := func(name string) Middleware {
+		return func(next RouteHandler) RouteHandler {
+			return func(s session, values map[string]string) {
+				calls = append(calls, name)
+				next(s, values)
+			}
+		}
+	}
+
+	var router Router
+	router.Use(track("router1"), track("router2"))
+	router.Add("GET", "/things/:id", func(s session, values map[string]string) {
+		calls = append(calls, "handler "+values["id"])
+	}, PermPublic, track("route"))
+
+	resp := httptest.NewRecorder()
+	router.ServeHTTP(resp, httptest.NewRequest("GET", "/things/42", nil))
+	expected := "router1, router2, route, handler 42"
+	if got := strings.Join(calls, ", "); got != expected {
+		t.Errorf("Unexpected calls: %s", got)
+	}
+}
+
+func TestRouterPermission(t *testing.T) {
+	var router Router
+	router.Add("GET", "/things", func(s session, values map[string]string) {
+		t.Errorf("Handler should not be called for anonymous users")
+	}, PermAdmin)
+	router.Add("GET", "/unset", func(s session, values map[string]string) {
+		t.Errorf("Handler should not be called for routes without a permission")
+	}, PermUnset)
+
+	for _, url := range []string{"/things", "/unset"} {
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, httptest.NewRequest("GET", url, nil))
+		if resp.Code != http.StatusUnauthorized {
+			t.Errorf("Unexpected status code for %s: %d", url, resp.Code)
+		}
+	}
+
+	admin := session{loginName: "user1", userType: "admin"}
+	editor := session{loginName: "user2", userType: "editor"}
+	if _, allowed := routeAllowed(&router, admin, "GET", "/things"); !allowed {
+		t.Errorf("Admin should be allowed")
+	}
+	if _, allowed := routeAllowed(&router, editor, "GET", "/things"); allowed {
+		t.Errorf("Editor should not be allowed")
+	}
+	if _, allowed := routeAllowed(&router, admin, "GET", "/unset"); allowed {
+		t.Errorf("Routes without a permission should not be allowed")
+	}
+}
+
+// Every route must say who can get to it.
+func TestRoutesHavePermission(t *testing.T) {
+	for _, router := range routers() {
+		for _, route := range router.routes {
+			if route.perm == PermUnset {
+				t.Errorf("Route without a permission: %s", route)
+			}
+		}
+	}
+}
+
+func TestMountedRouters(t *testing.T) {
+	mux := http.NewServeMux()
+	for _, mount := range mounts {
+		mux.Handle(mount.pattern, mount.router)
+	}
+	tests := map[string]*Router{
+		"/photos/2008/paris.jpg": &photoRouter,
+		"/links":                 &linkRouter,
+		"/albums/1":              &albumRouter,
+		"/blog/title/1":          &blogRouter,
+	}
+	for url, expected := range tests {
+		req := httptest.NewRequest("GET", url, nil)
+		handler, _ := mux.Handler(req)
+		if handler != expected {
+			t.Errorf("Unexpected router for %s", url)
+		}
+	}
+}
+
+func TestRouteWildcard(t *testing.T) {
+	var router Router
+	router.Add("GET", "/photos/*path", dummyHandler, PermPublic)
+	for _, url := range []string{"/photos", "/photos/", "/blog/a.jpg"} {
+		if found, _ := router.FindRoute("GET", url); found {
+			t.Errorf("Found an incorrect route for GET %s", url)
+		}
+	}
+	found, route := router.FindRoute("GET", "/photos/2008/my paris.jpg")
+	if !found {
+		t.Fatalf("Route not found")
+	}
+	values := route.UrlValues("/photos/2008/my paris.jpg")
+	if values["path"] != "2008/my paris.jpg" {
+		t.Errorf("Unexpected values: %v", values)
+	}
+}
+
+func TestRouteTable(t *testing.T) {
+	var router Router
+	router.Use(logRequest)
+	router.Add("POST", "/blog/new", dummyHandler, PermEditor, csrf)
+	table := router.RouteTable()
+	for _, text := range []string{"POST", "/blog/new", "editor", "dummyHandler", "logRequest, csrf"} {
+		if !strings.Contains(table, text) {
+			t.Errorf("%s not found in the route table: %s", text, table)
+		}
+	}
+}
+
+// Returns true if the middleware of the route lets the session through
+// to the handler.
+func routeAllowed(router *Router, s session, method string, url string) (bool, bool) {
+	found, route := router.FindRoute(method, url)
+	if !found {
+		return false, false
+	}
+
+	csrfSecret = []byte("secret")
+	s.csrfKey = "key"
+	s.req = httptest.NewRequest(method, url, nil)
+	s.req.Header.Set(csrfHeaderName, csrfToken(s.csrfKey))
+	s.resp = httptest.NewRecorder()
+	s.route = route
+
+	allowed := false
+	route.handler = func(s session, values map[string]string) {
+		allowed = true
+	}
+	router.chain(route)(s, route.UrlValues(url))
+	return true, allowed
+}
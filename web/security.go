@@ -0,0 +1,126 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"net/url"
+	"strings"
+
+	"hectorcorrea.com/hk/config"
+)
+
+// Headers added to every response. The defaults can be changed with
+// the settings indicated below (see config.Security).
+type headerPolicy struct {
+	csp            string // security.csp, the whole policy
+	frameOptions   string // security.frame_options
+	referrerPolicy string // security.referrer_policy
+	hstsMaxAge     int    // security.hsts_max_age in seconds, only sent over HTTPS (0 to disable)
+}
+
+var securityPolicy headerPolicy
+
+// The views have inline scripts (and a few onclick/onsubmit handlers)
+// and the blueimp gallery and the maps set inline styles, hence the
+// 'unsafe-inline'. Everything else (jQuery, bootstrap, blueimp) is
+// served from /public. Images can also come from the map tile server
+// and from the hosts in security.csp_img_src (e.g. if photos are
+// served by another web server).
+func defaultCsp(imgSources []string) string {
+	img := append([]string{"'self'", "data:", "blob:"}, imgSources...)
+	directives := []string{
+		"default-src 'self'",
+		"script-src 'self' 'unsafe-inline'",
+		"style-src 'self' 'unsafe-inline'",
+		"img-src " + strings.Join(img, " "),
+		"font-src 'self'",
+		"connect-src 'self'",
+		"object-src 'none'",
+		"base-uri 'self'",
+		"form-action 'self'",
+		"frame-ancestors 'none'",
+	}
+	return strings.Join(directives, "; ")
+}
+
+// Returns the scheme and host of a URL (e.g. of the map tiles) to use
+// in the CSP.
+func cspSource(rawUrl string) string {
+	u, err := url.Parse(strings.Replace(rawUrl, "{s}", "a", -1))
+	if err != nil || u.Host == "" {
+		return ""
+	}
+	// Tile servers with subdomains ({s}.tile.somewhere.com)
+	host := u.Host
+	if strings.Contains(rawUrl, "{s}.") {
+		host = "*" + host[strings.Index(host, "."):]
+	}
+	return u.Scheme + "://" + host
+}
+
+func initSecurityHeaders(c config.Security, tiles config.Map) {
+	imgSources := append([]string{}, c.CspImgSrc...)
+	if source := cspSource(tiles.TileUrl); source != "" {
+		imgSources = append(imgSources, source)
+	}
+
+	csp := c.Csp
+	if csp == "" {
+		csp = defaultCsp(imgSources)
+	}
+	securityPolicy = headerPolicy{
+		csp:            csp,
+		frameOptions:   c.FrameOptions,
+		referrerPolicy: c.ReferrerPolicy,
+		hstsMaxAge:     c.HstsMaxAge,
+	}
+	log.Printf("Content-Security-Policy: %s", securityPolicy.csp)
+}
+
+// Middleware that adds the security headers to every response.
+func securityHeaders(policy headerPolicy, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		h := resp.Header()
+		if policy.csp != "" {
+			h.Set("Content-Security-Policy", policy.csp)
+		}
+		if policy.frameOptions != "" {
+			h.Set("X-Frame-Options", policy.frameOptions)
+		}
+		if policy.referrerPolicy != "" {
+			h.Set("Referrer-Policy", policy.referrerPolicy)
+		}
+		if policy.hstsMaxAge > 0 && isHttps(req) {
+			h.Set("Strict-Transport-Security", fmt.Sprintf("max-age=%d; includeSubDomains", policy.hstsMaxAge))
+		}
+		h.Set("X-Content-Type-Options", "nosniff")
+		next.ServeHTTP(resp, req)
+	})
+}
+
+// True if the request came over HTTPS, either directly or via a proxy
+// that terminates TLS. Same as in remoteIp, X-Forwarded-Proto is only
+// trusted when it comes from a proxy running on the same machine.
+func isHttps(req *http.Request) bool {
+	if req.TLS != nil {
+		return true
+	}
+	return fromLocalProxy(req) && req.Header.Get("X-Forwarded-Proto") == "https"
+}
+
+// Sets a cookie with the attributes that every cookie in the site
+// must have: not available to JavaScript, not sent on cross site
+// requests (other than top level navigation), and only sent over
+// HTTPS when the site is served over HTTPS.
+func setCookie(resp http.ResponseWriter, req *http.Request, cookie *http.Cookie) {
+	if cookie.Path == "" {
+		cookie.Path = "/"
+	}
+	cookie.HttpOnly = true
+	if cookie.SameSite == 0 || cookie.SameSite == http.SameSiteDefaultMode {
+		cookie.SameSite = http.SameSiteLaxMode
+	}
+	cookie.Secure = isHttps(req)
+	http.SetCookie(resp, cookie)
+}
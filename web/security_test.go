@@ -0,0 +1,96 @@
+package web
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSecurityHeaders(t *testing.T) {
+	policy := headerPolicy{
+		csp:            defaultCsp([]string{"https://tile.openstreetmap.org"}),
+		frameOptions:   "DENY",
+		referrerPolicy: "same-origin",
+		hstsMaxAge:     3600,
+	}
+	next := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {})
+	handler := securityHeaders(policy, next)
+
+	resp := httptest.NewRecorder()
+	handler.ServeHTTP(resp, httptest.NewRequest("GET", "/", nil))
+	h := resp.Header()
+	if !strings.Contains(h.Get("Content-Security-Policy"), "img-src 'self' data: blob: https://tile.openstreetmap.org;") {
+		t.Errorf("Unexpected CSP: %s", h.Get("Content-Security-Policy"))
+	}
+	if h.Get("X-Frame-Options") != "DENY" || h.Get("Referrer-Policy") != "same-origin" || h.Get("X-Content-Type-Options") != "nosniff" {
+		t.Errorf("Missing headers: %v", h)
+	}
+	if h.Get("Strict-Transport-Security") != "" {
+		t.Errorf("HSTS should not be sent over HTTP")
+	}
+
+	req := httptest.NewRequest("GET", "/", nil)
+	req.Header.Set("X-Forwarded-Proto", "https")
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Header().Get("Strict-Transport-Security") != "" {
+		t.Errorf("X-Forwarded-Proto should only be trusted from a local proxy")
+	}
+
+	req.RemoteAddr = "127.0.0.1:40000"
+	resp = httptest.NewRecorder()
+	handler.ServeHTTP(resp, req)
+	if resp.Header().Get("Strict-Transport-Security") != "max-age=3600; includeSubDomains" {
+		t.Errorf("HSTS not sent over HTTPS: %v", resp.Header())
+	}
+}
+
+func TestCspSource(t *testing.T) {
+	tests := map[string]string{
+		"https://tile.openstreetmap.org/{z}/{x}/{y}.png":     "https://tile.openstreetmap.org",
+		"https://{s}.tile.openstreetmap.org/{z}/{x}/{y}.png": "https://*.tile.openstreetmap.org",
+		"http://localhost:8080/tiles/{z}/{x}/{y}.png":        "http://localhost:8080",
+		"/tiles/{z}/{x}/{y}.png":                             "",
+	}
+	for tileUrl, expected := range tests {
+		if source := cspSource(tileUrl); source != expected {
+			t.Errorf("Unexpected source for %s: %s", tileUrl, source)
+		}
+	}
+}
+
+func TestSetCookie(t *testing.T) {
+	resp := httptest.NewRecorder()
+	setCookie(resp, httptest.NewRequest("GET", "/", nil), &http.Cookie{Name: "a", Value: "1"})
+	cookie := resp.Result().Cookies()[0]
+	if !cookie.HttpOnly || cookie.Secure || cookie.Path != "/" || cookie.SameSite != http.SameSiteLaxMode {
+		t.Errorf("Unexpected cookie over HTTP: %#v", cookie)
+	}
+
+	req := httptest.NewRequest("GET", "https://localhost/", nil)
+	resp = httptest.NewRecorder()
+	setCookie(resp, req, &http.Cookie{Name: "a", Value: "1", Path: "/auth/", SameSite: http.SameSiteStrictMode})
+	cookie = resp.Result().Cookies()[0]
+	if !cookie.HttpOnly || !cookie.Secure || cookie.Path != "/auth/" || cookie.SameSite != http.SameSiteStrictMode {
+		t.Errorf("Unexpected cookie over HTTPS: %#v", cookie)
+	}
+}
+
+func TestBaseUrl(t *testing.T) {
+	req := httptest.NewRequest("GET", "http://localhost:9001/rss", nil)
+	if url := baseUrl(req); url != "http://localhost:9001" {
+		t.Errorf("Unexpected base URL from the request: %s", url)
+	}
+	req.Header.Set("X-Forwarded-Proto", "https")
+	req.RemoteAddr = "127.0.0.1:40000"
+	if url := baseUrl(req); url != "https://localhost:9001" {
+		t.Errorf("Unexpected base URL behind a proxy: %s", url)
+	}
+
+	site.BaseUrl = "https://example.com"
+	defer func() { site.BaseUrl = "" }()
+	if url := baseUrl(req); url != "https://example.com" {
+		t.Errorf("The configured base URL should be used: %s", url)
+	}
+}
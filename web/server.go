@@ -0,0 +1,142 @@
+package web
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"sync"
+	"time"
+
+	"hectorcorrea.com/hk/config"
+	"hectorcorrea.com/hk/models"
+)
+
+// Settings for the http.Server (from the [server] section of the
+// configuration). HTTPS is used when both the certificate and the key
+// files are set.
+type serverConfig struct {
+	address           string
+	readHeaderTimeout time.Duration
+	readTimeout       time.Duration
+	writeTimeout      time.Duration
+	idleTimeout       time.Duration
+	shutdownTimeout   time.Duration
+	maxHeaderBytes    int
+	certFile          string
+	keyFile           string
+}
+
+func newServerConfig(c config.Server) serverConfig {
+	return serverConfig{
+		address:           c.Address,
+		readHeaderTimeout: seconds(c.ReadHeaderTimeout),
+		readTimeout:       seconds(c.ReadTimeout),
+		writeTimeout:      seconds(c.WriteTimeout),
+		idleTimeout:       seconds(c.IdleTimeout),
+		shutdownTimeout:   seconds(c.ShutdownTimeout),
+		maxHeaderBytes:    c.MaxHeaderBytes,
+		certFile:          c.TlsCertFile,
+		keyFile:           c.TlsKeyFile,
+	}
+}
+
+func (c serverConfig) useTls() bool {
+	return c.certFile != "" && c.keyFile != ""
+}
+
+func (c serverConfig) url() string {
+	if c.useTls() {
+		return "https://" + c.address
+	}
+	return "http://" + c.address
+}
+
+func newServer(options serverConfig, handler http.Handler) *http.Server {
+	return &http.Server{
+		Addr:              options.address,
+		Handler:           handler,
+		ReadHeaderTimeout: options.readHeaderTimeout,
+		ReadTimeout:       options.readTimeout,
+		WriteTimeout:      options.writeTimeout,
+		IdleTimeout:       options.idleTimeout,
+		MaxHeaderBytes:    options.maxHeaderBytes,
+	}
+}
+
+// Serves requests until a signal is received, then stops accepting new
+// connections and waits (up to shutdownTimeout) for the requests in
+// flight to finish.
+func serve(server *http.Server, listener net.Listener, options serverConfig, signals <-chan os.Signal) error {
+	errors := make(chan error, 1)
+	go func() {
+		if options.useTls() {
+			errors <- server.ServeTLS(listener, options.certFile, options.keyFile)
+		} else {
+			errors <- server.Serve(listener)
+		}
+	}()
+
+	select {
+	case err := <-errors:
+		return err
+	case sig := <-signals:
+		log.Printf("Received %s, shutting down", sig)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), options.shutdownTimeout)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		return fmt.Errorf("Shutting down the web server: %s", err)
+	}
+	if err := <-errors; err != http.ErrServerClosed {
+		return err
+	}
+	return nil
+}
+
+// Runs fn every interval in the background. The returned function
+// stops the job and waits for a run in progress to finish. Jobs with
+// an interval of zero (or less) are disabled.
+func startJob(name string, interval time.Duration, fn func(now time.Time)) func() {
+	if interval <= 0 {
+		log.Printf("Job %s is disabled", name)
+		return func() {}
+	}
+
+	done := make(chan bool)
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-done:
+				return
+			case now := <-ticker.C:
+				fn(now)
+			}
+		}
+	}()
+	log.Printf("Started job %s (every %s)", name, interval)
+
+	return func() {
+		close(done)
+		wg.Wait()
+		log.Printf("Stopped job %s", name)
+	}
+}
+
+func cleanExpired(now time.Time) {
+	if err := models.CleanExpired(now); err != nil {
+		log.Printf("ERROR: Cleaning expired records: %s", err)
+	}
+}
+
+func seconds(value int) time.Duration {
+	return time.Duration(value) * time.Second
+}
@@ -0,0 +1,92 @@
+package web
+
+import (
+	"net"
+	"net/http"
+	"os"
+	"sync/atomic"
+	"syscall"
+	"testing"
+	"time"
+
+	"hectorcorrea.com/hk/config"
+)
+
+func TestNewServerConfig(t *testing.T) {
+	c := config.Default().Server
+	c.WriteTimeout = 5
+	options := newServerConfig(c)
+	if options.writeTimeout != 5*time.Second || options.readTimeout != 30*time.Second {
+		t.Errorf("Unexpected timeouts: %v %v", options.writeTimeout, options.readTimeout)
+	}
+	if options.useTls() || options.url() != "http://localhost:9001" {
+		t.Errorf("TLS should not be used without a certificate: %s", options.url())
+	}
+
+	options.certFile = "cert.pem"
+	options.keyFile = "key.pem"
+	if !options.useTls() || options.url() != "https://localhost:9001" {
+		t.Errorf("TLS should be used with a certificate: %s", options.url())
+	}
+}
+
+func TestServeDrainsRequests(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	started := make(chan bool)
+	handler := http.HandlerFunc(func(resp http.ResponseWriter, req *http.Request) {
+		started <- true
+		time.Sleep(100 * time.Millisecond)
+		resp.Write([]byte("done"))
+	})
+	c := serverConfig{shutdownTimeout: 5 * time.Second}
+	server := newServer(c, handler)
+	signals := make(chan os.Signal, 1)
+	result := make(chan error, 1)
+	go func() {
+		result <- serve(server, listener, c, signals)
+	}()
+
+	status := make(chan int, 1)
+	go func() {
+		resp, err := http.Get("http://" + listener.Addr().String())
+		if err != nil {
+			status <- 0
+			return
+		}
+		resp.Body.Close()
+		status <- resp.StatusCode
+	}()
+
+	<-started
+	signals <- syscall.SIGTERM
+	if code := <-status; code != http.StatusOK {
+		t.Errorf("Request in flight was not completed: %d", code)
+	}
+	if err := <-result; err != nil {
+		t.Errorf("Unexpected error: %s", err)
+	}
+}
+
+func TestStartJob(t *testing.T) {
+	var runs int32
+	stop := startJob("test", 10*time.Millisecond, func(now time.Time) {
+		atomic.AddInt32(&runs, 1)
+	})
+	time.Sleep(55 * time.Millisecond)
+	stop()
+	count := atomic.LoadInt32(&runs)
+	if count == 0 {
+		t.Errorf("Job did not run")
+	}
+
+	time.Sleep(30 * time.Millisecond)
+	if atomic.LoadInt32(&runs) != count {
+		t.Errorf("Job ran after it was stopped")
+	}
+
+	startJob("disabled", 0, func(now time.Time) {})()
+}
@@ -2,8 +2,10 @@ package web
 
 import (
 	"errors"
+	"fmt"
 	"log"
 	"net/http"
+	"strings"
 	"time"
 
 	"hectorcorrea.com/hk/models"
@@ -17,16 +19,75 @@ type session struct {
 	loginName string
 	sessionId string
 	userType  string
+	csrfKey   string
+	link      models.AccessLink // for anonymous users with an access link
+	bearer    bool              // authenticated with an API token rather than cookies
+	apiToken  models.ApiToken
+	route     Route // route of the request, set by the router
 }
 
 func newSession(resp http.ResponseWriter, req *http.Request) session {
+	if token := bearerToken(req); token != "" {
+		return newSessionFromToken(resp, req, token)
+	}
+	s := newSessionFromCookies(resp, req)
+	s.csrfKey = csrfKey(resp, req, s.sessionId)
+	return s
+}
+
+// Returns the token in the "Authorization: Bearer <token>" header.
+func bearerToken(req *http.Request) string {
+	auth := req.Header.Get("Authorization")
+	if len(auth) > 7 && strings.EqualFold(auth[0:7], "bearer ") {
+		return strings.TrimSpace(auth[7:])
+	}
+	return ""
+}
+
+// Sessions for API tokens are not kept anywhere, every request has to
+// include the token. Requests with a token that is not valid, or that
+// does not have the scope for the request, are treated as anonymous
+// (and therefore rejected by routes that need a user).
+func newSessionFromToken(resp http.ResponseWriter, req *http.Request, token string) session {
+	s := session{resp: resp, req: req, bearer: true}
+	apiToken, user, err := models.ApiTokenAuth(token)
+	if err != nil {
+		log.Printf("API token was not valid: %s", err)
+		return s
+	}
+
+	scope := requiredScope(req)
+	if !apiToken.HasScope(scope) {
+		log.Printf("API token %d for %s does not have scope %s", apiToken.Id, user.Login, scope)
+		return s
+	}
+
+	s.loginName = user.Login
+	s.userType = user.Type
+	s.apiToken = apiToken
+	return s
+}
+
+// Scope that an API token needs for a request.
+func requiredScope(req *http.Request) string {
+	switch {
+	case req.Method == "GET" || req.Method == "HEAD":
+		return models.ScopeRead
+	case strings.HasPrefix(req.URL.Path, "/photos/"):
+		return models.ScopeUpload
+	default:
+		return models.ScopeWrite
+	}
+}
+
+func newSessionFromCookies(resp http.ResponseWriter, req *http.Request) session {
 	cookie, err := req.Cookie("sessionId")
 	if err == nil {
 		sessionID := cookie.Value
 		userSession, err := models.GetUserSession(sessionID)
 		if err == nil {
 			// Known user with a valid sessionID.
-			return session{
+			s := session{
 				resp:      resp,
 				req:       req,
 				cookie:    cookie,
@@ -34,41 +95,54 @@ func newSession(resp http.ResponseWriter, req *http.Request) session {
 				sessionId: cookie.Value,
 				userType:  userSession.UserType,
 			}
+			if userSession.Extended {
+				// Sliding expiration, keep the cookie around as long as
+				// the session.
+				s.setSessionCookie(userSession.ExpiresOn)
+			}
+			return s
 		}
 		log.Printf("SessionId was not valid (%s) %s", sessionID, err)
 	}
 
-	cookie, err = req.Cookie("ticketId")
-	if err == nil {
-		ticketID := cookie.Value
-		userSession, err := models.GetUserSession(ticketID)
-		if err == nil {
-			// Known user with a valid ticketID.
-			return session{
-				resp:      resp,
-				req:       req,
-				cookie:    cookie,
-				loginName: userSession.Login,
-				sessionId: cookie.Value,
-				userType:  userSession.UserType,
-			}
+	// Anonymous user, possibly with an access link.
+	s := session{resp: resp, req: req}
+	s.loadLink()
+	return s
+}
+
+// Access links are passed in the URL the first time (?link=), opening
+// the link records a use and gives us an open ID that is kept in a
+// cookie until the link expires. The link token itself is never
+// accepted from the cookie, otherwise it could be reused without
+// counting the uses.
+func (s *session) loadLink() {
+	token := s.req.URL.Query().Get("link")
+	if cookie, err := s.req.Cookie("linkOpenId"); err == nil {
+		link, err := models.AccessLinkFromOpen(cookie.Value)
+		if err == nil && (token == "" || token == link.Token()) {
+			s.link = link
+			return
+		}
+		if err != nil {
+			log.Printf("LinkOpenId was not valid %s", err)
 		}
-		log.Printf("TicketId was not valid (%s) %s", ticketID, err)
 	}
 
-	ticket := req.URL.Query().Get("ticket")
-	if ticket != "" {
-		s := session{resp: resp, req: req, userType: "guest"}
-		err := s.loginTicket(ticket)
-		if err == nil {
-			// User has a valid ticket in the URL.
-			return s
+	if token != "" {
+		link, openId, err := models.AccessLinkOpen(token, remoteIp(s.req), s.req.UserAgent())
+		if err != nil {
+			log.Printf("Access link was not valid (%s) %s", token, err)
+			return
 		}
-		log.Printf("Ticket was not valid (%s) %s", ticket, err)
-	}
 
-	// Anonymous user
-	return session{resp: resp, req: req}
+		s.link = link
+		s.audit(models.AuditLinkUse, link.Id, "", fmt.Sprintf("%s %d", link.Scope, link.TargetId))
+		cookie := &http.Cookie{Name: "linkOpenId"}
+		cookie.Value = openId
+		cookie.Expires = link.ExpiresOn
+		setCookie(s.resp, s.req, cookie)
+	}
 }
 
 func (s *session) logout() {
@@ -79,66 +153,52 @@ func (s *session) logout() {
 		s.cookie.Value = ""
 		s.cookie.Expires = time.Unix(0, 0)
 		s.cookie.Path = "/"
-		s.cookie.HttpOnly = true
-		http.SetCookie(s.resp, s.cookie)
+		setCookie(s.resp, s.req, s.cookie)
 	}
 }
 
+// Validates the user/password and starts a new session.
 func (s *session) login(loginName, password string) error {
+	if err := s.checkPassword(loginName, password); err != nil {
+		return err
+	}
+	return s.startSession(loginName)
+}
+
+func (s *session) checkPassword(loginName, password string) error {
 	logged, err := models.LoginUser(loginName, password)
 	if err != nil {
 		return err
 	}
 
-	if logged {
-		userSession, err := models.NewUserSession(loginName)
-		if err != nil {
-			log.Printf("ERROR creating new session: %s", err)
-			return err
-		}
-
-		s.loginName = userSession.Login
-		s.sessionId = userSession.SessionId
-		s.cookie = &http.Cookie{Name: "sessionId"}
-		s.cookie.Value = s.sessionId
-		s.cookie.Expires = userSession.ExpiresOn
-		s.cookie.Path = "/"
-		s.cookie.HttpOnly = true
-		http.SetCookie(s.resp, s.cookie)
-		return nil
+	if !logged {
+		log.Printf("ERROR invalid user/password received: %s/***", loginName)
+		return errors.New("Invalid user/password received")
 	}
-
-	log.Printf("ERROR invalid user/password received: %s/***", loginName)
-	return errors.New("Invalid user/password received")
+	return nil
 }
 
-func (s *session) loginTicket(ticketID string) error {
-	// Tickets are password-less users with a very short lifespan.
-	logged, err := models.LoginTicket(ticketID)
+// Starts a new session for a user that has already been validated.
+func (s *session) startSession(loginName string) error {
+	userSession, err := models.NewUserSession(loginName, s.req.UserAgent(), remoteIp(s.req))
 	if err != nil {
+		log.Printf("ERROR creating new session: %s", err)
 		return err
 	}
 
-	if logged {
-		userSession, err := models.NewTicketSession(ticketID)
-		if err != nil {
-			log.Printf("ERROR creating new ticket session: %s", err)
-			return err
-		}
-
-		s.loginName = userSession.Login
-		s.sessionId = userSession.SessionId
-		s.cookie = &http.Cookie{Name: "ticketId"}
-		s.cookie.Value = s.sessionId
-		s.cookie.Expires = userSession.ExpiresOn
-		s.cookie.Path = "/"
-		s.cookie.HttpOnly = true
-		http.SetCookie(s.resp, s.cookie)
-		return nil
-	}
+	s.loginName = userSession.Login
+	s.sessionId = userSession.SessionId
+	s.userType = userSession.UserType
+	s.setSessionCookie(userSession.ExpiresOn)
+	s.csrfKey = s.sessionId
+	return nil
+}
 
-	log.Printf("ERROR invalid ticket ID received: %s", ticketID)
-	return errors.New("Invalid ticket ID received")
+func (s *session) setSessionCookie(expiresOn time.Time) {
+	s.cookie = &http.Cookie{Name: "sessionId"}
+	s.cookie.Value = s.sessionId
+	s.cookie.Expires = expiresOn
+	setCookie(s.resp, s.req, s.cookie)
 }
 
 func (s session) isAuth() bool {
@@ -149,14 +209,41 @@ func (s session) isAdmin() bool {
 	return s.userType == "admin"
 }
 
-func (s session) isGuest() bool {
-	return s.userType == "guest"
+// Returns the role used to decide what blogs the user can see. Access
+// links to the whole site see what family members see.
+func (s session) role() string {
+	if s.isAuth() {
+		if s.userType == "guest" {
+			return models.RoleFamily
+		}
+		return s.userType
+	}
+	if s.hasLink() && s.link.Scope == models.AccessLinkSite {
+		return models.RoleFamily
+	}
+	return models.RolePublic
+}
+
+func (s session) canEdit() bool {
+	return s.isAuth() && models.RoleCanEdit(s.userType)
+}
+
+func (s session) hasLink() bool {
+	return s.link.Id != ""
 }
 
 // Provide toViewModel() here since this type does not have
 // a model per-se.
 func (s session) toViewModel() viewModels.Session {
-	return viewModels.NewSession(
-		s.sessionId, s.loginName,
-		s.isAdmin(), s.isGuest())
+	vm := viewModels.NewSession(s.sessionId, s.loginName, s.role())
+	vm.CsrfToken = s.csrfToken()
+	vm.PublicMode = publicMode
+	vm.SiteName = site.Name
+	vm.ContactEmail = site.ContactEmail
+	vm.BaseUrl = baseUrl(s.req)
+	return vm
+}
+
+func (s session) csrfToken() string {
+	return csrfToken(s.csrfKey)
 }
@@ -0,0 +1,77 @@
+package web
+
+import (
+	"net/http/httptest"
+	"testing"
+
+	"hectorcorrea.com/hk/models"
+)
+
+func TestBearerToken(t *testing.T) {
+	tests := []struct {
+		header string
+		token  string
+	}{
+		{"", ""},
+		{"Bearer hk_abc", "hk_abc"},
+		{"bearer  hk_abc ", "hk_abc"},
+		{"Basic dXNlcjpwYXNz", ""},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest("GET", "/", nil)
+		if test.header != "" {
+			req.Header.Set("Authorization", test.header)
+		}
+		if token := bearerToken(req); token != test.token {
+			t.Errorf("Unexpected token for %q: %q", test.header, token)
+		}
+	}
+}
+
+func TestRequiredScope(t *testing.T) {
+	tests := []struct {
+		method string
+		url    string
+		scope  string
+	}{
+		{"GET", "/2019/some-post/123", models.ScopeRead},
+		{"HEAD", "/photos/2019/a.jpg", models.ScopeRead},
+		{"POST", "/2019/some-post/123/save", models.ScopeWrite},
+		{"POST", "/photos/2019/a.jpg", models.ScopeUpload},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(test.method, test.url, nil)
+		if scope := requiredScope(req); scope != test.scope {
+			t.Errorf("Unexpected scope for %s %s: %s", test.method, test.url, scope)
+		}
+	}
+}
+
+func TestBearerRejectedByAdminRoutes(t *testing.T) {
+	admin := session{loginName: "user1", userType: "admin"}
+	bearer := session{loginName: "user1", userType: "admin", bearer: true}
+
+	tests := []struct {
+		router *Router
+		method string
+		url    string
+	}{
+		{&userRouter, "GET", "/users"},
+		{&userRouter, "POST", "/users/new"},
+		{&userRouter, "POST", "/users/1/password"},
+		{&linkRouter, "GET", "/links"},
+		{&linkRouter, "POST", "/links/new"},
+		{&inviteRouter, "POST", "/invites/new"},
+		{&auditRouter, "GET", "/audit/export"},
+	}
+	for _, test := range tests {
+		if _, allowed := routeAllowed(test.router, admin, test.method, test.url); !allowed {
+			t.Errorf("Admin should be allowed: %s %s", test.method, test.url)
+		}
+		if _, allowed := routeAllowed(test.router, bearer, test.method, test.url); allowed {
+			t.Errorf("Bearer token should be rejected: %s %s", test.method, test.url)
+		}
+	}
+}
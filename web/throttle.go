@@ -0,0 +1,166 @@
+package web
+
+import (
+	"log"
+	"math"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
+// Throttles the login attempts per login and per IP: every failed
+// attempt doubles the time the client has to wait before trying again
+// and after too many failures the login (or IP) is locked out for a
+// while.
+type loginThrottle struct {
+	store          models.LoginAttemptStore
+	window         time.Duration // failures older than this are ignored
+	baseDelay      time.Duration
+	maxDelay       time.Duration
+	lockoutAfter   int // failures for the same login
+	ipLockoutAfter int // failures from the same IP (for any login)
+	lockoutFor     time.Duration
+	mutex          *sync.Mutex // see Attempt
+}
+
+var throttle loginThrottle
+
+// Password reset requests have their own counters (always in memory)
+// so that they don't slow down or lock out the logins from the same IP.
+var resetThrottle loginThrottle
+
+func initLoginThrottle(kind string) {
+	log.Printf("Login attempts store: %s", kind)
+	throttle = newLoginThrottle(models.NewLoginAttemptStore(kind))
+	resetThrottle = newLoginThrottle(&models.MemoryLoginAttempts{})
+}
+
+func newLoginThrottle(store models.LoginAttemptStore) loginThrottle {
+	return loginThrottle{
+		store:          store,
+		window:         time.Hour,
+		baseDelay:      time.Second,
+		maxDelay:       5 * time.Minute,
+		lockoutAfter:   10,
+		ipLockoutAfter: 50,
+		lockoutFor:     15 * time.Minute,
+		mutex:          &sync.Mutex{},
+	}
+}
+
+// Reserves an attempt for the login and IP: if the client does not
+// have to wait the attempt is recorded (as if it had failed) before
+// the password is checked, so that a burst of parallel requests
+// cannot all get through before their failures are recorded. Call
+// Succeeded once the user is logged in to clear it.
+func (t loginThrottle) Attempt(login, ip string, now time.Time) (time.Duration, bool) {
+	if t.store == nil {
+		return 0, false
+	}
+
+	t.mutex.Lock()
+	defer t.mutex.Unlock()
+	if wait, locked := t.Wait(login, ip, now); wait > 0 {
+		return wait, locked
+	}
+	t.Failed(login, ip, now)
+	return 0, false
+}
+
+// Returns how long the client has to wait before it can try to login
+// again (zero if it can try now) and whether it has been locked out.
+func (t loginThrottle) Wait(login, ip string, now time.Time) (time.Duration, bool) {
+	if t.store == nil {
+		return 0, false
+	}
+
+	since := now.Add(-t.window)
+	count, last, err := t.store.CountByLogin(login, since)
+	if err != nil {
+		log.Printf("Error fetching login attempts for %s: %s", login, err)
+	}
+	wait, locked := t.backoff(count, t.lockoutAfter, last, now)
+
+	count, last, err = t.store.CountByIp(ip, since)
+	if err != nil {
+		log.Printf("Error fetching login attempts for %s: %s", ip, err)
+	}
+	ipWait, ipLocked := t.backoff(count, t.ipLockoutAfter, last, now)
+	if ipWait > wait {
+		wait = ipWait
+	}
+	return wait, locked || ipLocked
+}
+
+// Exponential backoff: 1s, 2s, 4s, ... up to maxDelay after the last
+// failure and a lockout once the number of failures reaches lockoutAfter.
+func (t loginThrottle) backoff(failures int, lockoutAfter int, last time.Time, now time.Time) (time.Duration, bool) {
+	if failures == 0 {
+		return 0, false
+	}
+
+	if failures >= lockoutAfter {
+		wait := last.Add(t.lockoutFor).Sub(now)
+		return wait, wait > 0
+	}
+
+	delay := time.Duration(float64(t.baseDelay) * math.Pow(2, float64(failures-1)))
+	if delay > t.maxDelay {
+		delay = t.maxDelay
+	}
+	wait := last.Add(delay).Sub(now)
+	if wait < 0 {
+		wait = 0
+	}
+	return wait, false
+}
+
+func (t loginThrottle) Failed(login, ip string, now time.Time) {
+	if t.store == nil {
+		return
+	}
+	attempt := models.LoginAttempt{Login: login, Ip: ip, AttemptedOn: now.UTC()}
+	if err := t.store.Add(attempt); err != nil {
+		log.Printf("Error recording failed login attempt for %s: %s", login, err)
+	}
+}
+
+func (t loginThrottle) Succeeded(login string) {
+	if t.store == nil {
+		return
+	}
+	if err := t.store.Clear(login); err != nil {
+		log.Printf("Error clearing login attempts for %s: %s", login, err)
+	}
+}
+
+// Returns the IP of the client. When we are behind a reverse proxy
+// running on the same machine (e.g. nginx) we use the IP that the
+// proxy added to X-Forwarded-For.
+func remoteIp(req *http.Request) string {
+	forwarded := req.Header.Get("X-Forwarded-For")
+	if fromLocalProxy(req) && forwarded != "" {
+		ips := strings.Split(forwarded, ",")
+		return strings.TrimSpace(ips[len(ips)-1])
+	}
+	return remoteHost(req)
+}
+
+// True if the request comes from the same machine, in which case we
+// assume it is our reverse proxy and trust its X-Forwarded-* headers.
+func fromLocalProxy(req *http.Request) bool {
+	ip := net.ParseIP(remoteHost(req))
+	return ip != nil && ip.IsLoopback()
+}
+
+func remoteHost(req *http.Request) string {
+	host, _, err := net.SplitHostPort(req.RemoteAddr)
+	if err != nil {
+		return req.RemoteAddr
+	}
+	return host
+}
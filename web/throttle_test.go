@@ -0,0 +1,105 @@
+package web
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"hectorcorrea.com/hk/models"
+)
+
+func TestLoginThrottleBackoff(t *testing.T) {
+	throttle := newLoginThrottle(&models.MemoryLoginAttempts{})
+	now := time.Now()
+
+	if wait, locked := throttle.Wait("user1", "10.0.0.1", now); wait != 0 || locked {
+		t.Errorf("Unexpected wait without failures: %s, %t", wait, locked)
+	}
+
+	throttle.Failed("user1", "10.0.0.1", now)
+	throttle.Failed("user1", "10.0.0.1", now)
+	throttle.Failed("user1", "10.0.0.1", now)
+	if wait, locked := throttle.Wait("user1", "10.0.0.2", now); wait != 4*time.Second || locked {
+		t.Errorf("Unexpected wait after 3 failures: %s, %t", wait, locked)
+	}
+
+	later := now.Add(5 * time.Second)
+	if wait, locked := throttle.Wait("user1", "10.0.0.2", later); wait != 0 || locked {
+		t.Errorf("Unexpected wait after the backoff: %s, %t", wait, locked)
+	}
+
+	throttle.Succeeded("user1")
+	if wait, _ := throttle.Wait("user1", "10.0.0.2", now); wait != 0 {
+		t.Errorf("Unexpected wait after a successful login: %s", wait)
+	}
+}
+
+func TestLoginThrottleLockout(t *testing.T) {
+	throttle := newLoginThrottle(&models.MemoryLoginAttempts{})
+	now := time.Now()
+	for i := 0; i < throttle.lockoutAfter; i++ {
+		throttle.Failed("user1", "10.0.0.1", now)
+	}
+
+	wait, locked := throttle.Wait("user1", "10.0.0.2", now.Add(time.Minute))
+	if !locked || wait != throttle.lockoutFor-time.Minute {
+		t.Errorf("Unexpected lockout: %s, %t", wait, locked)
+	}
+
+	if _, locked := throttle.Wait("user1", "10.0.0.2", now.Add(throttle.lockoutFor)); locked {
+		t.Errorf("Lockout did not expire")
+	}
+
+	// Failures for other logins count against the IP
+	if wait, _ := throttle.Wait("user2", "10.0.0.1", now); wait == 0 {
+		t.Errorf("Failures from the same IP were not throttled")
+	}
+}
+
+func TestLoginThrottleAttempt(t *testing.T) {
+	throttle := newLoginThrottle(&models.MemoryLoginAttempts{})
+	now := time.Now()
+
+	// Only one of a burst of parallel attempts gets through.
+	allowed := make(chan bool, 10)
+	var wg sync.WaitGroup
+	for i := 0; i < 10; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			wait, _ := throttle.Attempt("user1", "10.0.0.1", now)
+			allowed <- wait == 0
+		}()
+	}
+	wg.Wait()
+	close(allowed)
+	count := 0
+	for ok := range allowed {
+		if ok {
+			count += 1
+		}
+	}
+	if count != 1 {
+		t.Errorf("Unexpected number of attempts allowed: %d", count)
+	}
+
+	throttle.Succeeded("user1")
+	if wait, _ := throttle.Attempt("user1", "10.0.0.1", now); wait != 0 {
+		t.Errorf("Unexpected wait after a successful login: %s", wait)
+	}
+}
+
+func TestResetThrottleIsSeparate(t *testing.T) {
+	initLoginThrottle("memory")
+	defer func() { throttle, resetThrottle = loginThrottle{}, loginThrottle{} }()
+	now := time.Now()
+	for i := 0; i < 5; i++ {
+		resetThrottle.Attempt("user1", "10.0.0.1", now)
+	}
+	if wait, _ := resetThrottle.Attempt("user1", "10.0.0.1", now); wait == 0 {
+		t.Errorf("Reset requests were not throttled")
+	}
+	if wait, locked := throttle.Wait("user1", "10.0.0.1", now); wait != 0 || locked {
+		t.Errorf("Reset requests should not throttle logins: %s, %t", wait, locked)
+	}
+}
@@ -0,0 +1,75 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strconv"
+
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+func init() {
+	authRouter.Add("GET", "/auth/tokens", handleTokens, PermGuest)
+	authRouter.Add("POST", "/auth/tokens/new", handleTokenNew, PermGuest)
+	authRouter.Add("POST", "/auth/tokens/revoke", handleTokenRevoke, PermGuest)
+}
+
+func handleTokens(s session, values map[string]string) {
+	renderTokens(s, "", "")
+}
+
+func renderTokens(s session, newToken string, message string) {
+	userId, err := models.GetUserId(s.loginName)
+	if err != nil {
+		renderError(s, "Error fetching user", err)
+		return
+	}
+
+	tokens, err := models.ApiTokensGetByUser(userId)
+	if err != nil {
+		renderError(s, "Error fetching API tokens", err)
+		return
+	}
+	vm := viewModels.FromApiTokens(tokens, newToken, message, s.toViewModel())
+	renderTemplate(s, "views/tokens.html", vm)
+}
+
+func handleTokenNew(s session, values map[string]string) {
+	userId, err := models.GetUserId(s.loginName)
+	if err != nil {
+		renderError(s, "Error fetching user", err)
+		return
+	}
+
+	s.req.ParseForm()
+	name := s.req.FormValue("name")
+	scopes := s.req.Form["scope"]
+	days, _ := strconv.Atoi(s.req.FormValue("days"))
+	token, value, err := models.ApiTokenNew(userId, name, scopes, days)
+	if err != nil {
+		renderTokens(s, "", err.Error())
+		return
+	}
+	log.Printf("API token %d created by %s (%v)", token.Id, s.loginName, token.Scopes)
+	s.audit(models.AuditTokenCreate, fmt.Sprintf("%d", token.Id), "", fmt.Sprintf("name=%q scopes=%v", token.Name, token.Scopes))
+	renderTokens(s, value, "")
+}
+
+func handleTokenRevoke(s session, values map[string]string) {
+	userId, err := models.GetUserId(s.loginName)
+	if err != nil {
+		renderError(s, "Error fetching user", err)
+		return
+	}
+
+	id := idFromString(s.req.FormValue("id"))
+	if err := models.ApiTokenRevoke(userId, id); err != nil {
+		renderError(s, "Error revoking API token", err)
+		return
+	}
+	log.Printf("API token %d revoked by %s", id, s.loginName)
+	s.audit(models.AuditTokenRevoke, fmt.Sprintf("%d", id), "", "")
+	http.Redirect(s.resp, s.req, "/auth/tokens", 303)
+}
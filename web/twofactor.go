@@ -0,0 +1,268 @@
+package web
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"math"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	qrcode "github.com/skip2/go-qrcode"
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+// Users that passed the password check but still need to enter a
+// code (or enroll) are tracked in a short lived signed cookie. No
+// session is created until the second step is completed.
+const pendingTwoFactorCookie = "pending2fa"
+const pendingTwoFactorTimeout = 5 * time.Minute
+
+// Returns the page the user must go to after entering a valid password,
+// or "" if no second step is needed.
+func twoFactorStep(login string) (string, error) {
+	totp, err := models.TotpGet(login)
+	if err != nil {
+		return "", err
+	}
+	if totp.Enabled {
+		return "/auth/2fa", nil
+	}
+
+	user, err := models.GetUserInfo(login)
+	if err != nil {
+		return "", err
+	}
+	if models.TotpRequired(user.Type) {
+		return "/auth/2fa/setup", nil
+	}
+	return "", nil
+}
+
+func pendingTwoFactorValue(login string, expires int64) string {
+	data := fmt.Sprintf("%s.%d", base64.RawURLEncoding.EncodeToString([]byte(login)), expires)
+	mac := hmac.New(sha256.New, csrfSecret)
+	mac.Write([]byte("2fa." + data))
+	return data + "." + base64.RawURLEncoding.EncodeToString(mac.Sum(nil))
+}
+
+// Returns the login of the user in the cookie if the cookie has
+// not been tampered with and has not expired.
+func parsePendingTwoFactor(value string, now time.Time) (string, bool) {
+	tokens := strings.Split(value, ".")
+	if len(tokens) != 3 {
+		return "", false
+	}
+	login, err := base64.RawURLEncoding.DecodeString(tokens[0])
+	if err != nil {
+		return "", false
+	}
+	expires, err := strconv.ParseInt(tokens[1], 10, 64)
+	if err != nil || now.Unix() > expires {
+		return "", false
+	}
+	expected := pendingTwoFactorValue(string(login), expires)
+	if !hmac.Equal([]byte(value), []byte(expected)) {
+		return "", false
+	}
+	return string(login), true
+}
+
+func setPendingTwoFactor(s session, login string) {
+	expires := time.Now().Add(pendingTwoFactorTimeout)
+	cookie := &http.Cookie{Name: pendingTwoFactorCookie, Path: "/auth/"}
+	cookie.Value = pendingTwoFactorValue(login, expires.Unix())
+	cookie.Expires = expires
+	setCookie(s.resp, s.req, cookie)
+}
+
+func clearPendingTwoFactor(s session) {
+	cookie := &http.Cookie{Name: pendingTwoFactorCookie, Path: "/auth/"}
+	cookie.Expires = time.Unix(0, 0)
+	setCookie(s.resp, s.req, cookie)
+}
+
+func pendingTwoFactorLogin(s session) (string, bool) {
+	cookie, err := s.req.Cookie(pendingTwoFactorCookie)
+	if err != nil {
+		return "", false
+	}
+	return parsePendingTwoFactor(cookie.Value, time.Now())
+}
+
+// Returns the user to enroll: the logged in user or a user that is
+// required to use two-factor authentication and is half way through
+// the login process.
+func twoFactorUser(s session) (string, bool, bool) {
+	if s.isAuth() {
+		return s.loginName, false, true
+	}
+	if login, ok := pendingTwoFactorLogin(s); ok {
+		return login, true, true
+	}
+	return "", false, false
+}
+
+func handleTwoFactor(s session, values map[string]string) {
+	login, ok := pendingTwoFactorLogin(s)
+	if !ok {
+		http.Redirect(s.resp, s.req, "/auth/login", 302)
+		return
+	}
+	vm := viewModels.NewTwoFactor(login, s.req.URL.Query().Get("url"), "", s.toViewModel())
+	renderTemplate(s, "views/twoFactor.html", vm)
+}
+
+func handleTwoFactorPost(s session, values map[string]string) {
+	login, ok := pendingTwoFactorLogin(s)
+	if !ok {
+		http.Redirect(s.resp, s.req, "/auth/login", 302)
+		return
+	}
+
+	url := s.req.FormValue("url")
+	ip := remoteIp(s.req)
+	if wait, locked := throttle.Attempt(login, ip, time.Now()); wait > 0 {
+		log.Printf("Two-factor THROTTLED for user: %s (IP: %s, locked: %t, wait: %s)", login, ip, locked, wait)
+		s.resp.Header().Set("Retry-After", fmt.Sprintf("%.0f", math.Ceil(wait.Seconds())))
+		s.resp.WriteHeader(http.StatusTooManyRequests)
+		message := fmt.Sprintf("Too many failed attempts, try again in %s.", wait.Round(time.Second))
+		vm := viewModels.NewTwoFactor(login, url, message, s.toViewModel())
+		renderTemplate(s, "views/twoFactor.html", vm)
+		return
+	}
+
+	valid, err := models.TotpVerify(login, s.req.FormValue("code"))
+	if err != nil || !valid {
+		log.Printf("Two-factor FAILED for user: %s (IP: %s) %v", login, ip, err)
+		s.auditAs(login, models.AuditTwoFactorFailed, login, "", "")
+		vm := viewModels.NewTwoFactor(login, url, "Invalid code", s.toViewModel())
+		renderTemplate(s, "views/twoFactor.html", vm)
+		return
+	}
+
+	clearPendingTwoFactor(s)
+	completeLogin(s, login, url)
+}
+
+func handleTwoFactorSetup(s session, values map[string]string) {
+	login, _, ok := twoFactorUser(s)
+	if !ok {
+		renderNotAuthorized(s)
+		return
+	}
+
+	url := s.req.URL.Query().Get("url")
+	renderTwoFactorSetup(s, login, url, "")
+}
+
+func renderTwoFactorSetup(s session, login string, url string, message string) {
+	totp, err := models.TotpGet(login)
+	if err != nil {
+		renderError(s, "Error fetching two-factor settings", err)
+		return
+	}
+	user, err := models.GetUserInfo(login)
+	if err != nil {
+		renderError(s, "Error fetching user", err)
+		return
+	}
+
+	vm := viewModels.NewTwoFactor(login, url, message, s.toViewModel())
+	vm.Enabled = totp.Enabled
+	vm.Required = models.TotpRequired(user.Type)
+	if !totp.Enabled {
+		vm.Secret, err = models.TotpStartEnrollment(login)
+		if err != nil {
+			renderError(s, "Error starting two-factor enrollment", err)
+			return
+		}
+	}
+	renderTemplate(s, "views/twoFactorSetup.html", vm)
+}
+
+// Renders the QR code with the secret being enrolled.
+func handleTwoFactorQr(s session, values map[string]string) {
+	login, _, ok := twoFactorUser(s)
+	if !ok {
+		http.Error(s.resp, "Not authorized", http.StatusUnauthorized)
+		return
+	}
+
+	totp, err := models.TotpGet(login)
+	if err != nil || totp.Enabled || totp.Secret == "" {
+		http.NotFound(s.resp, s.req)
+		return
+	}
+
+	png, err := qrcode.Encode(models.TotpUri(totp.Secret, login), qrcode.Medium, 256)
+	if err != nil {
+		http.Error(s.resp, "Error creating QR code", http.StatusInternalServerError)
+		return
+	}
+	s.resp.Header().Set("Content-Type", "image/png")
+	s.resp.Header().Set("Cache-Control", "no-store")
+	s.resp.Write(png)
+}
+
+func handleTwoFactorSetupPost(s session, values map[string]string) {
+	login, pending, ok := twoFactorUser(s)
+	if !ok {
+		renderNotAuthorized(s)
+		return
+	}
+
+	url := s.req.FormValue("url")
+	codes, err := models.TotpEnable(login, strings.TrimSpace(s.req.FormValue("code")))
+	if err != nil {
+		log.Printf("Two-factor enrollment FAILED for user: %s %s", login, err)
+		renderTwoFactorSetup(s, login, url, err.Error())
+		return
+	}
+	log.Printf("Two-factor enabled for user: %s", login)
+	s.auditAs(login, models.AuditTwoFactorEnable, login, "", "")
+
+	if pending {
+		clearPendingTwoFactor(s)
+		if err := s.startSession(login); err != nil {
+			renderError(s, "Error starting session", err)
+			return
+		}
+		throttle.Succeeded(login)
+		s.audit(models.AuditLoginOk, login, "", "")
+	}
+
+	if url == "" {
+		url = "/"
+	}
+	vm := viewModels.NewTwoFactor(login, url, "", s.toViewModel())
+	vm.Enabled = true
+	vm.RecoveryCodes = codes
+	renderTemplate(s, "views/twoFactorSetup.html", vm)
+}
+
+func handleTwoFactorDisable(s session, values map[string]string) {
+	if models.TotpRequired(s.userType) {
+		renderTwoFactorSetup(s, s.loginName, "", "Two-factor authentication is required for your account.")
+		return
+	}
+
+	valid, err := models.TotpVerify(s.loginName, s.req.FormValue("code"))
+	if err != nil || !valid {
+		renderTwoFactorSetup(s, s.loginName, "", "Invalid code")
+		return
+	}
+
+	if err := models.TotpDisable(s.loginName); err != nil {
+		renderError(s, "Error disabling two-factor authentication", err)
+		return
+	}
+	log.Printf("Two-factor disabled for user: %s", s.loginName)
+	s.audit(models.AuditTwoFactorDisable, s.loginName, "", "")
+	http.Redirect(s.resp, s.req, "/auth/2fa/setup", 303)
+}
@@ -0,0 +1,31 @@
+package web
+
+import (
+	"testing"
+	"time"
+)
+
+func TestPendingTwoFactor(t *testing.T) {
+	csrfSecret = []byte("secret")
+	now := time.Now()
+	value := pendingTwoFactorValue("user1", now.Add(time.Minute).Unix())
+
+	login, ok := parsePendingTwoFactor(value, now)
+	if !ok || login != "user1" {
+		t.Errorf("Valid value rejected: %s", value)
+	}
+
+	if _, ok := parsePendingTwoFactor(value, now.Add(2*time.Minute)); ok {
+		t.Errorf("Expired value accepted")
+	}
+
+	other := pendingTwoFactorValue("user2", now.Add(time.Minute).Unix())
+	tampered := other[0:len(other)-43] + value[len(value)-43:]
+	if _, ok := parsePendingTwoFactor(tampered, now); ok {
+		t.Errorf("Tampered value accepted: %s", tampered)
+	}
+
+	if _, ok := parsePendingTwoFactor("", now); ok {
+		t.Errorf("Empty value accepted")
+	}
+}
@@ -0,0 +1,213 @@
+package web
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"strings"
+
+	"hectorcorrea.com/hk/models"
+	"hectorcorrea.com/hk/viewModels"
+)
+
+var userRouter Router
+
+func init() {
+	// Users are managed by admins only.
+	userRouter.Use(logRequest, noBearer, csrf)
+	userRouter.Add("GET", "/users", userViewAll, PermAdmin)
+	userRouter.Add("POST", "/users/new", userNew, PermAdmin)
+	userRouter.Add("GET", "/users/:id", userViewOne, PermAdmin)
+	userRouter.Add("POST", "/users/:id/type", userSetType, PermAdmin)
+	userRouter.Add("POST", "/users/:id/disable", userDisable, PermAdmin)
+	userRouter.Add("POST", "/users/:id/enable", userEnable, PermAdmin)
+	userRouter.Add("POST", "/users/:id/delete", userDelete, PermAdmin)
+	userRouter.Add("POST", "/users/:id/password", userResetPassword, PermAdmin)
+	userRouter.Add("POST", "/users/:id/email", userSetEmail, PermAdmin)
+	userRouter.Add("POST", "/users/:id/signout", userSignOut, PermAdmin)
+}
+
+func userViewAll(s session, values map[string]string) {
+	renderUsers(s, "")
+}
+
+func renderUsers(s session, message string) {
+	users, err := models.UserGetAll()
+	if err != nil {
+		renderError(s, "Error fetching users", err)
+		return
+	}
+	vm := viewModels.FromUsers(users, message, s.toViewModel())
+	renderTemplate(s, "views/users.html", vm)
+}
+
+func userViewOne(s session, values map[string]string) {
+	renderUser(s, idFromString(values["id"]), "")
+}
+
+func renderUser(s session, id int64, message string) {
+	user, err := models.UserGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Fetching user %d", id), err)
+		return
+	}
+
+	sessions, err := models.UserSessionsGetByUser(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Fetching sessions for user %d", id), err)
+		return
+	}
+	vm := viewModels.NewUserView(user, sessions, message, s.toViewModel())
+	renderTemplate(s, "views/userView.html", vm)
+}
+
+func userNew(s session, values map[string]string) {
+	login := strings.TrimSpace(s.req.FormValue("login"))
+	password := s.req.FormValue("password")
+	userType := s.req.FormValue("type")
+	if err := models.UserAdd(login, password, userType); err != nil {
+		log.Printf("Error adding user %s: %s", login, err)
+		renderUsers(s, err.Error())
+		return
+	}
+	log.Printf("User %s (%s) added by %s", login, userType, s.loginName)
+	s.audit(models.AuditUserAdd, login, "", "type="+userType)
+	http.Redirect(s.resp, s.req, "/users", 303)
+}
+
+func userSetType(s session, values map[string]string) {
+	user, ok := userToChange(s, values)
+	if !ok {
+		return
+	}
+
+	userType := s.req.FormValue("type")
+	if err := models.UserSetType(user.Id, userType); err != nil {
+		renderUser(s, user.Id, err.Error())
+		return
+	}
+	log.Printf("User %s changed to %s by %s", user.Login, userType, s.loginName)
+	s.audit(models.AuditUserType, user.Login, "type="+user.Type, "type="+userType)
+	http.Redirect(s.resp, s.req, fmt.Sprintf("/users/%d", user.Id), 303)
+}
+
+func userDisable(s session, values map[string]string) {
+	userSetDisabled(s, values, true)
+}
+
+func userEnable(s session, values map[string]string) {
+	userSetDisabled(s, values, false)
+}
+
+func userSetDisabled(s session, values map[string]string, disabled bool) {
+	user, ok := userToChange(s, values)
+	if !ok {
+		return
+	}
+
+	if err := models.UserSetDisabled(user.Id, disabled); err != nil {
+		renderError(s, fmt.Sprintf("Updating user %d", user.Id), err)
+		return
+	}
+	log.Printf("User %s disabled: %t by %s", user.Login, disabled, s.loginName)
+	action := models.AuditUserEnable
+	if disabled {
+		action = models.AuditUserDisable
+	}
+	s.audit(action, user.Login, fmt.Sprintf("disabled=%t", user.Disabled), fmt.Sprintf("disabled=%t", disabled))
+	http.Redirect(s.resp, s.req, fmt.Sprintf("/users/%d", user.Id), 303)
+}
+
+func userDelete(s session, values map[string]string) {
+	user, ok := userToChange(s, values)
+	if !ok {
+		return
+	}
+
+	if err := models.UserDelete(user.Id); err != nil {
+		renderError(s, fmt.Sprintf("Deleting user %d", user.Id), err)
+		return
+	}
+	log.Printf("User %s deleted by %s", user.Login, s.loginName)
+	s.audit(models.AuditUserDelete, user.Login, "type="+user.Type, "")
+	http.Redirect(s.resp, s.req, "/users", 303)
+}
+
+func userResetPassword(s session, values map[string]string) {
+	id := idFromString(values["id"])
+	user, err := models.UserGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Fetching user %d", id), err)
+		return
+	}
+
+	password := s.req.FormValue("password")
+	if password == "" || password != s.req.FormValue("repeatPassword") {
+		renderUser(s, id, "Password cannot be empty and must match Repeat Password.")
+		return
+	}
+
+	if err := models.SetPassword(user.Login, password); err != nil {
+		renderError(s, fmt.Sprintf("Resetting password for user %d", id), err)
+		return
+	}
+	// Same as when users reset their own password, whoever had it
+	// is logged out.
+	if err := models.DeleteUserSessions(user.Id); err != nil {
+		renderError(s, fmt.Sprintf("Deleting sessions for user %d", id), err)
+		return
+	}
+	log.Printf("Password for user %s reset by %s", user.Login, s.loginName)
+	s.audit(models.AuditUserPassword, user.Login, "", "")
+	renderUser(s, id, "Password was reset and the sessions of the user were ended.")
+}
+
+func userSetEmail(s session, values map[string]string) {
+	id := idFromString(values["id"])
+	user, err := models.UserGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Fetching user %d", id), err)
+		return
+	}
+
+	email := strings.TrimSpace(s.req.FormValue("email"))
+	if err := models.UserSetEmail(id, email); err != nil {
+		renderUser(s, id, err.Error())
+		return
+	}
+	log.Printf("E-mail for user %s changed by %s", user.Login, s.loginName)
+	s.audit(models.AuditUserEmail, user.Login, user.Email, email)
+	http.Redirect(s.resp, s.req, fmt.Sprintf("/users/%d", id), 303)
+}
+
+func userSignOut(s session, values map[string]string) {
+	user, ok := userToChange(s, values)
+	if !ok {
+		return
+	}
+
+	if err := models.DeleteUserSessions(user.Id); err != nil {
+		renderError(s, fmt.Sprintf("Deleting sessions for user %d", user.Id), err)
+		return
+	}
+	log.Printf("Sessions for user %s deleted by %s", user.Login, s.loginName)
+	s.audit(models.AuditUserSignOut, user.Login, "", "")
+	http.Redirect(s.resp, s.req, fmt.Sprintf("/users/%d", user.Id), 303)
+}
+
+// Fetches the user to change. Admins cannot change (e.g. disable or
+// delete) their own account so that they cannot lock themselves out.
+func userToChange(s session, values map[string]string) (models.User, bool) {
+	id := idFromString(values["id"])
+	user, err := models.UserGetById(id)
+	if err != nil {
+		renderError(s, fmt.Sprintf("Fetching user %d", id), err)
+		return user, false
+	}
+
+	if user.Login == s.loginName {
+		renderUser(s, id, "You cannot change your own account.")
+		return user, false
+	}
+	return user, true
+}
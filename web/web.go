@@ -5,33 +5,128 @@ import (
 	"fmt"
 	"html/template"
 	"log"
+	"net"
 	"net/http"
+	"os"
+	"os/signal"
 	"strings"
+	"syscall"
 	"time"
 
+	"hectorcorrea.com/hk/config"
+	"hectorcorrea.com/hk/mailer"
 	"hectorcorrea.com/hk/models"
 	"hectorcorrea.com/hk/viewModels"
 )
 
-func StartWebServer(address string) {
-	log.Printf("Listening for requests at %s\n", "http://"+address)
+// In public mode anonymous users can see the list of public posts, the
+// feed, and search engines are allowed.
+var publicMode bool
 
-	if err := models.InitDB(); err != nil {
+// Name and canonical URL of the site (see baseUrl).
+var site config.Site
+
+func StartWebServer(c config.Config) {
+	options := newServerConfig(c.Server)
+	log.Printf("Listening for requests at %s\n", options.url())
+
+	if err := models.InitDB(c); err != nil {
 		log.Print("ERROR: Failed to initialize database: ", err)
 	}
 	log.Printf("Database: %s", models.DbConnStringSafe())
+	initLoginThrottle(c.Auth.LoginAttemptsStore)
+	initCsrf(c.Auth.CsrfSecret)
+	mail = mailer.New(c.Mail)
+	site = c.Site
+	if site.BaseUrl != "" {
+		log.Printf("Base URL: %s", site.BaseUrl)
+		initOidc(c.Oidc, site.BaseUrl)
+	} else {
+		log.Printf("WARNING: site.base_url is not set, password reset e-mails are disabled")
+		initOidc(c.Oidc, options.url())
+	}
+	initSecurityHeaders(c.Security, c.Map)
+	publicMode = c.Server.PublicMode
+	if publicMode {
+		log.Printf("Public mode: anonymous users can see public posts")
+	}
+	if c.Auth.LinkSecret == "" {
+		log.Printf("WARNING: auth.link_secret is not set, access links are signed with an empty secret")
+	}
 
 	fs := http.FileServer(http.Dir("./public"))
 	http.Handle("/favicon.ico", fs)
-	http.Handle("/robots.txt", fs)
+	http.HandleFunc("/robots.txt", robotsTxt)
 	http.Handle("/public/", http.StripPrefix("/public/", fs))
-	http.HandleFunc("/auth/", authPages)
-	http.HandleFunc("/", blogPages)
+	if models.PhotoFolder() != "" {
+		log.Printf("Serving photos from: %s", models.PhotoFolder())
+	}
+	for _, mount := range mounts {
+		http.Handle(mount.pattern, mount.router)
+	}
+	if c.Server.LogRoutes {
+		logRoutes()
+	}
 
-	err := http.ListenAndServe(address, nil)
+	listener, err := net.Listen("tcp", options.address)
 	if err != nil {
 		log.Fatal("Failed to start the web server: ", err)
 	}
+	stopCleanup := startJob("cleanup", time.Duration(c.Server.CleanupMinutes)*time.Minute, cleanExpired)
+
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	server := newServer(options, securityHeaders(securityPolicy, http.DefaultServeMux))
+	if err := serve(server, listener, options, signals); err != nil {
+		log.Print("ERROR: ", err)
+	}
+
+	stopCleanup()
+	if err := models.CloseDB(); err != nil {
+		log.Print("ERROR: Closing the database: ", err)
+	}
+	log.Printf("Web server stopped")
+}
+
+// Where each router is mounted. Routers are only registered from here
+// so that the route table (and the permission checks in the tests)
+// cover all of them.
+var mounts = []struct {
+	pattern string
+	router  *Router
+}{
+	{"/auth/", &authRouter},
+	{"/albums/", &albumRouter},
+	{"/photos/", &photoRouter},
+	{"/links", &linkRouter},
+	{"/links/", &linkRouter},
+	{"/users", &userRouter},
+	{"/users/", &userRouter},
+	{"/invites", &inviteRouter},
+	{"/invites/", &inviteRouter},
+	{"/audit", &auditRouter},
+	{"/audit/", &auditRouter},
+	{"/", &blogRouter},
+}
+
+// The mounted routers, each one once.
+func routers() []*Router {
+	list := []*Router{}
+	seen := map[*Router]bool{}
+	for _, mount := range mounts {
+		if !seen[mount.router] {
+			seen[mount.router] = true
+			list = append(list, mount.router)
+		}
+	}
+	return list
+}
+
+// Logs the routes of each router, with their handler and middleware.
+func logRoutes() {
+	for _, router := range routers() {
+		log.Printf("Routes:\n%s", router.RouteTable())
+	}
 }
 
 func cacheResponse(resp http.ResponseWriter) {
@@ -67,16 +162,32 @@ func renderNotAuthorized(s session) {
 		log.Printf("Rendered login in Spanish")
 	}
 
+	if s.bearer {
+		s.resp.Header().Set("WWW-Authenticate", `Bearer realm="hk"`)
+	}
 	t, err := template.New("layout").ParseFiles("views/layout.html", viewName)
 	if err != nil {
 		log.Printf("Error rendering not authorized page :(")
-		// perhaps render a hard coded string?
+		http.Error(s.resp, "Not authorized", http.StatusUnauthorized)
 	} else {
 		s.resp.WriteHeader(http.StatusUnauthorized)
 		t.Execute(s.resp, vm)
 	}
 }
 
+func renderForbidden(s session, title string) {
+	log.Printf("Forbidden: %s %s (%s)", s.req.Method, s.req.URL.Path, s.loginName)
+	vm := viewModels.NewErrorFromStr(title, "", s.toViewModel())
+	t, err := template.New("layout").ParseFiles("views/layout.html", "views/error.html")
+	if err != nil {
+		log.Printf("Error rendering forbidden page :(")
+		http.Error(s.resp, title, http.StatusForbidden)
+	} else {
+		s.resp.WriteHeader(http.StatusForbidden)
+		t.Execute(s.resp, vm)
+	}
+}
+
 func renderError(s session, title string, err error) {
 	// I don't like that we have a reference to sql in here.
 	// The web should not be aware of the DB.